@@ -8,6 +8,7 @@ import (
 	"github.com/algolia/algoliasearch-client-go/v3/algolia/search"
 	"github.com/mailgun/mailgun-go/v4"
 	allris_common "github.com/rismaster/allris-common"
+	"github.com/rismaster/allris-common/common/mail"
 	"github.com/rismaster/allris-common/common/slog"
 	"github.com/rismaster/allris-common/downloader"
 )
@@ -20,6 +21,7 @@ type AppContext struct {
 	publisher       *pubsub.Client
 	datastoreClient *datastore.Client
 	mailer          *mailgun.MailgunImpl
+	mailTransport   mail.Mailer
 
 	searchClient *search.Client
 	searchIndex  *search.Index
@@ -52,6 +54,23 @@ func (app *AppContext) Mail() *mailgun.MailgunImpl {
 	return app.mailer
 }
 
+// Mailer returns the mail transport selected by Config.GetMailTransport (mailgun is default),
+// messages without From are sent from Config.GetMailFrom
+func (app *AppContext) Mailer() mail.Mailer {
+	if app.mailTransport == nil {
+		switch app.Config.GetMailTransport() {
+		case mail.TransportSmtp:
+			app.mailTransport = mail.NewSmtpMailer(app.Config.GetMailSmtpHost(), app.Config.GetMailSmtpPort(), app.Config.GetMailSmtpUser(), app.Config.GetMailSmtpPassword())
+		case mail.TransportFile:
+			app.mailTransport = mail.NewFileMailer(app.Config.GetMailDropFolder())
+		default:
+			app.mailTransport = &mail.MailgunMailer{Mg: app.Mail()}
+		}
+		app.mailTransport = mail.WithDefaultFrom(app.mailTransport, app.Config.GetMailFrom())
+	}
+	return app.mailTransport
+}
+
 func (app *AppContext) Db() *datastore.Client {
 	if app.datastoreClient == nil {
 		var err error
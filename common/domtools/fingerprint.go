@@ -0,0 +1,98 @@
+package domtools
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/rismaster/allris-common/common"
+	"golang.org/x/net/html"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// fingerprintVersion is part of every fingerprint, change it if the normalisation changes
+const fingerprintVersion = "v2"
+
+// volatileParams are query parameters and form inputs changing without a change of the content
+var volatileParams = map[string]bool{
+	"sid":       true,
+	"sessionid": true,
+	"session":   true,
+	"token":     true,
+	"t":         true,
+	"ts":        true,
+	"timestamp": true,
+	"random":    true,
+}
+
+// Fingerprint returns the sha256 of the normalised content of the allris container (the body if there is none):
+// texts with collapsed whitespace, links and forms with their target and their non volatile parameters,
+// scripts, styles and comments are ignored. dpage hashes pages by their parsed fields, this is the hash of pages
+// without a registered parser and of the stored pages to migrate
+func Fingerprint(doc *goquery.Document) string {
+
+	root := doc.Find("#allriscontainer")
+	if root.Length() == 0 {
+		root = doc.Find("body")
+	}
+
+	parts := []string{fingerprintVersion}
+	for _, n := range root.Nodes {
+		parts = appendNormalised(parts, n)
+	}
+	return common.Sha256HashB([]byte(strings.Join(parts, "\n")))
+}
+
+func appendNormalised(parts []string, n *html.Node) []string {
+
+	switch n.Type {
+	case html.TextNode:
+		if t := CleanText(n.Data); t != "" {
+			parts = append(parts, t)
+		}
+		return parts
+	case html.CommentNode:
+		return parts
+	case html.ElementNode:
+		switch n.Data {
+		case "script", "style", "noscript":
+			return parts
+		case "a":
+			if href := GetAttrFromNode(n, "href"); href != "" {
+				parts = append(parts, "a:"+normaliseLink(href))
+			}
+		case "form":
+			parts = append(parts, "form:"+normaliseLink(GetAttrFromNode(n, "action")))
+		case "input":
+			name := GetAttrFromNode(n, "name")
+			if name != "" && !volatileParams[strings.ToLower(name)] {
+				parts = append(parts, "input:"+name+"="+GetAttrFromNode(n, "value"))
+			}
+		}
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		parts = appendNormalised(parts, c)
+	}
+	return parts
+}
+
+// normaliseLink returns the path with the sorted non volatile query parameters
+func normaliseLink(link string) string {
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+
+	var params []string
+	for k, values := range u.Query() {
+		if volatileParams[strings.ToLower(k)] {
+			continue
+		}
+		for _, v := range values {
+			params = append(params, k+"="+v)
+		}
+	}
+	sort.Strings(params)
+	return u.Path + "?" + strings.Join(params, "&")
+}
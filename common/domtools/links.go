@@ -0,0 +1,12 @@
+package domtools
+
+import "regexp"
+
+// RegexAnwesenheitLink matches the link of a Sitzung to its attendance page (to0045, to0050)
+var RegexAnwesenheitLink = regexp.MustCompile(`^to00(45|50)\.asp\?`)
+
+// RegexPersonLink matches the link to a Person (kp0050), the group is the KPLFDNR
+var RegexPersonLink = regexp.MustCompile(`kp0050\.asp\?.*KPLFDNR=([0-9]+)`)
+
+// RegexFraktionLink matches the link to a Fraktion (pa021), the group is the PALFDNR
+var RegexFraktionLink = regexp.MustCompile(`pa021\.asp\?.*PALFDNR=([0-9]+)`)
@@ -0,0 +1,82 @@
+package extract
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Betrag is an amount in Euro found in a text
+type Betrag struct {
+	Text  string //the matched text, e.g. "1,5 Mio. €"
+	Wert  float64
+	Start int //byte offsets of Text
+	Ende  int
+}
+
+const regexZahl = `\d{1,3}(?:\.\d{3})+(?:,\d{1,2})?|\d+(?:,\d{1,2})?`
+const regexFaktor = `(?:\s?(Mrd\.?|Milliarden|Mio\.?|Millionen|Tsd\.?|Tausend|T))?`
+const regexWaehrung = `(?:€|EUR|Euro)`
+
+// regexBetrag matches "1.234,56 €", "1,5 Mio. Euro", "15 TEUR", "€ 1.000,-" and "EUR 200"
+var regexBetrag = regexp.MustCompile(`(?:(` + regexZahl + `)(?:,-{1,2})?` + regexFaktor + `\s?` + regexWaehrung + `|` + regexWaehrung + `\s?(` + regexZahl + `)(?:,-{1,2})?` + regexFaktor + `)`)
+
+// FindBetraege returns all amounts in Euro in the order of the text
+func FindBetraege(text string) []Betrag {
+
+	var result []Betrag
+	for _, m := range regexBetrag.FindAllStringSubmatchIndex(text, -1) {
+
+		zahl, faktor := group(text, m, 1), group(text, m, 2)
+		if zahl == "" {
+			zahl, faktor = group(text, m, 3), group(text, m, 4)
+		}
+
+		wert, ok := ParseZahl(zahl)
+		if !ok {
+			continue
+		}
+		result = append(result, Betrag{
+			Text:  text[m[0]:m[1]],
+			Wert:  wert * multiplikator(faktor),
+			Start: m[0],
+			Ende:  m[1],
+		})
+	}
+	return result
+}
+
+// ParseEuro returns the first amount in Euro of text
+func ParseEuro(text string) (float64, bool) {
+	betraege := FindBetraege(text)
+	if len(betraege) == 0 {
+		return 0, false
+	}
+	return betraege[0].Wert, true
+}
+
+// ParseZahl parse a number in german format, e.g. "1.234,56"
+func ParseZahl(zahl string) (float64, bool) {
+	zahl = strings.Replace(strings.Replace(zahl, ".", "", -1), ",", ".", 1)
+	wert, err := strconv.ParseFloat(zahl, 64)
+	return wert, err == nil
+}
+
+func group(text string, m []int, n int) string {
+	if m[2*n] < 0 {
+		return ""
+	}
+	return text[m[2*n]:m[2*n+1]]
+}
+
+func multiplikator(faktor string) float64 {
+	switch strings.TrimSuffix(faktor, ".") {
+	case "Mrd", "Milliarden":
+		return 1e9
+	case "Mio", "Millionen":
+		return 1e6
+	case "Tsd", "Tausend", "T":
+		return 1e3
+	}
+	return 1
+}
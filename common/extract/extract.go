@@ -0,0 +1,232 @@
+package extract
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Result are the named entities found in a text, every list is sorted and without duplicates
+type Result struct {
+	Strassen    []string //street names, e.g. "Hauptstraße"
+	Adressen    []string //street with house number, e.g. "Hauptstraße 12a"
+	Gemarkungen []string
+	Flurstuecke []string  //e.g. "Gemarkung Altstadt, Flur 3, Flurstück 12/4"
+	BPlaene     []string  //numbers of Bebauungspläne, e.g. "123", "45a"
+	Betraege    []float64 //amounts in Euro
+}
+
+var streetSuffix = `(?:[Ss]tra(?:ß|ss)e|[Ss]tr\.|[Ww]eg|[Aa]llee|[Pp]latz|[Gg]asse|[Rr]ing|[Dd]amm|[Uu]fer|[Cc]haussee|[Pp]fad|[Ss]teig|[Mm]arkt|[Hh]of|[Kk]amp|[Ww]all)`
+
+// regexAdresse matches a street with house number, e.g. "Lange Straße 12a" or "Am Markt 1",
+// group 2 is the prefix, group 3 the name before the suffix (see isStrassenName)
+var regexAdresse = regexp.MustCompile(`(((?:Am|An der|Auf dem|Im|In der|Zum|Zur|Alte|Neue|Lange|Kleine|Große|Hohe) )?(\p{Lu}[\p{L}\-]*?)?` + streetSuffix + `)\s+(\d{1,4}(?:\s?[a-z]\b)?(?:\s?-\s?\d{1,4})?)`)
+
+// regexStrasseKompositum matches a name ending with a suffix which is rarely part of other compounds, e.g. "Hauptstraße"
+var regexStrasseKompositum = regexp.MustCompile(`(?:stra(?:ß|ss)e|str\.|allee|gasse|chaussee|damm|ufer)$`)
+
+// isStrassenName returns true if the match of regexAdresse is a street without gazetteer: with a prefix
+// ("Am Markt"), a hyphenated name ("Karl-Marx-Platz") or a compound with a suffix of streets only ("Hauptstraße"),
+// compounds like "Stellplatz" or "Radweg" and the bare suffix ("Markt 2024") are no streets
+func isStrassenName(m []string) bool {
+	praefix, name := m[2], m[3]
+	switch {
+	case praefix != "":
+		return true
+	case name == "":
+		return false
+	case strings.Contains(name, "-"):
+		return true
+	default:
+		return regexStrasseKompositum.MatchString(m[1])
+	}
+}
+
+var regexGemarkung = regexp.MustCompile(`Gemarkung\s+(\p{Lu}[\p{L}\-]+)`)
+var regexFlur = regexp.MustCompile(`\bFlur\s+(?:Nr\.?\s*)?(\d+)`)
+var regexFlurstueck = regexp.MustCompile(`Flurst(?:ü|ue)ck(?:e|s)?\s+(?:Nr\.?\s*)?(\d+(?:/\d+)?(?:\s*(?:,|und|u\.)\s*\d+(?:/\d+)?)*)`)
+var regexFlurstueckNr = regexp.MustCompile(`\d+(?:/\d+)?`)
+
+// regexBPlan matches the number of a Bebauungsplan, e.g. "B-Plan Nr. 123", "Bebauungsplanes Nr. 45 A"
+var regexBPlan = regexp.MustCompile(`(?:B-Plan|B-Plans|Bebauungsplan|Bebauungsplans|Bebauungsplanes|Bebauungspläne)\s+(?:Nr\.?\s*|Nummer\s+)?(\d{1,4}(?:\s?[A-Za-z]\b)?(?:[./-]\d{1,3})?)`)
+
+// flurstueckKontext is the maximal distance of Gemarkung and Flur before a Flurstück
+const flurstueckKontext = 300
+
+// Extractor finds the named entities, streets are only accepted if they are in the gazetteer (if any)
+type Extractor struct {
+	Gazetteer *Gazetteer
+}
+
+func NewExtractor(gazetteer *Gazetteer) *Extractor {
+	return &Extractor{Gazetteer: gazetteer}
+}
+
+var extractorMutex sync.Mutex
+var extractors = make(map[string]*Extractor)
+var extractorErrors = make(map[string]error)
+
+// ForGazetteer returns the extractor with the gazetteer of the local file path (loaded once),
+// without gazetteer if path is empty or not readable, the error of the load is returned on every call
+func ForGazetteer(path string) (*Extractor, error) {
+
+	extractorMutex.Lock()
+	defer extractorMutex.Unlock()
+
+	e, exist := extractors[path]
+	if exist {
+		return e, extractorErrors[path]
+	}
+
+	var err error
+	e = NewExtractor(nil)
+	if path != "" {
+		e.Gazetteer, err = LoadGazetteer(path)
+	}
+	extractors[path] = e
+	extractorErrors[path] = err
+	return e, err
+}
+
+// Extract find the named entities in text
+func (e *Extractor) Extract(text string) *Result {
+
+	r := &Result{}
+
+	for _, m := range regexAdresse.FindAllStringSubmatch(text, -1) {
+		strasse := NormalizeStrasse(m[1])
+		if e.Gazetteer != nil {
+			var known bool
+			strasse, known = e.Gazetteer.Lookup(strasse)
+			if !known {
+				continue
+			}
+		} else if !isStrassenName(m) {
+			continue
+		}
+		r.Strassen = append(r.Strassen, strasse)
+		r.Adressen = append(r.Adressen, strasse+" "+strings.Join(strings.Fields(m[4]), ""))
+	}
+	if e.Gazetteer != nil {
+		r.Strassen = append(r.Strassen, e.Gazetteer.Find(text)...)
+	}
+
+	r.Gemarkungen, r.Flurstuecke = extractFlurstuecke(text)
+
+	for _, m := range regexBPlan.FindAllStringSubmatch(text, -1) {
+		r.BPlaene = append(r.BPlaene, strings.ToLower(strings.Join(strings.Fields(m[1]), "")))
+	}
+
+	for _, b := range FindBetraege(text) {
+		r.Betraege = append(r.Betraege, b.Wert)
+	}
+
+	r.normalize()
+	return r
+}
+
+func extractFlurstuecke(text string) (gemarkungen []string, flurstuecke []string) {
+
+	gemarkungIdx := regexGemarkung.FindAllStringSubmatchIndex(text, -1)
+	for _, m := range gemarkungIdx {
+		gemarkungen = append(gemarkungen, text[m[2]:m[3]])
+	}
+	flurIdx := regexFlur.FindAllStringSubmatchIndex(text, -1)
+
+	for _, m := range regexFlurstueck.FindAllStringSubmatchIndex(text, -1) {
+
+		gemarkung := lastBefore(text, gemarkungIdx, m[0])
+		flur := lastBefore(text, flurIdx, m[0])
+
+		var prefix []string
+		if gemarkung != "" {
+			prefix = append(prefix, "Gemarkung "+gemarkung)
+		}
+		if flur != "" {
+			prefix = append(prefix, "Flur "+flur)
+		}
+		for _, nr := range regexFlurstueckNr.FindAllString(text[m[2]:m[3]], -1) {
+			flurstuecke = append(flurstuecke, strings.Join(append(prefix, "Flurstück "+nr), ", "))
+		}
+	}
+	return gemarkungen, flurstuecke
+}
+
+// lastBefore returns the first group of the last match ending before pos within flurstueckKontext
+func lastBefore(text string, matches [][]int, pos int) string {
+	result := ""
+	for _, m := range matches {
+		if m[1] <= pos && pos-m[1] <= flurstueckKontext {
+			result = text[m[2]:m[3]]
+		}
+	}
+	return result
+}
+
+// NormalizeStrasse write "str." and "strasse" as "straße"
+func NormalizeStrasse(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	for _, suffix := range []string{"str.", "strasse", "Str.", "Strasse"} {
+		if strings.HasSuffix(name, suffix) {
+			replacement := "straße"
+			if strings.HasPrefix(suffix, "S") {
+				replacement = "Straße"
+			}
+			return strings.TrimSuffix(name, suffix) + replacement
+		}
+	}
+	return name
+}
+
+// Merge add the entities of other
+func (r *Result) Merge(other *Result) {
+	if other == nil {
+		return
+	}
+	r.Strassen = append(r.Strassen, other.Strassen...)
+	r.Adressen = append(r.Adressen, other.Adressen...)
+	r.Gemarkungen = append(r.Gemarkungen, other.Gemarkungen...)
+	r.Flurstuecke = append(r.Flurstuecke, other.Flurstuecke...)
+	r.BPlaene = append(r.BPlaene, other.BPlaene...)
+	r.Betraege = append(r.Betraege, other.Betraege...)
+	r.normalize()
+}
+
+func (r *Result) normalize() {
+	r.Strassen = unique(r.Strassen)
+	r.Adressen = unique(r.Adressen)
+	r.Gemarkungen = unique(r.Gemarkungen)
+	r.Flurstuecke = unique(r.Flurstuecke)
+	r.BPlaene = unique(r.BPlaene)
+
+	seen := make(map[float64]bool)
+	var betraege []float64
+	for _, b := range r.Betraege {
+		if !seen[b] {
+			seen[b] = true
+			betraege = append(betraege, b)
+		}
+	}
+	sort.Float64s(betraege)
+	r.Betraege = betraege
+}
+
+func unique(values []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, v := range values {
+		if v != "" && !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (r *Result) String() string {
+	return fmt.Sprintf("%d Adressen, %d Straßen, %d Flurstücke, %d B-Pläne, %d Beträge",
+		len(r.Adressen), len(r.Strassen), len(r.Flurstuecke), len(r.BPlaene), len(r.Betraege))
+}
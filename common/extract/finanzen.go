@@ -0,0 +1,138 @@
+package extract
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Finanzen is the budget data of the Finanzielle Auswirkung of a Vorlage
+type Finanzen struct {
+	Keine          bool    //"keine finanziellen Auswirkungen"
+	Einmalig       float64 //sum of the one-time amounts (or the stated total)
+	Jaehrlich      float64 //sum of the recurring amounts per year
+	Haushaltsjahre []int
+	Produkte       []string
+	Kostenstellen  []string
+}
+
+var regexKeine = regexp.MustCompile(`(?i)keine\s+(?:unmittelbaren\s+|direkten\s+)?(?:finanziellen|haushaltsrelevanten|haushaltsmäßigen)\s+auswirkung`)
+
+// regexKeinFeld matches a Finanzielle Auswirkung answered with "Keine." or "Nein" only
+var regexKeinFeld = regexp.MustCompile(`(?i)^\s*(?:keine|nein)\s*[.!]?\s*$`)
+
+var regexHaushaltsjahr = regexp.MustCompile(`(?i)(?:haushaltsjahr(?:es)?|haushalt(?:s)?|hh\.?|wirtschaftsjahr(?:es)?|im\s+jahr(?:e)?)\s*(20\d\d|19\d\d)`)
+var regexProdukt = regexp.MustCompile(`(?i)produkt(?:nummer|nr\.?)?\s*:?\s*(\d[\d.]{2,}\d)`)
+var regexKostenstelle = regexp.MustCompile(`(?i)kostenst(?:elle|\.)\s*:?\s*(\d{3,})`)
+
+// kontext is the number of bytes before an amount checked for words classifying the amount
+const kontext = 80
+
+var wiederkehrend = []string{"jährlich", "pro jahr", "je jahr", "p.a", "per anno", "laufend", "folgekosten", "monatlich", "pro monat"}
+var gesamt = []string{"gesamt", "insgesamt", "summe"}
+
+// keineAusgabe are words before an amount which is no expense of the Vorlage: budgets, available funds and income
+var keineAusgabe = []string{"ansatz", "verfügbar", "zur verfügung stehende", "ertrag", "erträge", "einnahme", "fördermittel", "förderung", "zuwendung"}
+
+// ParseFinanzen extract the budget data from the text of the Finanzielle Auswirkung
+func ParseFinanzen(text string) *Finanzen {
+
+	f := &Finanzen{Keine: regexKeine.MatchString(text) || regexKeinFeld.MatchString(text)}
+
+	var einmalig, gesamtBetraege []float64
+	for _, b := range FindBetraege(text) {
+		start := b.Start - kontext
+		if start < 0 {
+			start = 0
+		}
+		davor := satzende(strings.ToLower(text[start:b.Start]), true)
+		danach := satzende(strings.ToLower(text[b.Ende:minInt(len(text), b.Ende+30)]), false)
+
+		switch {
+		case containsAny(lastWords(davor, 6), keineAusgabe):
+			continue
+		case containsAny(davor, wiederkehrend) || containsAny(danach, wiederkehrend):
+			wert := b.Wert
+			if containsAny(davor, []string{"monatlich", "pro monat"}) || containsAny(danach, []string{"monatlich", "pro monat"}) {
+				wert = wert * 12
+			}
+			f.Jaehrlich += wert
+		case containsAny(lastWords(davor, 4), gesamt):
+			gesamtBetraege = append(gesamtBetraege, b.Wert)
+		default:
+			einmalig = append(einmalig, b.Wert)
+		}
+	}
+
+	//a stated total replaces the sum of its parts
+	if len(gesamtBetraege) > 0 {
+		sort.Float64s(gesamtBetraege)
+		f.Einmalig = gesamtBetraege[len(gesamtBetraege)-1]
+	} else {
+		for _, w := range einmalig {
+			f.Einmalig += w
+		}
+	}
+
+	jahre := make(map[int]bool)
+	for _, m := range regexHaushaltsjahr.FindAllStringSubmatch(text, -1) {
+		jahr, err := strconv.Atoi(m[1])
+		if err == nil && !jahre[jahr] {
+			jahre[jahr] = true
+			f.Haushaltsjahre = append(f.Haushaltsjahre, jahr)
+		}
+	}
+	sort.Ints(f.Haushaltsjahre)
+
+	for _, m := range regexProdukt.FindAllStringSubmatch(text, -1) {
+		f.Produkte = append(f.Produkte, m[1])
+	}
+	f.Produkte = unique(f.Produkte)
+	for _, m := range regexKostenstelle.FindAllStringSubmatch(text, -1) {
+		f.Kostenstellen = append(f.Kostenstellen, m[1])
+	}
+	f.Kostenstellen = unique(f.Kostenstellen)
+
+	//"keine Auswirkungen auf den Ergebnishaushalt, Investition 10.000 €" has amounts
+	f.Keine = f.Keine && f.Einmalig == 0 && f.Jaehrlich == 0
+	return f
+}
+
+func containsAny(s string, words []string) bool {
+	for _, w := range words {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// satzende returns the part of the context in the same sentence as the amount
+func satzende(s string, davor bool) string {
+	for _, sep := range []string{". ", "\n", ";"} {
+		if davor {
+			if i := strings.LastIndex(s, sep); i >= 0 {
+				s = s[i+len(sep):]
+			}
+		} else if i := strings.Index(s, sep); i >= 0 {
+			s = s[:i]
+		}
+	}
+	return s
+}
+
+func lastWords(s string, n int) string {
+	words := strings.Fields(s)
+	if len(words) > n {
+		words = words[len(words)-n:]
+	}
+	return strings.Join(words, " ")
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,98 @@
+package extract
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// Gazetteer is the list of the streets of the municipality
+type Gazetteer struct {
+	strassen map[string]string //normalised lower case name => name
+	erstes   map[string][]string
+}
+
+// ReadGazetteer read a gazetteer with one street per line, further columns separated by ; are ignored
+func ReadGazetteer(r io.Reader) (*Gazetteer, error) {
+
+	g := &Gazetteer{strassen: make(map[string]string), erstes: make(map[string][]string)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		g.Add(strings.TrimSpace(strings.SplitN(line, ";", 2)[0]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// LoadGazetteer read the gazetteer from a local file
+func LoadGazetteer(path string) (*Gazetteer, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error opening gazetteer %s", path))
+	}
+	defer f.Close()
+	return ReadGazetteer(f)
+}
+
+func (g *Gazetteer) Add(strasse string) {
+	strasse = NormalizeStrasse(strasse)
+	key := strings.ToLower(strasse)
+	if key == "" {
+		return
+	}
+	if _, exist := g.strassen[key]; !exist {
+		first := strings.Fields(key)[0]
+		g.erstes[first] = append(g.erstes[first], key)
+	}
+	g.strassen[key] = strasse
+}
+
+func (g *Gazetteer) Len() int {
+	return len(g.strassen)
+}
+
+// Lookup returns the name of the street as written in the gazetteer
+func (g *Gazetteer) Lookup(strasse string) (string, bool) {
+	name, exist := g.strassen[strings.ToLower(NormalizeStrasse(strasse))]
+	return name, exist
+}
+
+// Find returns the streets of the gazetteer mentioned in text, also without house number
+func (g *Gazetteer) Find(text string) []string {
+
+	words := strings.Fields(strings.NewReplacer(",", " ", ";", " ", "(", " ", ")", " ", "\"", " ").Replace(text))
+	var result []string
+	for i := range words {
+		for _, key := range g.candidates(words[i]) {
+			n := len(strings.Fields(key))
+			if i+n > len(words) {
+				continue
+			}
+			joined := strings.Join(words[i:i+n], " ")
+			if name, exist := g.Lookup(joined); exist && strings.ToLower(name) == key {
+				result = append(result, name)
+			} else if name, exist = g.Lookup(strings.TrimRight(joined, ".:!?")); exist && strings.ToLower(name) == key {
+				result = append(result, name)
+			}
+		}
+	}
+	return result
+}
+
+func (g *Gazetteer) candidates(word string) []string {
+	keys := g.erstes[strings.ToLower(NormalizeStrasse(word))]
+	if trimmed := strings.TrimRight(word, ".:!?"); trimmed != word {
+		keys = append(keys, g.erstes[strings.ToLower(trimmed)]...)
+	}
+	return keys
+}
@@ -0,0 +1,453 @@
+package files
+
+import (
+	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/storage"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/slog"
+	"strings"
+	"time"
+)
+
+// ContentFolder is the folder of the content addressed objects in the content bucket (and their ocr results)
+const ContentFolder = "sha256/"
+
+// metadataRef is set on objects in the fetched bucket referring to the content bucket instead of containing the content
+const metadataRef = "contentRef"
+
+// metadataContentType is the content type of the referenced content, the reference itself is text
+const metadataContentType = "contentType"
+
+// ReferenceContentType is the content type of the references in the fetched bucket, their body is the content path
+const ReferenceContentType = "text/plain; charset=utf-8"
+
+// ContentRef list the paths in the fetched bucket with the same content
+type ContentRef struct {
+	Hash           string
+	ContentType    string
+	Size           int
+	Paths          []string
+	Frueher        []string `datastore:",noindex"` //paths removed, the content is their backup
+	Unreferenziert bool     //no path refers to the content since SavedAt (GCContent)
+	SavedAt        time.Time
+}
+
+// MigrationReport is the result of MigrateContentAddressed
+type MigrationReport struct {
+	DryRun        bool
+	Objekte       int
+	Migriert      []string
+	OcrVerschoben int
+	Fehler        int
+}
+
+func getContentRefKey(app *application.AppContext, hash string) *datastore.Key {
+	return datastore.NameKey(app.Config.GetEntityContentRef(), hash, nil)
+}
+
+// GetContentHash returns the sha256 of the content, empty if the file is not stored content addressed
+func (file *File) GetContentHash() string {
+	return file.contentHash
+}
+
+// GetContentPath returns the path of the content in the content bucket
+func (file *File) GetContentPath() string {
+	if file.contentHash == "" {
+		return ""
+	}
+	return ContentFolder + file.contentHash + strings.ToLower(file.GetExtension())
+}
+
+// GetOcrPrefix returns the prefix of the ocr results of the file, they are shared by all files with the same content
+func (file *File) GetOcrPrefix() string {
+	if file.contentHash == "" {
+		return file.GetPath()
+	}
+	return file.GetContentPath()
+}
+
+// WriteContentAddressed like WriteIfMoreActualAndDifferent, but the content is stored once per sha256
+// in the content bucket, the path is added to its ContentRef and only a reference is written to the path
+func (file *File) WriteContentAddressed(newHash string) error {
+	file.contentAddressed = true
+	return file.WriteIfMoreActualAndDifferent(newHash)
+}
+
+// isContentStored returns true if the stored version of the file exist in the content bucket
+func (file *File) isContentStored() (bool, error) {
+
+	if file.contentHash == "" {
+		return false, nil
+	}
+	_, err := file.app.Store().Bucket(file.app.Config.GetBucketContent()).Object(file.GetContentPath()).Attrs(file.app.Ctx())
+	if err == storage.ErrObjectNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// storeContent write the content to the content bucket if not already there and reference the path
+func (file *File) storeContent() error {
+
+	file.contentHash = common.Sha256HashB(file.content)
+
+	stored, err := file.isContentStored()
+	if err != nil {
+		return err
+	}
+
+	if stored {
+		slog.Info("Content of %s already stored as %s", file.GetPath(), file.GetContentPath())
+	} else {
+		contentFile := NewFileCopy(file)
+		contentFile.folder = ContentFolder
+		contentFile.name = file.contentHash + strings.ToLower(file.GetExtension())
+		contentFile.existInStore = false
+
+		err = contentFile.writeDocument(file.app.Config.GetBucketContent())
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error writing content %s", contentFile.GetPath()))
+		}
+		slog.Info("Create Content: %s for %s", contentFile.GetPath(), file.GetPath())
+	}
+
+	return addContentRef(file.app, file.contentHash, file.GetPath(), file.contentType, len(file.content))
+}
+
+// writeReference write the path of the content instead of the content to the bucket, the reference is text
+// (ReferenceContentType), the content type of the content is kept in the metadata
+func (file *File) writeReference(bucket string) error {
+	ref := NewFileCopy(file)
+	ref.reference = true
+	ref.content = []byte(file.GetContentPath())
+	err := ref.writeDocument(bucket)
+	if err != nil {
+		return err
+	}
+	file.reference = true
+	return nil
+}
+
+func addContentRef(app *application.AppContext, hash string, path string, contentType string, size int) error {
+
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+
+		var ref ContentRef
+		errTx := tx.Get(getContentRefKey(app, hash), &ref)
+		if errTx != nil && errTx != datastore.ErrNoSuchEntity {
+			return errTx
+		}
+
+		for _, p := range ref.Paths {
+			if p == path {
+				return nil
+			}
+		}
+
+		ref.Hash = hash
+		ref.ContentType = contentType
+		ref.Size = size
+		ref.Paths = append(ref.Paths, path)
+		ref.Unreferenziert = false
+		ref.SavedAt = time.Now()
+		_, errTx = tx.Put(getContentRefKey(app, hash), &ref)
+		return errTx
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error adding reference %s to content %s", path, hash))
+	}
+	return nil
+}
+
+// removeContentRef remove the path from the ContentRef, the content itself stays as backup until GCContent
+func removeContentRef(app *application.AppContext, hash string, path string) error {
+
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+
+		var ref ContentRef
+		errTx := tx.Get(getContentRefKey(app, hash), &ref)
+		if errTx == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if errTx != nil {
+			return errTx
+		}
+
+		var paths []string
+		for _, p := range ref.Paths {
+			if p != path {
+				paths = append(paths, p)
+			}
+		}
+		ref.Paths = paths
+		if !containsString(ref.Frueher, path) {
+			ref.Frueher = append(ref.Frueher, path)
+		}
+		ref.Unreferenziert = len(paths) == 0
+		ref.SavedAt = time.Now()
+		_, errTx = tx.Put(getContentRefKey(app, hash), &ref)
+		return errTx
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error removing reference %s from content %s", path, hash))
+	}
+	return nil
+}
+
+// GetContentRef load the references of a content, hash may also be a path in the ContentFolder
+func GetContentRef(app *application.AppContext, hash string) (*ContentRef, error) {
+
+	hash = strings.TrimPrefix(hash, ContentFolder)
+	if i := strings.IndexAny(hash, "./"); i >= 0 {
+		hash = hash[:i]
+	}
+
+	var ref ContentRef
+	err := app.Db().Get(app.Ctx(), getContentRefKey(app, hash), &ref)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting content reference %s", hash))
+	}
+	return &ref, nil
+}
+
+// GetDocumentUri returns the gs:// uri of the content of a path in the fetched bucket
+func GetDocumentUri(app *application.AppContext, path string) (string, error) {
+
+	file := NewFileFromStore(app, "", path)
+	err := file.ReadDocumentInfo(app.Config.GetBucketFetched())
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error reading info of %s", path))
+	}
+	if file.reference {
+		return fmt.Sprintf("gs://%s/%s", app.Config.GetBucketContent(), file.GetContentPath()), nil
+	}
+	return fmt.Sprintf("gs://%s/%s", app.Config.GetBucketFetched(), path), nil
+}
+
+// GetOcrPrefix returns the prefix of the ocr results of a path in the fetched bucket
+func GetOcrPrefix(app *application.AppContext, path string) (string, error) {
+
+	file := NewFileFromStore(app, "", path)
+	err := file.ReadDocumentInfo(app.Config.GetBucketFetched())
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error reading info of %s", path))
+	}
+	return file.GetOcrPrefix(), nil
+}
+
+// MigrateContentAddressed store the objects of the folder in the fetched bucket written before WriteContentAddressed
+// (e.g. Config.GetAnlagenFolder) once per sha256 in the content bucket and replace them by references, their ocr
+// results are moved to the content path (dropped if the content has ocr results already); references written with
+// the content type of the content get ReferenceContentType
+func MigrateContentAddressed(app *application.AppContext, folder string, dryRun bool) (*MigrationReport, error) {
+
+	bucket := app.Config.GetBucketFetched()
+	objects, err := ListObjectAttrs(app, bucket, folder)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error listing %s", folder))
+	}
+
+	report := &MigrationReport{DryRun: dryRun}
+	for _, o := range objects {
+
+		if o.Metadata[metadataRef] != "" {
+			if o.Metadata[metadataContentType] == "" && !dryRun {
+				errUpdate := updateReferenceContentType(app, o)
+				if errUpdate != nil {
+					slog.Error("error updating content type of reference %s: %v", o.Name, errUpdate)
+					report.Fehler++
+				}
+			}
+			continue
+		}
+		report.Objekte++
+		if dryRun {
+			slog.Info("DRY-RUN migrate %s", o.Name)
+			report.Migriert = append(report.Migriert, o.Name)
+			continue
+		}
+
+		moved, errMigrate := migrateContentAddressed(app, o)
+		report.OcrVerschoben += moved
+		if errMigrate != nil {
+			slog.Error("error migrating %s: %v", o.Name, errMigrate)
+			report.Fehler++
+			continue
+		}
+		report.Migriert = append(report.Migriert, o.Name)
+	}
+
+	slog.Info("content migration of %s (dry-run: %v): %d objekte, %d migriert, %d ocr results moved, %d fehler",
+		folder, dryRun, report.Objekte, len(report.Migriert), report.OcrVerschoben, report.Fehler)
+	return report, nil
+}
+
+func migrateContentAddressed(app *application.AppContext, attrs *storage.ObjectAttrs) (int, error) {
+
+	file, err := NewFileFromAttrs(app, attrs)
+	if err != nil {
+		return 0, err
+	}
+	err = file.ReadDocument(app.Config.GetBucketFetched())
+	if err != nil {
+		return 0, err
+	}
+	file.contentAddressed = true
+	err = file.storeContent()
+	if err != nil {
+		return 0, err
+	}
+	moved, err := moveOcrResults(app, file.GetPath(), file.GetContentPath())
+	if err != nil {
+		return moved, err
+	}
+	return moved, file.writeReference(app.Config.GetBucketFetched())
+}
+
+// updateReferenceContentType set the content type of a reference written with the content type of the content
+func updateReferenceContentType(app *application.AppContext, attrs *storage.ObjectAttrs) error {
+
+	metadata := make(map[string]string)
+	for k, v := range attrs.Metadata {
+		metadata[k] = v
+	}
+	metadata[metadataContentType] = attrs.ContentType
+	_, err := app.Store().Bucket(app.Config.GetBucketFetched()).Object(attrs.Name).Update(app.Ctx(), storage.ObjectAttrsToUpdate{
+		ContentType: ReferenceContentType,
+		Metadata:    metadata,
+	})
+	return err
+}
+
+// moveOcrResults move the ocr results of a prefix to another one, they are dropped if there are results already
+func moveOcrResults(app *application.AppContext, from string, to string) (int, error) {
+
+	ocrBucket := app.Config.GetBucketOcr()
+	objects, err := ListObjectAttrs(app, ocrBucket, from)
+	if err != nil {
+		return 0, err
+	}
+	existing, err := ListObjectAttrs(app, ocrBucket, to)
+	if err != nil {
+		return 0, err
+	}
+
+	bucket := app.Store().Bucket(ocrBucket)
+	moved := 0
+	for _, o := range objects {
+		if len(existing) == 0 {
+			_, err = bucket.Object(to + strings.TrimPrefix(o.Name, from)).CopierFrom(bucket.Object(o.Name)).Run(app.Ctx())
+			if err != nil {
+				return moved, errors.Wrap(err, fmt.Sprintf("error copying ocr result %s", o.Name))
+			}
+		}
+		err = bucket.Object(o.Name).Delete(app.Ctx())
+		if err != nil {
+			return moved, errors.Wrap(err, fmt.Sprintf("error deleting ocr result %s", o.Name))
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// GCContent delete the contents without references and their ocr results, a content is the backup of the paths which
+// referred to it: it is kept for policy.KeepFor and forever if one of them is protected (the Anlagen by
+// NewRetentionPolicy); with dryRun only the report is created
+func GCContent(app *application.AppContext, policy *RetentionPolicy, dryRun bool) (*GCReport, error) {
+
+	var refs []*ContentRef
+	keys, err := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityContentRef()).Filter("Unreferenziert =", true), &refs)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting unreferenced contents")
+	}
+
+	report := &GCReport{DryRun: dryRun, Versionen: len(refs)}
+	now := time.Now()
+	for i, ref := range refs {
+
+		if policy.isProtectedAny(ref.Frueher) {
+			report.Geschuetzt++
+			continue
+		}
+		if now.Sub(ref.SavedAt) < policy.KeepFor {
+			report.Behalten++
+			continue
+		}
+
+		prefix := ContentFolder + ref.Hash
+		if dryRun {
+			slog.Info("DRY-RUN delete content %s", prefix)
+			report.Geloescht = append(report.Geloescht, prefix)
+			report.Bytes = report.Bytes + int64(ref.Size)
+			continue
+		}
+
+		deleted, errDelete := deleteContent(app, keys[i], prefix)
+		if errDelete != nil {
+			slog.Error("error deleting content %s: %v", prefix, errDelete)
+			report.Fehler++
+			continue
+		}
+		if !deleted {
+			report.Behalten++
+			continue
+		}
+		slog.Info("deleted content %s", prefix)
+		report.Geloescht = append(report.Geloescht, prefix)
+		report.Bytes = report.Bytes + int64(ref.Size)
+	}
+
+	slog.Info("content gc (dry-run: %v): %d unreferenziert, %d behalten, %d geschützt, %d gelöscht (%d bytes), %d fehler",
+		dryRun, report.Versionen, report.Behalten, report.Geschuetzt, len(report.Geloescht), report.Bytes, report.Fehler)
+	return report, nil
+}
+
+// deleteContent delete the ContentRef if it is still unreferenced, then the content and its ocr results
+func deleteContent(app *application.AppContext, key *datastore.Key, prefix string) (bool, error) {
+
+	deleted := false
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+		var ref ContentRef
+		errTx := tx.Get(key, &ref)
+		if errTx != nil {
+			return errTx
+		}
+		if !ref.Unreferenziert {
+			return nil
+		}
+		deleted = true
+		return tx.Delete(key)
+	})
+	if err != nil || !deleted {
+		return false, err
+	}
+
+	for _, bucket := range []string{app.Config.GetBucketContent(), app.Config.GetBucketOcr()} {
+		objects, errList := ListObjectAttrs(app, bucket, prefix)
+		if errList != nil {
+			return true, errList
+		}
+		for _, o := range objects {
+			errDelete := app.Store().Bucket(bucket).Object(o.Name).Delete(app.Ctx())
+			if errDelete != nil && errDelete != storage.ErrObjectNotExist {
+				return true, errors.Wrap(errDelete, fmt.Sprintf("error deleting %s/%s", bucket, o.Name))
+			}
+		}
+	}
+	return true, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -29,11 +29,14 @@ type File struct {
 	risTime     time.Time //time the corresponding ressource in ris was created
 	fetchedAt   time.Time
 	hash        string //hash of the content created before saved in store
+	contentHash string //sha256 of the content if stored content addressed
 	content     []byte //the data of the stored file if loaded
 
 	loadedFromStore    bool
 	docInfoAlreadyRead bool //the properties of the file were already loaded from store
 	existInStore       bool //true if the file exist in store
+	contentAddressed   bool //content is stored once per sha256 in the content bucket
+	reference          bool //the stored object is only a reference to the content in the content bucket
 }
 
 func (file *File) GetReader() *bytes.Reader {
@@ -94,6 +97,9 @@ func NewFileCopy(file *File) *File {
 		folder:             file.folder,
 		name:               file.name,
 		hash:               file.hash,
+		contentHash:        file.contentHash,
+		contentAddressed:   file.contentAddressed,
+		reference:          file.reference,
 		contentType:        file.contentType,
 		updated:            file.updated,
 		risTime:            file.risTime,
@@ -119,7 +125,9 @@ func NewFileFromAttrs(app *application.AppContext, attrs *storage.ObjectAttrs) (
 		folder:             folder,
 		name:               name,
 		hash:               attrs.Metadata["hash"],
-		contentType:        attrs.ContentType,
+		contentHash:        attrs.Metadata["sha256"],
+		reference:          attrs.Metadata[metadataRef] != "",
+		contentType:        storedContentType(attrs),
 		updated:            attrs.Updated,
 		risTime:            attrs.CustomTime,
 		docInfoAlreadyRead: true,
@@ -128,6 +136,14 @@ func NewFileFromAttrs(app *application.AppContext, attrs *storage.ObjectAttrs) (
 	}, nil
 }
 
+// storedContentType returns the content type of the stored content, of the referenced content for references
+func storedContentType(attrs *storage.ObjectAttrs) string {
+	if contentType := attrs.Metadata[metadataContentType]; attrs.Metadata[metadataRef] != "" && contentType != "" {
+		return contentType
+	}
+	return attrs.ContentType
+}
+
 // ReadDocumentInfo load attributes from storage into file (only first time called it will get the attributes)
 func (file *File) ReadDocumentInfo(bucket string) error {
 
@@ -149,8 +165,10 @@ func (file *File) ReadDocumentInfo(bucket string) error {
 
 		file.existInStore = true
 		file.hash = attrs.Metadata["hash"]
+		file.contentHash = attrs.Metadata["sha256"]
+		file.reference = attrs.Metadata[metadataRef] != ""
 		file.updated = attrs.Updated
-		file.contentType = attrs.ContentType
+		file.contentType = storedContentType(attrs)
 		file.risTime = attrs.CustomTime
 		file.fetchedAt = fetchedAt
 	}
@@ -167,6 +185,15 @@ func (file *File) createObjectAttrs() (attrs *storage.ObjectAttrs, err error) {
 	}
 
 	props := map[string]string{"hash": file.hash, "fetchedAt": file.fetchedAt.Format(time.RFC3339)}
+	if file.contentHash != "" {
+		props["sha256"] = file.contentHash
+	}
+	contentType := file.contentType
+	if file.reference {
+		props[metadataRef] = file.GetContentPath()
+		props[metadataContentType] = file.contentType
+		contentType = ReferenceContentType
+	}
 
 	if file.existInStore {
 		props["ChangedBy"] = "Update"
@@ -177,7 +204,7 @@ func (file *File) createObjectAttrs() (attrs *storage.ObjectAttrs, err error) {
 	attrs = &storage.ObjectAttrs{
 		Name:            file.GetPath(),
 		ContentLanguage: "de",
-		ContentType:     file.contentType,
+		ContentType:     contentType,
 		CustomTime:      file.risTime,
 		Metadata:        props,
 	}
@@ -287,12 +314,58 @@ func (file *File) WriteIfMoreActualAndDifferent(newHash string) (err error) {
 	}
 
 	file.hash = newHash
-	err = file.writeDocument(file.app.Config.GetBucketFetched())
+	oldContentHash := file.contentHash
+	if file.contentAddressed {
+		err = file.storeContent()
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error storing content of %s", file.GetPath()))
+		}
+		err = file.writeReference(file.app.Config.GetBucketFetched())
+	} else {
+		file.contentHash = ""
+		file.reference = false
+		err = file.writeDocument(file.app.Config.GetBucketFetched())
+	}
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("error writing new file %s", file.GetPath()))
 	}
 
-	return err
+	if oldContentHash != "" && oldContentHash != file.contentHash {
+		err = removeContentRef(file.app, oldContentHash, file.GetPath())
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error removing old content reference of %s", file.GetPath()))
+		}
+	}
+	return nil
+}
+
+// GetHash returns the hash of the stored content (needs ReadDocumentInfo for stored files)
+func (file *File) GetHash() string {
+	return file.hash
+}
+
+// MigrateHash replace the hash of the stored file without writing a new version (after a change of the hash function)
+func (file *File) MigrateHash(bucket string, newHash string) error {
+
+	obj := file.app.Store().Bucket(bucket).Object(file.GetPath())
+	attrs, err := obj.Attrs(file.app.Ctx())
+	if err != nil {
+		return err
+	}
+
+	metadata := make(map[string]string)
+	for k, v := range attrs.Metadata {
+		metadata[k] = v
+	}
+	metadata["hash"] = newHash
+
+	_, err = obj.Update(file.app.Ctx(), storage.ObjectAttrsToUpdate{Metadata: metadata})
+	if err != nil {
+		return err
+	}
+	slog.Info("migrated hash of %s from %s to %s", file.GetPath(), file.hash, newHash)
+	file.hash = newHash
+	return nil
 }
 
 func (file *File) GetContent() []byte {
@@ -303,27 +376,36 @@ func (file *File) GetContentType() string {
 	return file.contentType
 }
 
-// moveToBackup move a stored file to the backup storage
+// GetRisTime returns the time the ressource was created in ris (needs ReadDocumentInfo for stored files)
+func (file *File) GetRisTime() time.Time {
+	return file.risTime
+}
+
+// moveToBackup move a stored file to the backup storage (content addressed files are only referenced)
 func (file *File) moveToBackup(deleteOriginal bool) error {
 
-	err := file.ReadDocument(file.app.Config.GetBucketFetched())
+	stored, err := file.isContentStored()
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error deleting file %s", file.name))
+		return errors.Wrap(err, fmt.Sprintf("error checking content of %s", file.name))
 	}
 
-	newFile := NewFileCopy(file)
-	newFile.name = sanitize.Path(
-		fmt.Sprintf("%s_%s%s",
-			file.GetNameWithoutExtension(),
-			file.updated.Format("2006-01-02-15-04-05"),
-			file.GetExtension()))
-
-	err = newFile.writeDocument(file.app.Config.GetBucketBackup())
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error writing file to backup %s", file.name))
+	if stored {
+		slog.Debug("content of %s already stored as %s, no backup", file.GetPath(), file.GetContentPath())
+	} else {
+		err = file.writeBackup()
+		if err != nil {
+			return err
+		}
 	}
 
 	if deleteOriginal {
+		if file.contentHash != "" {
+			err = removeContentRef(file.app, file.contentHash, file.GetPath())
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("error removing content reference of %s", file.name))
+			}
+		}
+
 		err = file.DeleteDocument(file.app.Config.GetBucketFetched())
 		if err != nil {
 			return errors.Wrap(err, fmt.Sprintf("error deleting file %s", file.name))
@@ -333,6 +415,30 @@ func (file *File) moveToBackup(deleteOriginal bool) error {
 	return nil
 }
 
+// writeBackup copy the stored version of the file to the backup storage
+func (file *File) writeBackup() error {
+
+	// read into a copy, the content of file may be already the new version
+	oldFile := NewFileCopy(file)
+	err := oldFile.ReadDocument(file.app.Config.GetBucketFetched())
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading file %s", file.name))
+	}
+
+	oldFile.name = sanitize.Path(
+		fmt.Sprintf("%s_%s%s",
+			file.GetNameWithoutExtension(),
+			file.updated.Format("2006-01-02-15-04-05"),
+			file.GetExtension()))
+
+	err = oldFile.writeDocument(file.app.Config.GetBucketBackup())
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing file to backup %s", file.name))
+	}
+
+	return nil
+}
+
 func (file *File) backupAndDeleteFile() error {
 	return file.moveToBackup(true)
 }
@@ -426,10 +532,52 @@ func ListFiles(app *application.AppContext, prefix string) (result []*File, err
 	return result, nil
 }
 
-// ReadDocument read content from storage
+// ListObjectAttrs list the attributes of all objects in a bucket (also buckets not written by File like ocr)
+func ListObjectAttrs(app *application.AppContext, bucket string, prefix string) (result []*storage.ObjectAttrs, err error) {
+
+	it := app.Store().Bucket(bucket).Objects(app.Ctx(), &storage.Query{
+		Prefix: prefix,
+	})
+
+	for {
+		attrs, errIt := it.Next()
+		if errIt == iterator.Done {
+			break
+		}
+		if errIt != nil {
+			return nil, errors.Wrap(errIt, fmt.Sprintf("error iterating objects in %s/%s", bucket, prefix))
+		}
+		result = append(result, attrs)
+	}
+
+	return result, nil
+}
+
+// SavedAt returns the time the stored content was fetched (metadata fetchedAt, written with the content and not changed
+// by touch), the creation of the object version if the metadata is missing
+func SavedAt(attrs *storage.ObjectAttrs) time.Time {
+	fetchedAt, err := time.Parse(time.RFC3339, attrs.Metadata["fetchedAt"])
+	if err != nil {
+		return attrs.Created
+	}
+	return fetchedAt
+}
+
+// ReadDocument read content from storage, the content of a reference in the fetched bucket is read from the content bucket
 func (file *File) ReadDocument(bucket string) error {
 
-	reader, err := file.app.Store().Bucket(bucket).Object(file.GetPath()).NewReader(file.app.Ctx())
+	objectPath := file.GetPath()
+	if bucket == file.app.Config.GetBucketFetched() {
+		err := file.ReadDocumentInfo(bucket)
+		if err != nil {
+			return err
+		}
+		if file.reference {
+			bucket, objectPath = file.app.Config.GetBucketContent(), file.GetContentPath()
+		}
+	}
+
+	reader, err := file.app.Store().Bucket(bucket).Object(objectPath).NewReader(file.app.Ctx())
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,161 @@
+package files
+
+import (
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// regexBackupName matches the names created by moveToBackup: name_2006-01-02-15-04-05.ext
+var regexBackupName = regexp.MustCompile(`^(.*)_([0-9]{4}-[0-9]{2}-[0-9]{2}-[0-9]{2}-[0-9]{2}-[0-9]{2})(\.[^./]*)?$`)
+
+const backupTimeFormat = "2006-01-02-15-04-05"
+
+// RetentionPolicy decide which versions in the backup bucket are kept. A version is kept if it is one of
+// the KeepLast newest versions of its path or younger than KeepFor. List pages (stored without folder)
+// use ListKeepLast and ListKeepFor, versions in Protected folders are never deleted.
+type RetentionPolicy struct {
+	KeepLast     int
+	KeepFor      time.Duration
+	ListKeepLast int
+	ListKeepFor  time.Duration
+	Protected    []string
+}
+
+type GCReport struct {
+	DryRun     bool
+	Versionen  int
+	Behalten   int
+	Geschuetzt int
+	Geloescht  []string
+	Bytes      int64
+	Fehler     int
+}
+
+type backupVersion struct {
+	name string
+	time time.Time
+	size int64
+}
+
+// NewRetentionPolicy create the policy from config, Vorlagen and Anlagen are protected
+func NewRetentionPolicy(app *application.AppContext) *RetentionPolicy {
+	return &RetentionPolicy{
+		KeepLast:     app.Config.GetRetentionKeepLast(),
+		KeepFor:      app.Config.GetRetentionKeepFor(),
+		ListKeepLast: app.Config.GetRetentionListKeepLast(),
+		ListKeepFor:  app.Config.GetRetentionListKeepFor(),
+		Protected:    []string{app.Config.GetVorlagenFolder(), app.Config.GetAnlagenFolder()},
+	}
+}
+
+func (p *RetentionPolicy) isProtected(path string) bool {
+	for _, folder := range p.Protected {
+		if folder != "" && strings.HasPrefix(path, folder) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetentionPolicy) isProtectedAny(paths []string) bool {
+	for _, path := range paths {
+		if p.isProtected(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// keep returns true if the version with the given index (0 is the newest) must be kept
+func (p *RetentionPolicy) keep(path string, index int, version time.Time, now time.Time) bool {
+
+	keepLast, keepFor := p.KeepLast, p.KeepFor
+	if !strings.Contains(path, "/") {
+		keepLast, keepFor = p.ListKeepLast, p.ListKeepFor
+	}
+
+	//the newest backup is always kept
+	if keepLast < 1 {
+		keepLast = 1
+	}
+	return index < keepLast || now.Sub(version) < keepFor
+}
+
+// splitBackupName returns the original path and the time of a version in the backup bucket
+func splitBackupName(name string) (string, time.Time, bool) {
+
+	matches := regexBackupName.FindStringSubmatch(name)
+	if matches == nil {
+		return "", time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeFormat, matches[2])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return matches[1] + matches[3], t, true
+}
+
+// GC delete the versions in the backup bucket not kept by the policy, with dryRun only the report is created
+func GC(app *application.AppContext, policy *RetentionPolicy, dryRun bool) (*GCReport, error) {
+
+	bucket := app.Config.GetBucketBackup()
+	objects, err := ListObjectAttrs(app, bucket, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing backup bucket")
+	}
+
+	report := &GCReport{DryRun: dryRun, Versionen: len(objects)}
+	versions := make(map[string][]*backupVersion)
+	for _, o := range objects {
+		path, t, ok := splitBackupName(o.Name)
+		if !ok {
+			slog.Debug("not a backup version, keep %s", o.Name)
+			report.Behalten++
+			continue
+		}
+		versions[path] = append(versions[path], &backupVersion{name: o.Name, time: t, size: o.Size})
+	}
+
+	now := time.Now()
+	for path, vs := range versions {
+
+		if policy.isProtected(path) {
+			report.Geschuetzt += len(vs)
+			continue
+		}
+
+		sort.SliceStable(vs, func(i, j int) bool {
+			return vs[i].time.After(vs[j].time)
+		})
+
+		for i, v := range vs {
+			if policy.keep(path, i, v.time, now) {
+				report.Behalten++
+				continue
+			}
+
+			if dryRun {
+				slog.Info("DRY-RUN delete backup %s", v.name)
+			} else {
+				err = app.Store().Bucket(bucket).Object(v.name).Delete(app.Ctx())
+				if err != nil {
+					slog.Error("error deleting backup %s: %v", v.name, err)
+					report.Fehler++
+					continue
+				}
+				slog.Info("deleted backup %s", v.name)
+			}
+			report.Geloescht = append(report.Geloescht, v.name)
+			report.Bytes = report.Bytes + v.size
+		}
+	}
+
+	slog.Info("backup gc (dry-run: %v): %d versionen, %d behalten, %d geschützt, %d gelöscht (%d bytes), %d fehler",
+		dryRun, report.Versionen, report.Behalten, report.Geschuetzt, len(report.Geloescht), report.Bytes, report.Fehler)
+	return report, nil
+}
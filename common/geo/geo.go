@@ -0,0 +1,254 @@
+package geo
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/extract"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const GenauigkeitAdresse = "adresse"
+const GenauigkeitStrasse = "strasse"
+
+// Ort is a geocoded location mentioned in a text
+type Ort struct {
+	Adresse     string
+	Lat         float64
+	Lon         float64
+	Genauigkeit string //GenauigkeitAdresse or GenauigkeitStrasse (centroid of the addresses of the street)
+	Gebiet      string
+}
+
+type punkt struct {
+	lat    float64
+	lon    float64
+	gebiet string
+}
+
+// Geocoder resolve addresses with a local address list (e.g. exported from OSM or the official address register)
+type Geocoder struct {
+	adressen map[string]punkt //strasse|hausnummer
+	strassen map[string]*strasse
+}
+
+type strasse struct {
+	name    string
+	lat     float64
+	lon     float64
+	anzahl  int
+	gebiete map[string]int
+}
+
+var headerStrasse = []string{"strasse", "straße", "street", "addr:street"}
+var headerHausnummer = []string{"hausnummer", "hnr", "housenumber", "addr:housenumber"}
+var headerLat = []string{"lat", "latitude", "breite"}
+var headerLon = []string{"lon", "lng", "longitude", "laenge", "länge"}
+var headerGebiet = []string{"gebiet", "ortsteil", "stadtteil", "suburb", "addr:suburb"}
+
+// ReadGeocoder read the addresses from csv (separated by ; or ,) with a header naming the columns
+// strasse, hausnummer, lat, lon and optional gebiet
+func ReadGeocoder(r io.Reader) (*Geocoder, error) {
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	firstLine := strings.SplitN(string(data), "\n", 2)[0]
+	if strings.Count(firstLine, ";") > strings.Count(firstLine, ",") {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading address header")
+	}
+	colStrasse, colNr, colLat, colLon, colGebiet := column(header, headerStrasse), column(header, headerHausnummer), column(header, headerLat), column(header, headerLon), column(header, headerGebiet)
+	if colStrasse < 0 || colLat < 0 || colLon < 0 {
+		return nil, errors.New(fmt.Sprintf("address list needs the columns strasse, lat and lon: %v", header))
+	}
+
+	g := &Geocoder{adressen: make(map[string]punkt), strassen: make(map[string]*strasse)}
+	for {
+		record, errRead := reader.Read()
+		if errRead == io.EOF {
+			break
+		}
+		if errRead != nil {
+			return nil, errors.Wrap(errRead, "error reading address list")
+		}
+		lat, errLat := strconv.ParseFloat(field(record, colLat), 64)
+		lon, errLon := strconv.ParseFloat(field(record, colLon), 64)
+		if errLat != nil || errLon != nil {
+			continue
+		}
+		g.Add(field(record, colStrasse), field(record, colNr), lat, lon, field(record, colGebiet))
+	}
+	return g, nil
+}
+
+// LoadGeocoder read the address list from a local file
+func LoadGeocoder(path string) (*Geocoder, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error opening address list %s", path))
+	}
+	defer f.Close()
+	return ReadGeocoder(f)
+}
+
+var geocoderMutex sync.Mutex
+var geocoders = make(map[string]*Geocoder)
+var geocoderErrors = make(map[string]error)
+
+// ForAdressen returns the geocoder of the local address list (loaded once), nil if path is empty or not readable,
+// the error of the load is returned on every call
+func ForAdressen(path string) (*Geocoder, error) {
+
+	geocoderMutex.Lock()
+	defer geocoderMutex.Unlock()
+
+	g, exist := geocoders[path]
+	if exist || path == "" {
+		return g, geocoderErrors[path]
+	}
+	g, err := LoadGeocoder(path)
+	geocoders[path] = g
+	geocoderErrors[path] = err
+	return g, err
+}
+
+func column(header []string, names []string) int {
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		for _, n := range names {
+			if h == n {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func field(record []string, col int) string {
+	if col < 0 || col >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[col])
+}
+
+func strassenKey(name string) string {
+	return strings.ToLower(extract.NormalizeStrasse(name))
+}
+
+func nummerKey(nr string) string {
+	return strings.ToLower(strings.Join(strings.Fields(nr), ""))
+}
+
+// Add a address, the street is also geocoded by the centroid of its addresses
+func (g *Geocoder) Add(name string, hausnummer string, lat float64, lon float64, gebiet string) {
+
+	key := strassenKey(name)
+	if key == "" {
+		return
+	}
+	if hausnummer != "" {
+		g.adressen[key+"|"+nummerKey(hausnummer)] = punkt{lat: lat, lon: lon, gebiet: gebiet}
+	}
+
+	s, exist := g.strassen[key]
+	if !exist {
+		s = &strasse{name: extract.NormalizeStrasse(name), gebiete: make(map[string]int)}
+		g.strassen[key] = s
+	}
+	s.lat = (s.lat*float64(s.anzahl) + lat) / float64(s.anzahl+1)
+	s.lon = (s.lon*float64(s.anzahl) + lon) / float64(s.anzahl+1)
+	s.anzahl++
+	if gebiet != "" {
+		s.gebiete[gebiet]++
+	}
+}
+
+func (g *Geocoder) Len() int {
+	return len(g.adressen)
+}
+
+// Geocode returns the location of the address, the centroid of the street if the house number is unknown
+func (g *Geocoder) Geocode(name string, hausnummer string) (Ort, bool) {
+
+	key := strassenKey(name)
+	s, exist := g.strassen[key]
+	if !exist {
+		return Ort{}, false
+	}
+
+	if hausnummer != "" {
+		if p, found := g.adressen[key+"|"+nummerKey(hausnummer)]; found {
+			return Ort{
+				Adresse:     s.name + " " + hausnummer,
+				Lat:         p.lat,
+				Lon:         p.lon,
+				Genauigkeit: GenauigkeitAdresse,
+				Gebiet:      p.gebiet,
+			}, true
+		}
+	}
+
+	return Ort{
+		Adresse:     strings.TrimSpace(s.name + " " + hausnummer),
+		Lat:         s.lat,
+		Lon:         s.lon,
+		Genauigkeit: GenauigkeitStrasse,
+		Gebiet:      s.gebiet(),
+	}, true
+}
+
+// gebiet returns the area with the most addresses of the street
+func (s *strasse) gebiet() string {
+	result, max := "", 0
+	for gebiet, anzahl := range s.gebiete {
+		if anzahl > max || (anzahl == max && gebiet < result) {
+			result, max = gebiet, anzahl
+		}
+	}
+	return result
+}
+
+// GeocodeEntities returns the locations of the addresses and of the streets mentioned without house number,
+// nil without geocoder
+func (g *Geocoder) GeocodeEntities(r *extract.Result) []Ort {
+
+	if g == nil || r == nil {
+		return nil
+	}
+
+	var orte []Ort
+	mitAdresse := make(map[string]bool)
+	for _, adresse := range r.Adressen {
+		name, nr := adresse, ""
+		if i := strings.LastIndex(adresse, " "); i > 0 {
+			name, nr = adresse[:i], adresse[i+1:]
+		}
+		if ort, found := g.Geocode(name, nr); found {
+			orte = append(orte, ort)
+			mitAdresse[strassenKey(name)] = true
+		}
+	}
+	for _, name := range r.Strassen {
+		if mitAdresse[strassenKey(name)] {
+			continue
+		}
+		if ort, found := g.Geocode(name, ""); found {
+			orte = append(orte, ort)
+		}
+	}
+	return orte
+}
@@ -0,0 +1,54 @@
+package geo
+
+// FeatureCollection is a GeoJSON (RFC 7946) collection of features
+type FeatureCollection struct {
+	Type     string     `json:"type"`
+	Features []*Feature `json:"features"`
+}
+
+type Feature struct {
+	Type       string                 `json:"type"`
+	Geometry   *Geometry              `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Geometry is a Point or MultiPoint, the coordinates are [lon, lat]
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+func NewFeatureCollection() *FeatureCollection {
+	return &FeatureCollection{Type: "FeatureCollection", Features: []*Feature{}}
+}
+
+// NewFeature returns a Point feature for one location and a MultiPoint feature for more, nil without locations
+func NewFeature(orte []Ort, properties map[string]interface{}) *Feature {
+
+	if len(orte) == 0 {
+		return nil
+	}
+
+	var adressen []string
+	var coordinates [][]float64
+	for _, o := range orte {
+		adressen = append(adressen, o.Adresse)
+		coordinates = append(coordinates, []float64{o.Lon, o.Lat})
+	}
+	if properties == nil {
+		properties = make(map[string]interface{})
+	}
+	properties["adressen"] = adressen
+
+	geometry := &Geometry{Type: "MultiPoint", Coordinates: coordinates}
+	if len(coordinates) == 1 {
+		geometry = &Geometry{Type: "Point", Coordinates: coordinates[0]}
+	}
+	return &Feature{Type: "Feature", Geometry: geometry, Properties: properties}
+}
+
+func (fc *FeatureCollection) Add(f *Feature) {
+	if f != nil {
+		fc.Features = append(fc.Features, f)
+	}
+}
@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"github.com/kennygrant/sanitize"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer write mails as .eml files into Folder or to stdout if Folder is empty (for local testing)
+type FileMailer struct {
+	Folder string
+}
+
+func NewFileMailer(folder string) *FileMailer {
+	return &FileMailer{
+		Folder: folder,
+	}
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg *Message) error {
+
+	now := time.Now()
+	body, err := buildMime(msg, now)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error building mail '%s'", msg.Subject))
+	}
+
+	if m.Folder == "" {
+		_, err = os.Stdout.Write(body)
+		return err
+	}
+
+	err = os.MkdirAll(m.Folder, 0755)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error creating mail folder %s", m.Folder))
+	}
+
+	name := filepath.Join(m.Folder, sanitize.Path(fmt.Sprintf("%s-%s.eml", now.Format("2006-01-02-15-04-05.000000"), msg.Subject)))
+	err = ioutil.WriteFile(name, body, 0644)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing mail to %s", name))
+	}
+
+	slog.Info("mail written to %s", name)
+	return nil
+}
@@ -1,6 +1,13 @@
 package mail
 
-import "time"
+import (
+	"context"
+	"time"
+)
+
+const TransportMailgun = "mailgun"
+const TransportSmtp = "smtp"
+const TransportFile = "file"
 
 type SomethingNewMessage struct {
 	EntityType string
@@ -10,3 +17,36 @@ type SomethingNewMessage struct {
 	Message    string
 	Time       time.Time
 }
+
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	Html    string
+}
+
+// Mailer sends a prepared message over one transport (mailgun, smtp or file)
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+type defaultFromMailer struct {
+	Mailer
+	from string
+}
+
+// WithDefaultFrom returns a mailer sending messages without From from the address from
+func WithDefaultFrom(m Mailer, from string) Mailer {
+	if from == "" {
+		return m
+	}
+	return &defaultFromMailer{Mailer: m, from: from}
+}
+
+func (m *defaultFromMailer) Send(ctx context.Context, msg *Message) error {
+	if msg.From == "" {
+		msg.From = m.from
+	}
+	return m.Mailer.Send(ctx, msg)
+}
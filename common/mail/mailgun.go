@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"github.com/mailgun/mailgun-go/v4"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+)
+
+type MailgunMailer struct {
+	Mg *mailgun.MailgunImpl
+}
+
+func NewMailgunMailer(domain string, apiKey string) *MailgunMailer {
+	return &MailgunMailer{
+		Mg: mailgun.NewMailgun(domain, apiKey),
+	}
+}
+
+func (m *MailgunMailer) Send(ctx context.Context, msg *Message) error {
+
+	message := m.Mg.NewMessage(msg.From, msg.Subject, msg.Text, msg.To...)
+	if msg.Html != "" {
+		message.SetHtml(msg.Html)
+	}
+
+	resp, id, err := m.Mg.Send(ctx, message)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error sending mail '%s' with mailgun", msg.Subject))
+	}
+
+	slog.Info("mail sent with mailgun: %s (%s)", id, resp)
+	return nil
+}
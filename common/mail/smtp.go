@@ -0,0 +1,196 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SmtpMailer struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+}
+
+func NewSmtpMailer(host string, port int, user string, password string) *SmtpMailer {
+	return &SmtpMailer{
+		Host:     host,
+		Port:     port,
+		User:     user,
+		Password: password,
+	}
+}
+
+// Send deliver the message with the mail server, STARTTLS is used if the server supports it.
+// The connection is closed if ctx is done, its deadline is the deadline of the whole dialog
+func (m *SmtpMailer) Send(ctx context.Context, msg *Message) error {
+
+	if len(msg.To) == 0 {
+		return errors.New(fmt.Sprintf("no recipients for mail '%s'", msg.Subject))
+	}
+
+	body, err := buildMime(msg, time.Now())
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error building mail '%s'", msg.Subject))
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	err = m.send(ctx, addr, msg, body)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return errors.Wrap(err, fmt.Sprintf("error sending mail '%s' to %s", msg.Subject, addr))
+	}
+
+	slog.Info("mail sent with smtp %s: %s", addr, msg.Subject)
+	return nil
+}
+
+// send is smtp.SendMail with a connection bound to ctx
+func (m *SmtpMailer) send(ctx context.Context, addr string, msg *Message, body []byte) error {
+
+	for _, a := range append([]string{msg.From}, msg.To...) {
+		if strings.ContainsAny(a, "\r\n") {
+			return errors.New(fmt.Sprintf("line break in address %q", a))
+		}
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: m.Host})
+		if err != nil {
+			return err
+		}
+	}
+	if m.User != "" {
+		err = c.Auth(smtp.PlainAuth("", m.User, m.Password, m.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(msg.From)
+	if err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		err = c.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildMime create a multipart/alternative message with text and html part
+func buildMime(msg *Message, date time.Time) ([]byte, error) {
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	header := textproto.MIMEHeader{}
+	header.Set("From", msg.From)
+	header.Set("To", strings.Join(msg.To, ", "))
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header.Set("Date", date.Format(time.RFC1123Z))
+	header.Set("MIME-Version", "1.0")
+	header.Set("Content-Type", fmt.Sprintf("multipart/alternative; boundary=%s", writer.Boundary()))
+
+	for _, k := range []string{"From", "To", "Subject", "Date", "MIME-Version", "Content-Type"} {
+		buf.WriteString(fmt.Sprintf("%s: %s\r\n", k, header.Get(k)))
+	}
+	buf.WriteString("\r\n")
+
+	err := writePart(writer, "text/plain; charset=utf-8", msg.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.Html != "" {
+		err = writePart(writer, "text/html; charset=utf-8", msg.Html)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writePart(writer *multipart.Writer, contentType string, content string) error {
+
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(part)
+	_, err = qp.Write([]byte(content))
+	if err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,141 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const DigestDaily = "daily"
+const DigestWeekly = "weekly"
+
+type digestGroup struct {
+	EntityType string
+	Messages   []SomethingNewMessage
+}
+
+type digestData struct {
+	Zeitraum string
+	Von      time.Time
+	Bis      time.Time
+	Anzahl   int
+	Gruppen  []digestGroup
+}
+
+var digestFuncs = map[string]interface{}{
+	"datum": func(t time.Time) string {
+		return t.Format("02.01.2006")
+	},
+	"zeit": func(t time.Time) string {
+		return t.Format("02.01.2006 15:04")
+	},
+}
+
+const digestTextTmpl = `Guten Tag,
+
+{{if eq .Anzahl 1}}es gibt eine Neuigkeit{{else}}es gibt {{.Anzahl}} Neuigkeiten{{end}} im Ratsinformationssystem ({{.Zeitraum}} vom {{datum .Von}} bis {{datum .Bis}}).
+{{range .Gruppen}}
+{{.EntityType}}
+{{range .Messages}}
+- {{.Name}}{{if .ParentName}} ({{.ParentKind}} {{.ParentName}}){{end}}, {{zeit .Time}}
+  {{.Message}}
+{{end}}{{end}}
+Sie erhalten diese Nachricht, weil Sie Benachrichtigungen abonniert haben.
+`
+
+const digestHtmlTmpl = `<!DOCTYPE html>
+<html lang="de">
+<head><meta charset="utf-8"><title>Neuigkeiten im Ratsinformationssystem</title></head>
+<body style="font-family: sans-serif;">
+<p>Guten Tag,</p>
+<p>{{if eq .Anzahl 1}}es gibt eine Neuigkeit{{else}}es gibt {{.Anzahl}} Neuigkeiten{{end}} im Ratsinformationssystem ({{.Zeitraum}} vom {{datum .Von}} bis {{datum .Bis}}).</p>
+{{range .Gruppen}}
+<h2>{{.EntityType}}</h2>
+<ul>
+{{range .Messages}}<li><strong>{{.Name}}</strong>{{if .ParentName}} ({{.ParentKind}} {{.ParentName}}){{end}}, {{zeit .Time}}<br>{{.Message}}</li>
+{{end}}</ul>
+{{end}}
+<p style="color: #666; font-size: small;">Sie erhalten diese Nachricht, weil Sie Benachrichtigungen abonniert haben.</p>
+</body>
+</html>
+`
+
+var digestText = texttemplate.Must(texttemplate.New("digest.txt").Funcs(digestFuncs).Parse(digestTextTmpl))
+var digestHtml = htmltemplate.Must(htmltemplate.New("digest.html").Funcs(digestFuncs).Parse(digestHtmlTmpl))
+
+// DigestZeitraum returns the name and the beginning of the daily or weekly digest ending at until
+func DigestZeitraum(period string, until time.Time) (string, time.Time, error) {
+
+	switch period {
+	case DigestDaily:
+		return "Tageszusammenfassung", until.AddDate(0, 0, -1), nil
+	case DigestWeekly:
+		return "Wochenzusammenfassung", until.AddDate(0, 0, -7), nil
+	}
+	return "", time.Time{}, fmt.Errorf("unknown digest period %s", period)
+}
+
+// NewSomethingNewDigest render the daily or weekly digest of messages as text and html mail (without From and To)
+func NewSomethingNewDigest(period string, messages []SomethingNewMessage, until time.Time) (*Message, error) {
+
+	zeitraum, von, err := DigestZeitraum(period, until)
+	if err != nil {
+		return nil, err
+	}
+
+	data := digestData{
+		Zeitraum: zeitraum,
+		Von:      von,
+		Bis:      until,
+		Anzahl:   len(messages),
+		Gruppen:  groupMessages(messages),
+	}
+
+	var text bytes.Buffer
+	err = digestText.Execute(&text, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var html bytes.Buffer
+	err = digestHtml.Execute(&html, data)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := fmt.Sprintf("%s Ratsinformationssystem: %d Neuigkeiten", zeitraum, len(messages))
+	if len(messages) == 1 {
+		subject = fmt.Sprintf("%s Ratsinformationssystem: 1 Neuigkeit", zeitraum)
+	}
+
+	return &Message{
+		Subject: subject,
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		Html:    html.String(),
+	}, nil
+}
+
+func groupMessages(messages []SomethingNewMessage) (groups []digestGroup) {
+
+	index := make(map[string]int)
+	for _, m := range messages {
+		i, exist := index[m.EntityType]
+		if !exist {
+			i = len(groups)
+			index[m.EntityType] = i
+			groups = append(groups, digestGroup{EntityType: m.EntityType})
+		}
+		groups[i].Messages = append(groups[i].Messages, m)
+	}
+
+	for _, g := range groups {
+		sort.SliceStable(g.Messages, func(i, j int) bool {
+			return g.Messages[i].Time.Before(g.Messages[j].Time)
+		})
+	}
+	return groups
+}
@@ -0,0 +1,187 @@
+package minhash
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/rismaster/allris-common/common/textnorm"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// Hashes is the length of a signature, Baender * Zeilen
+const Hashes = 128
+
+// Baender and Zeilen split a signature for locality sensitive hashing, texts with a similarity of 0.7
+// share a band with a probability of 99.9%, texts with 0.3 with 23%
+const Baender = 32
+const Zeilen = 4
+
+// ShingleWords is the number of words of a shingle
+const ShingleWords = 3
+
+// Signatur is the MinHash signature of a text
+type Signatur []uint64
+
+var seeds = makeSeeds()
+
+func makeSeeds() []uint64 {
+	result := make([]uint64, Hashes)
+	x := uint64(0x5eed)
+	for i := range result {
+		x = mix(x + uint64(i))
+		result[i] = x
+	}
+	return result
+}
+
+// mix is the splitmix64 finalizer
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// Shingles returns the hashes of all sequences of ShingleWords words of text,
+// lowercase with folded umlauts and without stopwords and single letters (ocr noise)
+func Shingles(text string) map[uint64]bool {
+
+	var words []string
+	for _, w := range textnorm.Words(text) {
+		w = strings.ToLower(textnorm.FoldUmlauts(w))
+		if len(w) > 1 && !textnorm.IsStopword(w) {
+			words = append(words, w)
+		}
+	}
+
+	shingles := make(map[uint64]bool)
+	if len(words) > 0 && len(words) < ShingleWords {
+		shingles[hash(words)] = true
+	}
+	for i := 0; i+ShingleWords <= len(words); i++ {
+		shingles[hash(words[i:i+ShingleWords])] = true
+	}
+	return shingles
+}
+
+func hash(words []string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(words, " ")))
+	return h.Sum64()
+}
+
+// NewSignatur returns the signature of the shingles, nil without shingles
+func NewSignatur(shingles map[uint64]bool) Signatur {
+
+	if len(shingles) == 0 {
+		return nil
+	}
+
+	sig := make(Signatur, Hashes)
+	for i := range sig {
+		sig[i] = ^uint64(0)
+	}
+	for s := range shingles {
+		for i, seed := range seeds {
+			if h := mix(s ^ seed); h < sig[i] {
+				sig[i] = h
+			}
+		}
+	}
+	return sig
+}
+
+// ForText returns the signature of the shingles of text
+func ForText(text string) Signatur {
+	return NewSignatur(Shingles(text))
+}
+
+// Aehnlichkeit estimate the jaccard similarity of the shingles of two signatures
+func Aehnlichkeit(a Signatur, b Signatur) float64 {
+	if len(a) != Hashes || len(b) != Hashes {
+		return 0
+	}
+	gleich := 0
+	for i := range a {
+		if a[i] == b[i] {
+			gleich++
+		}
+	}
+	return float64(gleich) / float64(Hashes)
+}
+
+// Baender returns the hash of every band of the signature, prefixed with the number of the band
+func (sig Signatur) Baender() []string {
+	if len(sig) != Hashes {
+		return nil
+	}
+	result := make([]string, Baender)
+	buf := make([]byte, 8)
+	for b := 0; b < Baender; b++ {
+		h := fnv.New64a()
+		for _, v := range sig[b*Zeilen : (b+1)*Zeilen] {
+			binary.LittleEndian.PutUint64(buf, v)
+			h.Write(buf)
+		}
+		result[b] = fmt.Sprintf("%d-%x", b, h.Sum64())
+	}
+	return result
+}
+
+// Familien group the names of the signatures with a similarity of at least schwelle (transitive),
+// only groups with more than one member are returned, sorted by their first name
+func Familien(signaturen map[string]Signatur, schwelle float64) [][]string {
+
+	var names []string
+	for name, sig := range signaturen {
+		if len(sig) == Hashes {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	parent := make(map[string]string)
+	var find func(string) string
+	find = func(n string) string {
+		p, exist := parent[n]
+		if !exist || p == n {
+			return n
+		}
+		root := find(p)
+		parent[n] = root
+		return root
+	}
+
+	buckets := make(map[string][]string)
+	for _, name := range names {
+		for _, band := range signaturen[name].Baender() {
+			for _, other := range buckets[band] {
+				a, b := find(name), find(other)
+				if a != b && Aehnlichkeit(signaturen[name], signaturen[other]) >= schwelle {
+					if b < a {
+						a, b = b, a
+					}
+					parent[b] = a
+				}
+			}
+			buckets[band] = append(buckets[band], name)
+		}
+	}
+
+	groups := make(map[string][]string)
+	for _, name := range names {
+		root := find(name)
+		groups[root] = append(groups[root], name)
+	}
+	var result [][]string
+	for _, group := range groups {
+		if len(group) > 1 {
+			result = append(result, group)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i][0] < result[j][0]
+	})
+	return result
+}
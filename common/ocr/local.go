@@ -0,0 +1,144 @@
+package ocr
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/files"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// localLanguage is the tesseract language of the documents
+const localLanguage = "deu"
+
+// LocalProvider runs ocrmypdf (pdf) or tesseract (images) installed on the host, the job is done on Submit
+type LocalProvider struct {
+	ocrmypdf  string
+	tesseract string
+}
+
+func NewLocalProvider() *LocalProvider {
+	p := &LocalProvider{}
+	p.ocrmypdf, _ = exec.LookPath("ocrmypdf")
+	p.tesseract, _ = exec.LookPath("tesseract")
+	return p
+}
+
+// Available returns true if ocrmypdf or tesseract is installed
+func (p *LocalProvider) Available() bool {
+	return p.ocrmypdf != "" || p.tesseract != ""
+}
+
+func (p *LocalProvider) Name() string {
+	return ProviderLocal
+}
+
+func (p *LocalProvider) Submit(app *application.AppContext, document string, ocrPrefix string) (*Job, error) {
+
+	job := &Job{
+		ID:        fmt.Sprintf("local-%d", time.Now().UnixNano()),
+		Provider:  p.Name(),
+		Document:  document,
+		OcrPrefix: ocrPrefix,
+		Status:    JobRunning,
+		Submitted: time.Now(),
+	}
+
+	file := files.NewFileFromStore(app, "", document)
+	err := file.ReadDocument(app.Config.GetBucketFetched())
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading %s", document))
+	}
+
+	dir, err := ioutil.TempDir("", "ocr")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in"+strings.ToLower(file.GetExtension()))
+	err = ioutil.WriteFile(in, file.GetContent(), 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	var annots []OcrFullTextAnnotation
+	if strings.EqualFold(file.GetExtension(), ".pdf") {
+		annots, err = p.runOcrmypdf(app, dir, in)
+	} else {
+		annots, err = p.runTesseract(app, in)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, annot := range annots {
+		job.responses = append(job.responses, OcsResponse{
+			FullTextAnnotation: annot,
+			Context:            OcrContext{Uri: document, PageNumber: i + 1},
+		})
+	}
+	job.Status = JobDone
+	return job, nil
+}
+
+// runOcrmypdf returns the text of the pages of the sidecar without layout (ocrmypdf writes no hOCR or tsv)
+func (p *LocalProvider) runOcrmypdf(app *application.AppContext, dir string, in string) ([]OcrFullTextAnnotation, error) {
+
+	if p.ocrmypdf == "" {
+		return nil, errors.New("ocrmypdf not installed")
+	}
+
+	sidecar := filepath.Join(dir, "out.txt")
+	//the text layer of documents sent to ocr is garbage, it must be replaced and not skipped
+	cmd := exec.CommandContext(app.Ctx(), p.ocrmypdf, "--force-ocr", "-l", localLanguage, "--sidecar", sidecar, in, filepath.Join(dir, "out.pdf"))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error running ocrmypdf: %s", stderr.String()))
+	}
+
+	text, err := ioutil.ReadFile(sidecar)
+	if err != nil {
+		return nil, err
+	}
+
+	//ocrmypdf separates the pages of the sidecar by form feed
+	var annots []OcrFullTextAnnotation
+	for _, page := range strings.Split(strings.TrimSuffix(string(text), "\f"), "\f") {
+		annots = append(annots, OcrFullTextAnnotation{Text: strings.TrimSpace(page)})
+	}
+	return annots, nil
+}
+
+// runTesseract returns the pages of an image with the layout of the tsv output of tesseract
+func (p *LocalProvider) runTesseract(app *application.AppContext, in string) ([]OcrFullTextAnnotation, error) {
+
+	if p.tesseract == "" {
+		return nil, errors.New("tesseract not installed")
+	}
+
+	cmd := exec.CommandContext(app.Ctx(), p.tesseract, in, "stdout", "-l", localLanguage, "tsv")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	tsv, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error running tesseract: %s", stderr.String()))
+	}
+	return parseTesseractTsv(string(tsv)), nil
+}
+
+func (p *LocalProvider) Poll(app *application.AppContext, job *Job) (bool, error) {
+	return job.Status != JobRunning, nil
+}
+
+func (p *LocalProvider) Results(app *application.AppContext, job *Job) ([]OcsResponse, error) {
+	return job.responses, nil
+}
@@ -0,0 +1,195 @@
+package ocr
+
+import (
+	"cloud.google.com/go/storage"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+const ProviderVision = "vision"
+const ProviderLocal = "local"
+
+const JobRunning = "running"
+const JobDone = "done"
+const JobFailed = "failed"
+
+// pollInterval is the wait between two polls of a running job
+const pollInterval = 10 * time.Second
+
+// Job is a document submitted to a Provider
+type Job struct {
+	ID        string
+	Provider  string
+	Document  string //path in the fetched bucket
+	OcrPrefix string
+	Status    string
+	Error     string
+	Submitted time.Time
+
+	//results of providers not writing to the ocr bucket themselves
+	responses []OcsResponse
+}
+
+// Provider runs the ocr of a pdf in the fetched bucket, the results are normalised to one OcsResponse per page
+type Provider interface {
+	Name() string
+	Submit(app *application.AppContext, document string, ocrPrefix string) (*Job, error)
+	Poll(app *application.AppContext, job *Job) (bool, error)
+	Results(app *application.AppContext, job *Job) ([]OcsResponse, error)
+}
+
+// NewProvider returns the provider selected by Config.GetOcrProvider (vision is default),
+// vision if the local provider is selected but not installed
+func NewProvider(app *application.AppContext) Provider {
+	switch app.Config.GetOcrProvider() {
+	case ProviderLocal:
+		local := NewLocalProvider()
+		if local.Available() {
+			return local
+		}
+		slog.Warn("ocr provider %s selected but neither ocrmypdf nor tesseract installed, use %s", ProviderLocal, ProviderVision)
+		return &VisionProvider{}
+	default:
+		return &VisionProvider{}
+	}
+}
+
+// Run submit the document, wait for the job and write the results to the ocr bucket
+func Run(app *application.AppContext, provider Provider, document string, ocrPrefix string) ([]OcsResponse, error) {
+
+	job, err := provider.Submit(app, document, ocrPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error submitting %s to %s", document, provider.Name()))
+	}
+	slog.Info("ocr job %s for %s submitted to %s", job.ID, document, provider.Name())
+
+	for {
+		done, errPoll := provider.Poll(app, job)
+		if errPoll != nil {
+			return nil, errors.Wrap(errPoll, fmt.Sprintf("error in ocr job %s for %s", job.ID, document))
+		}
+		if done {
+			break
+		}
+		select {
+		case <-app.Ctx().Done():
+			return nil, app.Ctx().Err()
+		case <-time.After(pollInterval):
+		}
+	}
+
+	responses, err := provider.Results(app, job)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading results of ocr job %s for %s", job.ID, document))
+	}
+
+	if job.responses != nil {
+		err = writeOcrResult(app, ocrPrefix+"-"+provider.Name()+".json", &OcrJsonoutput{
+			InputConfig: OcrInputConfig{
+				GcsSource: OcrGcsSource{Uri: fmt.Sprintf("gs://%s/%s", app.Config.GetBucketFetched(), document)},
+				MimeType:  "application/pdf",
+			},
+			Responses: responses,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slog.Info("ocr of %s done by %s with %d pages", document, provider.Name(), len(responses))
+
+	err = RenderDocument(app, document, ocrPrefix)
+	if err != nil {
+		slog.Error("error rendering ocr of %s: %v", document, err)
+	}
+	return responses, nil
+}
+
+// HandleOcrRequest run the ocr of a document queued by ProcessDocument
+func HandleOcrRequest(app *application.AppContext, req *OcrRequest) error {
+
+	ocrPrefix := req.OcrPrefix
+	if ocrPrefix == "" {
+		var err error
+		ocrPrefix, err = files.GetOcrPrefix(app, req.Name)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := Run(app, NewProvider(app), req.Name, ocrPrefix)
+	return err
+}
+
+// sourceTextLayer is the source of the results of the text layer extraction
+const sourceTextLayer = "textlayer"
+
+// ocrSource returns the source of an ocr result of the prefix: the text layer, a provider writing one json
+// (ocrPrefix-<provider>.json) or vision (ocrPrefix + output-<from>-to-<to>.json), empty for objects of other documents
+func ocrSource(ocrPrefix string, name string) string {
+	rest := strings.TrimPrefix(name, ocrPrefix)
+	switch {
+	case rest == TextLayerSuffix:
+		return sourceTextLayer
+	case rest == "-"+ProviderLocal+".json":
+		return ProviderLocal
+	case strings.HasPrefix(rest, "output-") && strings.HasSuffix(rest, ".json"):
+		return ProviderVision
+	default:
+		return ""
+	}
+}
+
+// SelectOcrResults returns the ocr results of one source of the prefix, there may be several (a text layer
+// found garbage and the ocr of the document, the ocr of different providers): the most recently written one
+func SelectOcrResults(objects []*storage.ObjectAttrs, ocrPrefix string) []*storage.ObjectAttrs {
+
+	latest := make(map[string]time.Time)
+	bySource := make(map[string][]*storage.ObjectAttrs)
+	for _, o := range objects {
+		source := ocrSource(ocrPrefix, o.Name)
+		if source == "" {
+			continue
+		}
+		bySource[source] = append(bySource[source], o)
+		if o.Updated.After(latest[source]) {
+			latest[source] = o.Updated
+		}
+	}
+
+	selected := ""
+	for source, t := range latest {
+		if selected == "" || t.After(latest[selected]) || (t.Equal(latest[selected]) && source < selected) {
+			selected = source
+		}
+	}
+	return bySource[selected]
+}
+
+// readOcrResults read the json outputs of one source with the prefix from the ocr bucket ordered by page
+func readOcrResults(app *application.AppContext, ocrPrefix string) ([]OcsResponse, error) {
+
+	objects, err := files.ListObjectAttrs(app, app.Config.GetBucketOcr(), ocrPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var responses []OcsResponse
+	for _, o := range SelectOcrResults(objects, ocrPrefix) {
+		out, errRead := ReadOcrFromFile(app, o.Name, app.Config.GetBucketOcr())
+		if errRead != nil {
+			return nil, errors.Wrap(errRead, fmt.Sprintf("error reading ocr result %s", o.Name))
+		}
+		responses = append(responses, out.Responses...)
+	}
+
+	sort.SliceStable(responses, func(i, j int) bool {
+		return responses[i].Context.PageNumber < responses[j].Context.PageNumber
+	})
+	return responses, nil
+}
@@ -0,0 +1,290 @@
+package ocr
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/slog"
+	vision2 "google.golang.org/api/vision/v1"
+	"html/template"
+	"strings"
+)
+
+const HtmlSuffix = ".html"
+const HocrSuffix = ".hocr"
+
+type renderDocument struct {
+	Title string
+	Pages []*renderPage
+}
+
+type renderPage struct {
+	Nummer     int
+	Anker      string
+	BBox       string
+	Paragraphs []*renderParagraph
+}
+
+type renderParagraph struct {
+	BBox  string
+	Lines []*renderLine
+}
+
+type renderLine struct {
+	Words []*renderWord
+}
+
+type renderWord struct {
+	BBox string
+	Conf int
+	Text string
+}
+
+func (l *renderLine) Text() string {
+	var texts []string
+	for _, w := range l.Words {
+		texts = append(texts, w.Text)
+	}
+	return strings.Join(texts, " ")
+}
+
+var htmlTemplate = template.Must(template.New("html").Parse(`<!DOCTYPE html>
+<html lang="de">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+</head>
+<body>
+<header><h1>{{.Title}}</h1></header>
+<nav aria-label="Seiten"><ol>{{range .Pages}}<li><a href="#{{.Anker}}">Seite {{.Nummer}}</a></li>{{end}}</ol></nav>
+<main>
+{{range .Pages}}<section id="{{.Anker}}" aria-labelledby="{{.Anker}}-titel">
+<h2 id="{{.Anker}}-titel"><a href="#{{.Anker}}">Seite {{.Nummer}}</a></h2>
+{{range .Paragraphs}}<p>{{range $i, $l := .Lines}}{{if $i}}<br>
+{{end}}{{$l.Text}}{{end}}</p>
+{{end}}</section>
+{{end}}</main>
+</body>
+</html>
+`))
+
+var hocrTemplate = template.Must(template.New("hocr").Parse(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="de" lang="de">
+<head>
+<title>{{.Title}}</title>
+<meta http-equiv="Content-Type" content="text/html;charset=utf-8" />
+<meta name="ocr-system" content="allris-common" />
+<meta name="ocr-capabilities" content="ocr_page ocr_par ocr_line ocrx_word" />
+</head>
+<body>
+{{range .Pages}}<div class="ocr_page" id="{{.Anker}}" title="{{.BBox}}; ppageno {{.Nummer}}">
+{{range .Paragraphs}}<p class="ocr_par" title="{{.BBox}}">
+{{range .Lines}}<span class="ocr_line">{{range .Words}}<span class="ocrx_word" title="{{.BBox}}; x_wconf {{.Conf}}">{{.Text}}</span> {{end}}</span>
+{{end}}</p>
+{{end}}</div>
+{{end}}</body>
+</html>
+`))
+
+// PageAnker returns the id of a page in the rendered html, e.g. for links from search hits
+func PageAnker(page int) string {
+	return fmt.Sprintf("seite-%d", page)
+}
+
+// PageLink returns the path of a page in the ocr html bucket
+func PageLink(ocrPrefix string, page int) string {
+	return ocrPrefix + HtmlSuffix + "#" + PageAnker(page)
+}
+
+// RenderHtml render the ocr results as html with one section per page
+func RenderHtml(title string, responses []OcsResponse) ([]byte, error) {
+	return render(htmlTemplate, title, responses)
+}
+
+// RenderHocr render the ocr results as hOCR with the bounding boxes of paragraphs and words
+func RenderHocr(title string, responses []OcsResponse) ([]byte, error) {
+	return render(hocrTemplate, title, responses)
+}
+
+func render(tmpl *template.Template, title string, responses []OcsResponse) ([]byte, error) {
+
+	doc := &renderDocument{Title: title}
+	for i, resp := range responses {
+		nummer := resp.Context.PageNumber
+		if nummer <= 0 {
+			nummer = i + 1
+		}
+		doc.Pages = append(doc.Pages, newRenderPage(nummer, resp.FullTextAnnotation))
+	}
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, doc)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error rendering %s", title))
+	}
+	return buf.Bytes(), nil
+}
+
+func newRenderPage(nummer int, annot OcrFullTextAnnotation) *renderPage {
+
+	page := &renderPage{Nummer: nummer, Anker: PageAnker(nummer), BBox: "bbox 0 0 0 0"}
+
+	//without layout (text layer or ocrmypdf) the paragraphs are separated by empty lines
+	if len(annot.Pages) == 0 {
+		for _, par := range strings.Split(annot.Text, "\n\n") {
+			p := &renderParagraph{BBox: page.BBox}
+			for _, line := range strings.Split(par, "\n") {
+				l := &renderLine{}
+				for _, w := range strings.Fields(line) {
+					l.Words = append(l.Words, &renderWord{BBox: page.BBox, Text: w})
+				}
+				if len(l.Words) > 0 {
+					p.Lines = append(p.Lines, l)
+				}
+			}
+			if len(p.Lines) > 0 {
+				page.Paragraphs = append(page.Paragraphs, p)
+			}
+		}
+		return page
+	}
+
+	for _, vp := range annot.Pages {
+		page.BBox = fmt.Sprintf("bbox 0 0 %d %d", vp.Width, vp.Height)
+		for _, block := range vp.Blocks {
+			for _, par := range block.Paragraphs {
+				page.Paragraphs = append(page.Paragraphs, newRenderParagraph(par, vp.Width, vp.Height))
+			}
+		}
+	}
+	return page
+}
+
+// newRenderParagraph split the words of a paragraph into lines by the detected breaks of the last symbols
+func newRenderParagraph(par *vision2.Paragraph, width int64, height int64) *renderParagraph {
+
+	p := &renderParagraph{BBox: bbox(par.BoundingBox, width, height)}
+	line := &renderLine{}
+	for _, w := range par.Words {
+		var text strings.Builder
+		breakType := ""
+		for _, s := range w.Symbols {
+			text.WriteString(s.Text)
+			if s.Property != nil && s.Property.DetectedBreak != nil {
+				breakType = s.Property.DetectedBreak.Type
+			}
+		}
+		if breakType == "HYPHEN" {
+			text.WriteString("-")
+		}
+		line.Words = append(line.Words, &renderWord{
+			BBox: bbox(w.BoundingBox, width, height),
+			Conf: int(w.Confidence * 100),
+			Text: text.String(),
+		})
+		if breakType == "EOL_SURE_SPACE" || breakType == "LINE_BREAK" || breakType == "HYPHEN" {
+			p.Lines = append(p.Lines, line)
+			line = &renderLine{}
+		}
+	}
+	if len(line.Words) > 0 {
+		p.Lines = append(p.Lines, line)
+	}
+	return p
+}
+
+// bbox returns the hOCR bounding box, Vision returns normalized vertices for pdf
+func bbox(poly *vision2.BoundingPoly, width int64, height int64) string {
+
+	if poly == nil {
+		return "bbox 0 0 0 0"
+	}
+
+	var xs, ys []int64
+	for _, v := range poly.Vertices {
+		xs = append(xs, v.X)
+		ys = append(ys, v.Y)
+	}
+	for _, v := range poly.NormalizedVertices {
+		xs = append(xs, int64(v.X*float64(width)))
+		ys = append(ys, int64(v.Y*float64(height)))
+	}
+	if len(xs) == 0 {
+		return "bbox 0 0 0 0"
+	}
+
+	x0, y0, x1, y1 := xs[0], ys[0], xs[0], ys[0]
+	for i := range xs {
+		if xs[i] < x0 {
+			x0 = xs[i]
+		}
+		if xs[i] > x1 {
+			x1 = xs[i]
+		}
+		if ys[i] < y0 {
+			y0 = ys[i]
+		}
+		if ys[i] > y1 {
+			y1 = ys[i]
+		}
+	}
+	return fmt.Sprintf("bbox %d %d %d %d", x0, y0, x1, y1)
+}
+
+// hasLayout returns true if every page has the layout of Vision or tesseract (tsv), the bounding boxes of hOCR
+func hasLayout(responses []OcsResponse) bool {
+	for _, resp := range responses {
+		if len(resp.FullTextAnnotation.Pages) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RenderDocument render the ocr results of a document to the ocr html bucket (hOCR if Config.GetOcrHocr)
+func RenderDocument(app *application.AppContext, document string, ocrPrefix string) error {
+
+	responses, err := readOcrResults(app, ocrPrefix)
+	if err != nil {
+		return err
+	}
+	if len(responses) == 0 {
+		return errors.New(fmt.Sprintf("no ocr results for %s", ocrPrefix))
+	}
+
+	outputs := map[string]func(string, []OcsResponse) ([]byte, error){HtmlSuffix: RenderHtml}
+	if app.Config.GetOcrHocr() {
+		if hasLayout(responses) {
+			outputs[HocrSuffix] = RenderHocr
+		} else {
+			slog.Info("no layout in the ocr results of %s (text layer or ocrmypdf), no hOCR", document)
+		}
+	}
+
+	for suffix, renderer := range outputs {
+		data, errRender := renderer(document, responses)
+		if errRender != nil {
+			return errRender
+		}
+
+		wc := app.Store().Bucket(app.Config.GetBucketOcrHtml()).Object(ocrPrefix + suffix).NewWriter(app.Ctx())
+		wc.ContentType = "text/html; charset=utf-8"
+		if suffix == HocrSuffix {
+			wc.ContentType = "application/xhtml+xml; charset=utf-8"
+		}
+		wc.ContentLanguage = "de"
+		_, err = wc.Write(data)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error writing %s%s", ocrPrefix, suffix))
+		}
+		err = wc.Close()
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error writing %s%s", ocrPrefix, suffix))
+		}
+	}
+
+	slog.Info("rendered %d pages of %s to %s", len(responses), document, app.Config.GetBucketOcrHtml())
+	return nil
+}
@@ -0,0 +1,247 @@
+package ocr
+
+import (
+	"bytes"
+	"cloud.google.com/go/pubsub"
+	"encoding/json"
+	"fmt"
+	"github.com/ledongthuc/pdf"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/slog"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// TextLayerSuffix is appended to the ocr prefix of a document for results of the text layer extraction
+const TextLayerSuffix = "-textlayer.json"
+
+// minCharsPerPage below this average the pdf is treated as scanned
+const minCharsPerPage = 50
+
+// minPlausible is the minimal part of letters, digits, spaces and common punctuation in the text
+const minPlausible = 0.85
+
+// minWordRatio is the minimal part of words with at least two letters
+const minWordRatio = 0.5
+
+// lineTolerance is the maximal difference in points of the baseline of characters in the same line
+const lineTolerance = 2.0
+
+// spaceWidth is the minimal gap between two words relative to the font size
+const spaceWidth = 0.15
+
+// OcrRequest is published to the ocr topic for documents without usable text layer
+type OcrRequest struct {
+	Bucket    string
+	Name      string
+	OcrBucket string
+	OcrPrefix string
+}
+
+// ExtractTextLayer read the text of every page of a pdf into the structure of the Vision json output
+func ExtractTextLayer(content []byte, uri string) (out *OcrJsonoutput, err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			out = nil
+			err = errors.New(fmt.Sprintf("error reading pdf %s: %v", uri, r))
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error opening pdf %s", uri))
+	}
+
+	out = &OcrJsonoutput{
+		InputConfig: OcrInputConfig{
+			GcsSource: OcrGcsSource{Uri: uri},
+			MimeType:  "application/pdf",
+		},
+	}
+
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+
+		out.Responses = append(out.Responses, OcsResponse{
+			FullTextAnnotation: OcrFullTextAnnotation{Text: pageText(page.Content().Text)},
+			Context:            OcrContext{Uri: uri, PageNumber: i},
+		})
+	}
+	return out, nil
+}
+
+// pageText build the lines of a page from its characters, a space is inserted where the gap
+// between two characters is wider than spaceWidth of the font size
+func pageText(chars []pdf.Text) string {
+
+	sort.SliceStable(chars, func(i, j int) bool {
+		if math.Abs(chars[i].Y-chars[j].Y) > lineTolerance {
+			return chars[i].Y > chars[j].Y
+		}
+		return chars[i].X < chars[j].X
+	})
+
+	var lines []string
+	var line strings.Builder
+	for i, c := range chars {
+		if i > 0 {
+			prev := chars[i-1]
+			if math.Abs(c.Y-prev.Y) > lineTolerance {
+				lines = appendLine(lines, line.String())
+				line.Reset()
+			} else if c.X-(prev.X+prev.W) > spaceWidth*c.FontSize && !strings.HasSuffix(line.String(), " ") {
+				line.WriteString(" ")
+			}
+		}
+		line.WriteString(c.S)
+	}
+	lines = appendLine(lines, line.String())
+	return strings.Join(lines, "\n")
+}
+
+func appendLine(lines []string, line string) []string {
+	line = strings.Join(strings.Fields(line), " ")
+	if line == "" {
+		return lines
+	}
+	return append(lines, line)
+}
+
+// IsGarbage returns true if the text layer is empty or not readable (e.g. fonts without unicode mapping)
+func IsGarbage(out *OcrJsonoutput) bool {
+
+	if out == nil || len(out.Responses) == 0 {
+		return true
+	}
+
+	var text strings.Builder
+	for _, r := range out.Responses {
+		text.WriteString(r.FullTextAnnotation.Text)
+		text.WriteString("\n")
+	}
+	s := text.String()
+
+	total := 0
+	plausible := 0
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		total++
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune(".,;:!?-–()[]/%€§\"'„“&+*=", r) {
+			plausible++
+		}
+	}
+	if total < minCharsPerPage*len(out.Responses) {
+		return true
+	}
+	if float64(plausible)/float64(total) < minPlausible {
+		return true
+	}
+
+	words := strings.Fields(s)
+	realWords := 0
+	for _, w := range words {
+		letters := 0
+		for _, r := range w {
+			if unicode.IsLetter(r) {
+				letters++
+			}
+		}
+		if letters >= 2 {
+			realWords++
+		}
+	}
+	return float64(realWords)/float64(len(words)) < minWordRatio
+}
+
+// ProcessDocument write the text layer of a pdf in the fetched bucket as ocr result,
+// documents without usable text layer are published to the ocr topic
+func ProcessDocument(app *application.AppContext, path string) error {
+
+	file := files.NewFileFromStore(app, "", path)
+	err := file.ReadDocumentInfo(app.Config.GetBucketFetched())
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading info of %s", path))
+	}
+	err = file.ReadDocument(app.Config.GetBucketFetched())
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading %s", path))
+	}
+
+	uri, err := files.GetDocumentUri(app, path)
+	if err != nil {
+		return err
+	}
+	out, err := ExtractTextLayer(file.GetContent(), uri)
+	if err != nil {
+		slog.Warn("no text layer in %s: %v", path, err)
+	}
+
+	if err != nil || IsGarbage(out) {
+		return queueForOcr(app, path, file.GetOcrPrefix())
+	}
+
+	err = writeOcrResult(app, file.GetOcrPrefix()+TextLayerSuffix, out)
+	if err != nil {
+		return err
+	}
+	slog.Info("text layer of %s with %d pages written to %s", path, len(out.Responses), file.GetOcrPrefix())
+
+	err = RenderDocument(app, path, file.GetOcrPrefix())
+	if err != nil {
+		slog.Error("error rendering text layer of %s: %v", path, err)
+	}
+	return nil
+}
+
+func writeOcrResult(app *application.AppContext, name string, out *OcrJsonoutput) error {
+
+	data, err := json.Marshal(out)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error serializing ocr result %s", name))
+	}
+
+	wc := app.Store().Bucket(app.Config.GetBucketOcr()).Object(name).NewWriter(app.Ctx())
+	wc.ContentType = "application/json"
+	_, err = wc.Write(data)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing ocr result %s", name))
+	}
+	return wc.Close()
+}
+
+// queueForOcr publish the document to the ocr topic, the local provider runs without queue
+func queueForOcr(app *application.AppContext, path string, ocrPrefix string) error {
+
+	req := &OcrRequest{
+		Bucket:    app.Config.GetBucketFetched(),
+		Name:      path,
+		OcrBucket: app.Config.GetBucketOcr(),
+		OcrPrefix: ocrPrefix,
+	}
+	if NewProvider(app).Name() == ProviderLocal {
+		return HandleOcrRequest(app, req)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	res := app.Publisher().Topic(app.Config.GetOcrTopic()).Publish(app.Ctx(), &pubsub.Message{Data: data})
+	_, err = res.Get(app.Ctx())
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error queueing %s for ocr", path))
+	}
+	slog.Info("queued %s for ocr", path)
+	return nil
+}
@@ -0,0 +1,123 @@
+package ocr
+
+import (
+	vision2 "google.golang.org/api/vision/v1"
+	"strconv"
+	"strings"
+)
+
+// levels of the rows of the tesseract tsv output
+const (
+	tsvPage = 1 + iota
+	tsvBlock
+	tsvParagraph
+	tsvLine
+	tsvWord
+)
+
+// tsvColumns is the number of columns of the tesseract tsv output:
+// level page_num block_num par_num line_num word_num left top width height conf text
+const tsvColumns = 12
+
+// parseTesseractTsv map the tsv output of tesseract to the layout of Vision (pages, blocks, paragraphs, words),
+// the text of a page has lines separated by a line break and paragraphs by an empty line
+func parseTesseractTsv(tsv string) []OcrFullTextAnnotation {
+
+	var annots []OcrFullTextAnnotation
+	var page *vision2.Page
+	var block *vision2.Block
+	var par *vision2.Paragraph
+	var lastWord *vision2.Word
+	var text strings.Builder
+
+	endLine := func() {
+		if lastWord != nil {
+			setBreak(lastWord, "EOL_SURE_SPACE")
+			lastWord = nil
+			text.WriteString("\n")
+		}
+	}
+	endPage := func() {
+		endLine()
+		if page != nil {
+			annots = append(annots, OcrFullTextAnnotation{Text: strings.TrimSpace(text.String()), Pages: []vision2.Page{*page}})
+		}
+		page, block, par = nil, nil, nil
+		text.Reset()
+	}
+
+	for i, row := range strings.Split(strings.ReplaceAll(tsv, "\r\n", "\n"), "\n") {
+		cols := strings.SplitN(row, "\t", tsvColumns)
+		if i == 0 || len(cols) < tsvColumns-1 {
+			continue
+		}
+		level, err := strconv.Atoi(cols[0])
+		if err != nil {
+			continue
+		}
+		box := tsvBox(cols[6:10])
+
+		switch level {
+		case tsvPage:
+			endPage()
+			page = &vision2.Page{Width: box.Vertices[2].X, Height: box.Vertices[2].Y}
+		case tsvBlock:
+			if page == nil {
+				continue
+			}
+			endLine()
+			block = &vision2.Block{BoundingBox: box}
+			page.Blocks = append(page.Blocks, block)
+		case tsvParagraph:
+			if block == nil {
+				continue
+			}
+			endLine()
+			if len(block.Paragraphs) > 0 || len(page.Blocks) > 1 {
+				text.WriteString("\n")
+			}
+			par = &vision2.Paragraph{BoundingBox: box}
+			block.Paragraphs = append(block.Paragraphs, par)
+		case tsvLine:
+			endLine()
+		case tsvWord:
+			if par == nil || len(cols) < tsvColumns || strings.TrimSpace(cols[11]) == "" {
+				continue
+			}
+			conf, _ := strconv.ParseFloat(cols[10], 64)
+			if lastWord != nil {
+				setBreak(lastWord, "SPACE")
+				text.WriteString(" ")
+			}
+			word := &vision2.Word{
+				BoundingBox: box,
+				Confidence:  conf / 100,
+				Symbols:     []*vision2.Symbol{{Text: strings.TrimSpace(cols[11])}},
+			}
+			par.Words = append(par.Words, word)
+			text.WriteString(word.Symbols[0].Text)
+			lastWord = word
+		}
+	}
+	endPage()
+	return annots
+}
+
+// tsvBox returns the bounding box of left, top, width and height
+func tsvBox(cols []string) *vision2.BoundingPoly {
+
+	var n [4]int64
+	for i, c := range cols {
+		n[i], _ = strconv.ParseInt(c, 10, 64)
+	}
+	left, top, right, bottom := n[0], n[1], n[0]+n[2], n[1]+n[3]
+	return &vision2.BoundingPoly{Vertices: []*vision2.Vertex{
+		{X: left, Y: top}, {X: right, Y: top}, {X: right, Y: bottom}, {X: left, Y: bottom},
+	}}
+}
+
+// setBreak set the break after the word as detected by Vision on the last symbol
+func setBreak(word *vision2.Word, breakType string) {
+	last := word.Symbols[len(word.Symbols)-1]
+	last.Property = &vision2.TextProperty{DetectedBreak: &vision2.DetectedBreak{Type: breakType}}
+}
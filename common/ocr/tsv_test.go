@@ -0,0 +1,66 @@
+package ocr
+
+import (
+	"strings"
+	"testing"
+)
+
+const tesseractTsv = "level\tpage_num\tblock_num\tpar_num\tline_num\tword_num\tleft\ttop\twidth\theight\tconf\ttext\n" +
+	"1\t1\t0\t0\t0\t0\t0\t0\t2480\t3508\t-1\t\n" +
+	"2\t1\t1\t0\t0\t0\t236\t300\t1200\t120\t-1\t\n" +
+	"3\t1\t1\t1\t0\t0\t236\t300\t1200\t120\t-1\t\n" +
+	"4\t1\t1\t1\t1\t0\t236\t300\t1200\t50\t-1\t\n" +
+	"5\t1\t1\t1\t1\t1\t236\t300\t400\t50\t96.5\tBeschluss-\n" +
+	"5\t1\t1\t1\t1\t2\t650\t300\t300\t50\t91\tvorlage\n" +
+	"4\t1\t1\t1\t2\t0\t236\t370\t800\t50\t-1\t\n" +
+	"5\t1\t1\t1\t2\t1\t236\t370\t800\t50\t88\tNr. 12\n" +
+	"2\t1\t2\t0\t0\t0\t236\t600\t900\t50\t-1\t\n" +
+	"3\t1\t2\t1\t0\t0\t236\t600\t900\t50\t-1\t\n" +
+	"4\t1\t2\t1\t1\t0\t236\t600\t900\t50\t-1\t\n" +
+	"5\t1\t2\t1\t1\t1\t236\t600\t900\t50\t-1\t \n" +
+	"5\t1\t2\t1\t1\t2\t236\t600\t900\t50\t90\tBegründung\n"
+
+func TestParseTesseractTsv(t *testing.T) {
+
+	annots := parseTesseractTsv(tesseractTsv)
+	if len(annots) != 1 || len(annots[0].Pages) != 1 {
+		t.Fatalf("%d pages, want 1", len(annots))
+	}
+
+	want := "Beschluss- vorlage\nNr. 12\n\nBegründung"
+	if annots[0].Text != want {
+		t.Errorf("Text = %q, want %q", annots[0].Text, want)
+	}
+
+	page := annots[0].Pages[0]
+	if page.Width != 2480 || page.Height != 3508 || len(page.Blocks) != 2 {
+		t.Errorf("page %dx%d with %d blocks, want 2480x3508 with 2", page.Width, page.Height, len(page.Blocks))
+	}
+	words := page.Blocks[0].Paragraphs[0].Words
+	if len(words) != 3 || words[0].Confidence != 0.965 || words[1].Symbols[0].Property.DetectedBreak.Type != "EOL_SURE_SPACE" {
+		t.Errorf("words of the first paragraph %+v", words)
+	}
+}
+
+func TestRenderHocrLocal(t *testing.T) {
+
+	annots := parseTesseractTsv(tesseractTsv)
+	responses := []OcsResponse{{FullTextAnnotation: annots[0], Context: OcrContext{PageNumber: 1}}}
+	if !hasLayout(responses) {
+		t.Fatal("no layout in the tesseract result")
+	}
+
+	hocr, err := RenderHocr("test.png", responses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"bbox 0 0 2480 3508", "bbox 236 300 636 350", "Begründung"} {
+		if !strings.Contains(string(hocr), s) {
+			t.Errorf("hOCR without %q", s)
+		}
+	}
+
+	if hasLayout([]OcsResponse{{FullTextAnnotation: OcrFullTextAnnotation{Text: "ocrmypdf"}}}) {
+		t.Error("layout in a result without pages")
+	}
+}
@@ -0,0 +1,99 @@
+package ocr
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/files"
+	vision2 "google.golang.org/api/vision/v1"
+	"time"
+)
+
+// visionBatchSize is the number of pages per json output written by Vision
+const visionBatchSize = 20
+
+// VisionProvider runs the ocr with the async file annotation of Google Vision, the results are written to the ocr bucket
+type VisionProvider struct {
+	service *vision2.Service
+}
+
+func (p *VisionProvider) Name() string {
+	return ProviderVision
+}
+
+func (p *VisionProvider) getService(app *application.AppContext) (*vision2.Service, error) {
+	if p.service == nil {
+		service, err := vision2.NewService(app.Ctx())
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating vision service")
+		}
+		p.service = service
+	}
+	return p.service, nil
+}
+
+func (p *VisionProvider) Submit(app *application.AppContext, document string, ocrPrefix string) (*Job, error) {
+
+	service, err := p.getService(app)
+	if err != nil {
+		return nil, err
+	}
+
+	uri, err := files.GetDocumentUri(app, document)
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := service.Files.AsyncBatchAnnotate(&vision2.AsyncBatchAnnotateFilesRequest{
+		Requests: []*vision2.AsyncAnnotateFileRequest{{
+			Features: []*vision2.Feature{{Type: "DOCUMENT_TEXT_DETECTION"}},
+			InputConfig: &vision2.InputConfig{
+				GcsSource: &vision2.GcsSource{Uri: uri},
+				MimeType:  "application/pdf",
+			},
+			OutputConfig: &vision2.OutputConfig{
+				BatchSize:      visionBatchSize,
+				GcsDestination: &vision2.GcsDestination{Uri: fmt.Sprintf("gs://%s/%s", app.Config.GetBucketOcr(), ocrPrefix)},
+			},
+		}},
+	}).Context(app.Ctx()).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{
+		ID:        op.Name,
+		Provider:  p.Name(),
+		Document:  document,
+		OcrPrefix: ocrPrefix,
+		Status:    JobRunning,
+		Submitted: time.Now(),
+	}, nil
+}
+
+func (p *VisionProvider) Poll(app *application.AppContext, job *Job) (bool, error) {
+
+	service, err := p.getService(app)
+	if err != nil {
+		return false, err
+	}
+
+	op, err := service.Operations.Get(job.ID).Context(app.Ctx()).Do()
+	if err != nil {
+		return false, err
+	}
+	if !op.Done {
+		return false, nil
+	}
+	if op.Error != nil {
+		job.Status = JobFailed
+		job.Error = op.Error.Message
+		return true, errors.New(fmt.Sprintf("vision operation %s failed: %s", job.ID, op.Error.Message))
+	}
+	job.Status = JobDone
+	return true, nil
+}
+
+func (p *VisionProvider) Results(app *application.AppContext, job *Job) ([]OcsResponse, error) {
+	return readOcrResults(app, job.OcrPrefix)
+}
@@ -0,0 +1,154 @@
+package taxonomy
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/textnorm"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Thema is a topic with the keywords of its texts, e.g.
+//
+//	Verkehr:
+//	  begriffe: [verkehr, radweg, "*parkplatz", bus]
+//	  ausschluss: [verkehrssicherungspflicht]
+//	  mindestens: 1
+//
+// a keyword matches words starting with it, with a leading * anywhere in the word
+type Thema struct {
+	Begriffe   []string `yaml:"begriffe"`
+	Ausschluss []string `yaml:"ausschluss"`
+	Mindestens int      `yaml:"mindestens"`
+}
+
+// Taxonomy are the topics by name
+type Taxonomy struct {
+	Themen map[string]*Thema
+}
+
+// Parse read the taxonomy from yaml, a topic is a map as Thema or only the list of keywords
+func Parse(data []byte) (*Taxonomy, error) {
+
+	var raw map[string]yaml.Node
+	err := yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing taxonomy")
+	}
+
+	t := &Taxonomy{Themen: make(map[string]*Thema)}
+	for name, node := range raw {
+		thema := &Thema{}
+		if node.Kind == yaml.SequenceNode {
+			err = node.Decode(&thema.Begriffe)
+		} else {
+			err = node.Decode(thema)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error parsing topic %s", name))
+		}
+		if thema.Mindestens <= 0 {
+			thema.Mindestens = 1
+		}
+		thema.Begriffe = normalize(thema.Begriffe)
+		thema.Ausschluss = normalize(thema.Ausschluss)
+		t.Themen[name] = thema
+	}
+	return t, nil
+}
+
+// Load read the taxonomy from a local yaml file
+func Load(path string) (*Taxonomy, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading taxonomy %s", path))
+	}
+	return Parse(data)
+}
+
+var taxonomyMutex sync.Mutex
+var taxonomies = make(map[string]*Taxonomy)
+
+// ForFile returns the taxonomy of the local file (loaded once), nil if path is empty or not readable
+func ForFile(path string) (*Taxonomy, error) {
+
+	taxonomyMutex.Lock()
+	defer taxonomyMutex.Unlock()
+
+	t, exist := taxonomies[path]
+	if exist || path == "" {
+		return t, nil
+	}
+	t, err := Load(path)
+	taxonomies[path] = t
+	return t, err
+}
+
+func normalize(begriffe []string) []string {
+	var result []string
+	for _, b := range begriffe {
+		b = strings.ToLower(textnorm.FoldUmlauts(strings.TrimSpace(b)))
+		if b != "" && b != "*" {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// Classify returns the sorted names of the topics of the texts, nil without taxonomy
+func (t *Taxonomy) Classify(texts ...string) []string {
+
+	if t == nil {
+		return nil
+	}
+
+	var words []string
+	for _, text := range texts {
+		for _, w := range textnorm.Words(text) {
+			words = append(words, strings.ToLower(textnorm.FoldUmlauts(w)))
+		}
+	}
+
+	var result []string
+	for name, thema := range t.Themen {
+		if thema.matches(words) {
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (thema *Thema) matches(words []string) bool {
+
+	treffer := 0
+	for _, w := range words {
+		if matchAny(w, thema.Ausschluss) {
+			continue
+		}
+		if matchAny(w, thema.Begriffe) {
+			treffer++
+			if treffer >= thema.Mindestens {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(word string, begriffe []string) bool {
+	for _, b := range begriffe {
+		if strings.HasPrefix(b, "*") {
+			if strings.Contains(word, b[1:]) {
+				return true
+			}
+		} else if strings.HasPrefix(word, b) {
+			return true
+		}
+	}
+	return false
+}
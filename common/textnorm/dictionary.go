@@ -0,0 +1,112 @@
+package textnorm
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// minPart is the minimal length of a part of a compound noun
+const minPart = 3
+
+// fugen are the linking elements between the parts of a compound, e.g. "Bebauung-s-plan"
+var fugen = []string{"", "s", "es", "n", "en", "er", "e"}
+
+// Dictionary is a list of words used to split compound nouns
+type Dictionary struct {
+	words map[string]bool
+}
+
+// NewDictionary create a dictionary of the words (case insensitive)
+func NewDictionary(words []string) *Dictionary {
+	d := &Dictionary{words: make(map[string]bool)}
+	for _, w := range words {
+		w = strings.ToLower(strings.TrimSpace(w))
+		if utf8.RuneCountInString(w) >= minPart {
+			d.words[w] = true
+		}
+	}
+	return d
+}
+
+// ReadDictionary read a dictionary with one word per line, lines starting with # are skipped
+func ReadDictionary(r io.Reader) (*Dictionary, error) {
+
+	var words []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return NewDictionary(words), nil
+}
+
+// LoadDictionary read the dictionary from a local file
+func LoadDictionary(path string) (*Dictionary, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error opening dictionary %s", path))
+	}
+	defer f.Close()
+	return ReadDictionary(f)
+}
+
+func (d *Dictionary) Contains(word string) bool {
+	return d.words[strings.ToLower(word)]
+}
+
+func (d *Dictionary) Len() int {
+	return len(d.words)
+}
+
+// Split returns the parts of a compound noun, e.g. "Bebauungsplanverfahren" => bebauung, plan, verfahren,
+// nil if the word is no compound of dictionary words
+func (d *Dictionary) Split(word string) []string {
+
+	w := strings.ToLower(word)
+	if utf8.RuneCountInString(w) < 2*minPart {
+		return nil
+	}
+	parts := d.split(w)
+	if len(parts) < 2 {
+		return nil
+	}
+	return parts
+}
+
+func (d *Dictionary) split(w string) []string {
+
+	if d.words[w] {
+		return []string{w}
+	}
+
+	runes := []rune(w)
+	//the longest head first, "Haushaltsplan" is haushalt + plan rather than haus + halt + plan
+	for i := len(runes) - minPart; i >= minPart; i-- {
+		head := string(runes[:i])
+		for _, fuge := range fugen {
+			if !strings.HasSuffix(head, fuge) {
+				continue
+			}
+			stem := strings.TrimSuffix(head, fuge)
+			if utf8.RuneCountInString(stem) < minPart || !d.words[stem] {
+				continue
+			}
+			if rest := d.split(string(runes[i:])); rest != nil {
+				return append([]string{stem}, rest...)
+			}
+		}
+	}
+	return nil
+}
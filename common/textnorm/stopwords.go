@@ -0,0 +1,24 @@
+package textnorm
+
+// Stopwords are frequent german words without meaning for the search
+var Stopwords = toSet(
+	"aber", "alle", "allem", "allen", "aller", "alles", "als", "also", "am", "an", "ander", "andere", "anderen",
+	"auch", "auf", "aus", "bei", "beim", "bereits", "bis", "bisher", "bzw", "da", "dabei", "dadurch", "dafür",
+	"damit", "dann", "darf", "darin", "das", "dass", "daß", "dem", "den", "denen", "denn", "der", "deren", "des",
+	"dessen", "die", "dies", "diese", "diesem", "diesen", "dieser", "dieses", "doch", "dort", "du", "durch",
+	"ein", "eine", "einem", "einen", "einer", "eines", "er", "es", "etwa", "für", "gegen", "hat", "hatte",
+	"hier", "ihr", "ihre", "ihrem", "ihren", "ihrer", "im", "in", "ins", "ist", "jedoch", "kann", "kein",
+	"keine", "können", "mit", "muss", "nach", "nicht", "noch", "nur", "ob", "oder", "ohne", "sein", "seine",
+	"seinem", "seinen", "seiner", "sich", "sie", "sind", "so", "soll", "sollen", "sondern", "sowie", "über",
+	"um", "und", "uns", "unter", "vom", "von", "vor", "war", "waren", "was", "wegen", "weil", "welche",
+	"welcher", "wenn", "werden", "wie", "wieder", "will", "wir", "wird", "wo", "wurde", "wurden", "zu", "zum",
+	"zur", "zwischen",
+)
+
+func toSet(words ...string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range words {
+		set[w] = true
+	}
+	return set
+}
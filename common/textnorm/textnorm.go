@@ -0,0 +1,150 @@
+package textnorm
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// regexHyphenBreak matches a word hyphenated at a line break, e.g. "Bebauungs-\nplan"
+var regexHyphenBreak = regexp.MustCompile(`(\p{L})[-\x{2010}\x{00AD}][ \t]*\r?\n\s*(\p{Ll}[\p{L}.]*)`)
+
+var ligatures = strings.NewReplacer(
+	"ﬀ", "ff",
+	"ﬁ", "fi",
+	"ﬂ", "fl",
+	"ﬃ", "ffi",
+	"ﬄ", "ffl",
+	"ﬅ", "st",
+	"ﬆ", "st",
+	"Ĳ", "IJ",
+	"ĳ", "ij",
+	"Œ", "OE",
+	"œ", "oe",
+)
+
+var invisible = strings.NewReplacer(
+	"\u00ad", "", //soft hyphen
+	"\u200b", "", //zero width space
+	"\u200c", "",
+	"\u200d", "",
+	"\ufeff", "",
+	"\u00a0", " ",
+)
+
+var umlauts = strings.NewReplacer(
+	"ä", "ae", "ö", "oe", "ü", "ue",
+	"Ä", "Ae", "Ö", "Oe", "Ü", "Ue",
+	"ß", "ss", "ẞ", "SS",
+)
+
+// Normalizer is the text pipeline applied to the text of search records. Normalize only changes the
+// spelling of the text, Terms returns the additional search terms (umlaut variants and compound parts).
+type Normalizer struct {
+	Dehyphenate bool
+	Unicode     bool //NFC and ligature expansion
+	Umlauts     bool //add ae/oe/ue/ss variants of words with umlauts to the terms
+	Compounds   *Dictionary
+	Stopwords   map[string]bool
+}
+
+// NewNormalizer returns a normalizer with all steps, compounds are only split if dict is not nil
+func NewNormalizer(dict *Dictionary) *Normalizer {
+	return &Normalizer{
+		Dehyphenate: true,
+		Unicode:     true,
+		Umlauts:     true,
+		Compounds:   dict,
+		Stopwords:   Stopwords,
+	}
+}
+
+// Normalize returns the text with unicode normalised and hyphenation at line breaks removed
+func (n *Normalizer) Normalize(text string) string {
+
+	if n.Unicode {
+		text = norm.NFC.String(ligatures.Replace(text))
+	}
+	if n.Dehyphenate {
+		text = Dehyphenate(text)
+	}
+	return invisible.Replace(text)
+}
+
+// Dehyphenate join words hyphenated at line breaks, the hyphen is kept before conjunctions as in "Straßen- und Wegebau"
+func Dehyphenate(text string) string {
+	return regexHyphenBreak.ReplaceAllStringFunc(text, func(m string) string {
+		parts := regexHyphenBreak.FindStringSubmatch(m)
+		if keepHyphen[strings.TrimSuffix(parts[2], ".")] {
+			return parts[1] + "- " + parts[2]
+		}
+		return parts[1] + parts[2]
+	})
+}
+
+var keepHyphen = map[string]bool{"und": true, "oder": true, "bzw": true, "sowie": true, "bis": true}
+
+// Terms returns the search terms not contained in the text: umlaut variants and parts of compound nouns without stopwords
+func (n *Normalizer) Terms(text string) []string {
+
+	seen := make(map[string]bool)
+	for _, w := range Words(text) {
+		seen[strings.ToLower(w)] = true
+	}
+
+	var terms []string
+	add := func(t string) {
+		t = strings.ToLower(t)
+		if len([]rune(t)) < 3 || seen[t] || n.Stopwords[t] {
+			return
+		}
+		seen[t] = true
+		terms = append(terms, t)
+	}
+
+	for _, w := range Words(text) {
+		if n.Umlauts {
+			if folded := FoldUmlauts(w); folded != w {
+				add(folded)
+			}
+		}
+		if n.Compounds != nil {
+			for _, part := range n.Compounds.Split(w) {
+				add(part)
+				if n.Umlauts {
+					add(FoldUmlauts(part))
+				}
+			}
+		}
+	}
+	return terms
+}
+
+// FoldUmlauts replace umlauts and ß by their ascii transcription
+func FoldUmlauts(text string) string {
+	return umlauts.Replace(text)
+}
+
+// Words returns the words of text, numbers and punctuation are skipped
+func Words(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+}
+
+// IsStopword returns true for german stopwords
+func IsStopword(word string) bool {
+	return Stopwords[strings.ToLower(word)]
+}
+
+// RemoveStopwords returns the words of text without stopwords, for backends without own stopword handling
+func RemoveStopwords(text string) string {
+	var result []string
+	for _, w := range strings.Fields(text) {
+		if !IsStopword(strings.TrimFunc(w, func(r rune) bool { return !unicode.IsLetter(r) })) {
+			result = append(result, w)
+		}
+	}
+	return strings.Join(result, " ")
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"fmt"
 )
 
@@ -13,3 +14,7 @@ func Md5HashStr(data string) string {
 func Md5HashB(data []byte) string {
 	return fmt.Sprintf("%x", md5.Sum(data))
 }
+
+func Sha256HashB(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
@@ -33,6 +33,12 @@ type Config interface {
 	GetProjectId() string
 	GetBucketFetched() string
 	GetBucketBackup() string
+	GetBucketContent() string
+	GetEntityContentRef() string
+	GetRetentionKeepLast() int
+	GetRetentionKeepFor() time.Duration
+	GetRetentionListKeepLast() int
+	GetRetentionListKeepFor() time.Duration
 	GetMinAgeBeforeDownload() time.Duration
 
 	GetHttpTimeout() time.Duration
@@ -66,6 +72,7 @@ type Config interface {
 	GetEntityTermin() string
 
 	GetEntityVorlage() string
+	GetBsvvPattern() string
 	GetDateFormat() string
 
 	GetAnlagenFolder() string
@@ -79,22 +86,67 @@ type Config interface {
 	GetGremienListeType() string
 	GetUrlSitzungTmpl() string
 	GetGremienOptionsType() string
+	GetEntityGremium() string
+
+	GetUrlPersonenliste() string
+	GetUrlPersonTmpl() string
+	GetUrlFraktionTmpl() string
+	GetPersonenListeType() string
+	GetPersonType() string
+	GetFraktionType() string
+	GetPersonenFolder() string
+	GetFraktionenFolder() string
+	GetEntityPerson() string
+	GetEntityFraktion() string
+	GetEntityMitgliedschaft() string
+
+	GetAnwesenheitFolder() string
+	GetAnwesenheitType() string
+	GetEntityAnwesenheit() string
 	GetUrlVorlagenliste() string
 	GetVorlagenListeType() string
 	GetUrlVorlageTmpl() string
 
 	GetBucketOcr() string
 	GetBucketOcrHtml() string
+	GetOcrTopic() string
+	GetOcrProvider() string
+	GetOcrHocr() bool
 
 	GetMailDomain() string
 	GetMailApiString() string
+	GetMailTransport() string
+	GetMailFrom() string
+	GetMailSmtpHost() string
+	GetMailSmtpPort() int
+	GetMailSmtpUser() string
+	GetMailSmtpPassword() string
+	GetMailDropFolder() string
 
 	GetSomethingNewEntity() string
 	GetSearchIndexJobEntity() string
 	GetSearchAppId() string
 	GetSearchApiKey() string
 	GetSearchIndex() string
+	GetSearchMaxRecordBytes() int
+	GetSearchChunkOverlap() int
+	GetSearchReindexBatchSize() int
+	GetSearchReindexConcurrency() int
+	GetSearchSwapMinRatio() float64
+	GetSearchCompoundDictionary() string
+	GetExtractGazetteer() string
+	GetGeoAdressen() string
+	GetBucketGeoJson() string
+	GetTaxonomy() string
+	GetDuplikatSchwelle() float64
 	GetRestartUrl() string
 	GetPublicSearchIndexDoneTopic() string
 	GetPublishDoneSecret() string
+
+	GetEntityWebhookEndpoint() string
+	GetEntityWebhookDelivery() string
+	GetEntityWebhookAttempt() string
+	GetWebhookMaxAttempts() int
+	GetWebhookBackoff() time.Duration
+	GetWebhookTimeout() time.Duration
 }
@@ -0,0 +1,485 @@
+package db
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/kennygrant/sanitize"
+	"github.com/rismaster/allris-common/common/domtools"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AbstimmungUnbekannt marks a count that is not stated, 0 means nobody voted this way
+const AbstimmungUnbekannt = -1
+
+const MehrheitEinstimmig = "einstimmig"
+const MehrheitMehrheitlich = "mehrheitlich"
+
+const ErgebnisAngenommen = "angenommen"
+const ErgebnisAbgelehnt = "abgelehnt"
+const ErgebnisUnbekannt = "unbekannt"
+
+const QuelleErgebnisblock = "allrisAE"
+const QuelleProtokoll = "Protokoll"
+
+type FraktionsVotum struct {
+	Fraktion   string
+	Zustimmung int
+	Ablehnung  int
+	Enthaltung int
+}
+
+// Abstimmung is the vote of a Top, Ergebnis is the outcome and Mehrheit the margin (empty if unknown)
+type Abstimmung struct {
+	Ergebnis   string
+	Mehrheit   string
+	Angenommen bool
+	Zustimmung int
+	Ablehnung  int
+	Enthaltung int
+	Fraktionen []FraktionsVotum
+	Befangen   []string `datastore:",noindex"`
+	Quelle     string
+
+	Teilabstimmungen []Teilabstimmung
+}
+
+// Teilabstimmung is one of several votes of a Top in the Protokoll (e.g. on an Änderungsantrag before the main vote)
+type Teilabstimmung struct {
+	Text             string `datastore:",noindex"`
+	Aenderungsantrag bool
+	Ergebnis         string
+	Mehrheit         string
+	Zustimmung       int
+	Ablehnung        int
+	Enthaltung       int
+}
+
+var zahlWorte = map[string]int{
+	"keine": 0, "keiner": 0, "ein": 1, "eine": 1, "einer": 1, "einem": 1, "zwei": 2, "drei": 3, "vier": 4, "fünf": 5,
+	"sechs": 6, "sieben": 7, "acht": 8, "neun": 9, "zehn": 10, "elf": 11, "zwölf": 12,
+}
+
+const zahlPattern = `\b([0-9]+|keine[r]?|eine[rm]?|ein|zwei|drei|vier|fünf|sechs|sieben|acht|neun|zehn|elf|zwölf)\b`
+
+var regexEnthaltungen = regexp.MustCompile(`(?i)` + zahlPattern + `\s+(stimm)?enthaltung(en)?`)
+var regexGegenstimmen = regexp.MustCompile(`(?i)` + zahlPattern + `\s+(gegenstimme[n]?|nein-stimme[n]?)`)
+var regexJaStimmen = regexp.MustCompile(`(?i)` + zahlPattern + `\s+(ja-stimme[n]?|dafür|für-stimme[n]?)`)
+var regexLabelZahl = regexp.MustCompile(`(?i)(ja|nein|enthaltung(en)?|zustimmung|ablehnung|dafür|dagegen)\s*:\s*([0-9]+)`)
+var regexBefangen = regexp.MustCompile(`(?i)[^.;]*befangen[^.;]*`)
+
+// regexErgebnisWort find the margin and the outcome of a vote with an optional negation ("nicht einstimmig")
+var regexErgebnisWort = regexp.MustCompile(`(?i)(\bnicht\s+)?\b(einstimmig|mehrheitlich|angenommen|abgelehnt|beschlossen|zugestimmt)`)
+
+// regexAbstimmungKopf find the beginning of the Abstimmungsergebnis in a line of the Protokoll
+var regexAbstimmungKopf = regexp.MustCompile(`(?i)^(abstimmungsergebnis(se)?|ergebnis der abstimmung|abstimmung)\s*:?\s*`)
+
+// regexAenderungsantrag find votes on amendments, they are voted before the Beschlussvorlage
+var regexAenderungsantrag = regexp.MustCompile(`(?i)(änderungs|ergänzungs|zusatz|gegen)antr(ag|äge)`)
+
+// regexVotumVerb find sentences stating a vote, e.g. "Der Antrag wird abgelehnt", without the head Abstimmungsergebnis
+var regexVotumVerb = regexp.MustCompile(`(?i)^((einstimmig|mehrheitlich)\b|.*\b(wird|wurde|werden|wurden|ist|sind)\b)`)
+
+// regexSatzEnde split a vote into sentences, a point in a date or number is followed by a digit
+var regexSatzEnde = regexp.MustCompile(`[.;!?](\s+|$)`)
+
+// regexBlockEnde mark the end of a line of the html
+var regexBlockEnde = regexp.MustCompile(`(?i)(<br\s*/?>|</(p|div|tr|li|h[1-6]|table)>)`)
+
+// maxAbstimmungZeilen is the number of lines read after the head Abstimmungsergebnis
+const maxAbstimmungZeilen = 6
+
+func NewAbstimmung() Abstimmung {
+	return Abstimmung{
+		Ergebnis:   ErgebnisUnbekannt,
+		Zustimmung: AbstimmungUnbekannt,
+		Ablehnung:  AbstimmungUnbekannt,
+		Enthaltung: AbstimmungUnbekannt,
+	}
+}
+
+// parseAnzahl returns the count of a cell or text, false if it contains no count
+func parseAnzahl(s string) (int, bool) {
+	s = strings.ToLower(strings.TrimSuffix(domtools.CleanText(s), "."))
+	if s == "" {
+		return AbstimmungUnbekannt, false
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+	if n, exist := zahlWorte[s]; exist {
+		return n, true
+	}
+	if s == "-" || s == "–" {
+		return 0, true
+	}
+	return AbstimmungUnbekannt, false
+}
+
+// ParseErgebnisblock read the allrisAE block, either label/value pairs or a table per Fraktion
+func (a *Abstimmung) ParseErgebnisblock(sel *goquery.Selection) {
+
+	bez, cont := domtools.ParseTable(sel.Find("table tr td:first-child"))
+	if bez == nil && cont == nil {
+		sel.Find("p").Each(func(i int, selection *goquery.Selection) {
+			a.setLabel(selection.Find("span").First().Text(), selection.Find("span").Last().Text())
+		})
+	} else {
+		for i, b := range bez {
+			if i < len(cont) {
+				a.setLabel(b, cont[i])
+			}
+		}
+	}
+
+	sel.Find("table").Each(func(i int, table *goquery.Selection) {
+		a.parseFraktionsTabelle(table)
+	})
+
+	if a.Zustimmung != AbstimmungUnbekannt || a.Ablehnung != AbstimmungUnbekannt || a.Enthaltung != AbstimmungUnbekannt || len(a.Fraktionen) > 0 {
+		a.Quelle = QuelleErgebnisblock
+	}
+
+	a.parseErgebnisText(domtools.CleanText(sel.Text()))
+	a.complete()
+}
+
+func (a *Abstimmung) setLabel(label string, value string) {
+	n, ok := parseAnzahl(value)
+	if !ok {
+		a.parseErgebnisText(value)
+		return
+	}
+	switch strings.TrimSuffix(domtools.CleanText(label), ":") {
+	case "Zustimmung", "Ja", "Dafür":
+		a.Zustimmung = n
+	case "Ablehnung", "Nein", "Dagegen":
+		a.Ablehnung = n
+	case "Enthaltung", "Enthaltungen":
+		a.Enthaltung = n
+	}
+}
+
+// parseFraktionsTabelle read tables with a header row like "Fraktion | Ja | Nein | Enthaltung"
+func (a *Abstimmung) parseFraktionsTabelle(table *goquery.Selection) {
+
+	rows := table.Find("tr")
+	if rows.Size() < 2 {
+		return
+	}
+
+	spalten := map[string]int{}
+	rows.First().Find("td, th").Each(func(i int, cell *goquery.Selection) {
+		h := strings.ToLower(domtools.CleanText(cell.Text()))
+		switch {
+		case strings.HasPrefix(h, "ja"), strings.HasPrefix(h, "zustimmung"), strings.HasPrefix(h, "dafür"):
+			spalten["ja"] = i
+		case strings.HasPrefix(h, "nein"), strings.HasPrefix(h, "ablehnung"), strings.HasPrefix(h, "dagegen"):
+			spalten["nein"] = i
+		case strings.HasPrefix(h, "enthaltung"):
+			spalten["enthaltung"] = i
+		}
+	})
+	if len(spalten) < 2 {
+		return
+	}
+
+	rows.Slice(1, rows.Size()).Each(func(i int, row *goquery.Selection) {
+		var cells []string
+		row.Find("td, th").Each(func(j int, cell *goquery.Selection) {
+			cells = append(cells, domtools.CleanText(cell.Text()))
+		})
+		if len(cells) < 2 || cells[0] == "" {
+			return
+		}
+		votum := FraktionsVotum{
+			Fraktion:   cells[0],
+			Zustimmung: cellAnzahl(cells, spalten, "ja"),
+			Ablehnung:  cellAnzahl(cells, spalten, "nein"),
+			Enthaltung: cellAnzahl(cells, spalten, "enthaltung"),
+		}
+		lower := strings.ToLower(votum.Fraktion)
+		if lower == "gesamt" || lower == "summe" {
+			a.Zustimmung, a.Ablehnung, a.Enthaltung = votum.Zustimmung, votum.Ablehnung, votum.Enthaltung
+			return
+		}
+		a.Fraktionen = append(a.Fraktionen, votum)
+	})
+}
+
+func cellAnzahl(cells []string, spalten map[string]int, name string) int {
+	i, exist := spalten[name]
+	if !exist || i >= len(cells) {
+		return AbstimmungUnbekannt
+	}
+	n, _ := parseAnzahl(cells[i])
+	return n
+}
+
+// ParseProtokoll fill result and counts not known from the allrisAE block from the html of the Protokoll,
+// only the Abstimmungsergebnis (or, without it, the sentences stating a vote) is read, not the discussion.
+// With several votes the last vote not on an Änderungsantrag is the result of the Top
+func (a *Abstimmung) ParseProtokoll(html ...string) {
+
+	var zeilen []string
+	for _, h := range html {
+		zeilen = append(zeilen, htmlZeilen(h)...)
+	}
+	if len(zeilen) == 0 {
+		return
+	}
+
+	before := *a
+	a.Teilabstimmungen = parseTeilabstimmungen(zeilen)
+
+	var haupt *Teilabstimmung
+	for i := range a.Teilabstimmungen {
+		if !a.Teilabstimmungen[i].Aenderungsantrag {
+			haupt = &a.Teilabstimmungen[i]
+		}
+	}
+	if haupt != nil {
+		if a.Mehrheit == "" {
+			a.Mehrheit = haupt.Mehrheit
+		}
+		if a.Ergebnis == ErgebnisUnbekannt {
+			a.Ergebnis = haupt.Ergebnis
+		}
+		setIfUnbekannt(&a.Zustimmung, haupt.Zustimmung)
+		setIfUnbekannt(&a.Ablehnung, haupt.Ablehnung)
+		setIfUnbekannt(&a.Enthaltung, haupt.Enthaltung)
+	}
+	if len(a.Teilabstimmungen) < 2 {
+		a.Teilabstimmungen = nil
+	}
+
+	for _, b := range regexBefangen.FindAllString(strings.Join(zeilen, " "), -1) {
+		a.Befangen = append(a.Befangen, domtools.CleanText(b))
+	}
+
+	a.complete()
+	if a.Quelle == "" && (a.Ergebnis != before.Ergebnis || a.Mehrheit != before.Mehrheit || a.Zustimmung != before.Zustimmung ||
+		a.Ablehnung != before.Ablehnung || a.Enthaltung != before.Enthaltung) {
+		a.Quelle = QuelleProtokoll
+	}
+}
+
+// htmlZeilen returns the non empty lines of the text of the html
+func htmlZeilen(html string) []string {
+
+	var zeilen []string
+	for _, z := range strings.Split(sanitize.HTML(regexBlockEnde.ReplaceAllString(html, "$1\n")), "\n") {
+		if z = domtools.CleanText(z); z != "" {
+			zeilen = append(zeilen, z)
+		}
+	}
+	return zeilen
+}
+
+// parseTeilabstimmungen returns the votes in the Abstimmungsergebnis blocks of the lines, without blocks the votes
+// stated in sentences like "Der Änderungsantrag wird mehrheitlich abgelehnt"
+func parseTeilabstimmungen(zeilen []string) []Teilabstimmung {
+
+	var abstimmungen []Teilabstimmung
+	for _, block := range abstimmungsBloecke(zeilen) {
+		for _, satz := range saetze(block) {
+			if t, ok := parseVotum(satz); ok {
+				abstimmungen = append(abstimmungen, t)
+			}
+		}
+	}
+	if len(abstimmungen) > 0 {
+		return abstimmungen
+	}
+
+	for _, z := range zeilen {
+		for _, satz := range saetze(z) {
+			if !regexVotumVerb.MatchString(satz) {
+				continue
+			}
+			if t, ok := parseVotum(satz); ok && t.Ergebnis != ErgebnisUnbekannt {
+				abstimmungen = append(abstimmungen, t)
+			}
+		}
+	}
+	return abstimmungen
+}
+
+// abstimmungsBloecke returns the text following each head Abstimmungsergebnis up to the next head or maxAbstimmungZeilen lines
+func abstimmungsBloecke(zeilen []string) []string {
+
+	var bloecke []string
+	for i := 0; i < len(zeilen); i++ {
+		kopf := regexAbstimmungKopf.FindString(zeilen[i])
+		if kopf == "" {
+			continue
+		}
+		block := []string{strings.TrimPrefix(zeilen[i], kopf)}
+		for j := i + 1; j < len(zeilen) && j <= i+maxAbstimmungZeilen && !regexAbstimmungKopf.MatchString(zeilen[j]); j++ {
+			block = append(block, zeilen[j])
+		}
+		bloecke = append(bloecke, domtools.CleanText(strings.Join(block, " ")))
+	}
+	return bloecke
+}
+
+func saetze(text string) []string {
+
+	var result []string
+	for _, s := range regexSatzEnde.Split(text, -1) {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// parseVotum read the outcome, margin and counts of one sentence, false if it does not state a vote
+func parseVotum(satz string) (Teilabstimmung, bool) {
+
+	v := Abstimmung{Ergebnis: ErgebnisUnbekannt, Zustimmung: AbstimmungUnbekannt, Ablehnung: AbstimmungUnbekannt, Enthaltung: AbstimmungUnbekannt}
+	v.parseErgebnisText(satz)
+	v.parseAnzahlen(satz)
+	if v.Ergebnis == ErgebnisUnbekannt && v.Mehrheit == "" && v.Zustimmung == AbstimmungUnbekannt &&
+		v.Ablehnung == AbstimmungUnbekannt && v.Enthaltung == AbstimmungUnbekannt {
+		return Teilabstimmung{}, false
+	}
+	v.complete()
+
+	return Teilabstimmung{
+		Text:             satz,
+		Aenderungsantrag: regexAenderungsantrag.MatchString(satz),
+		Ergebnis:         v.Ergebnis,
+		Mehrheit:         v.Mehrheit,
+		Zustimmung:       v.Zustimmung,
+		Ablehnung:        v.Ablehnung,
+		Enthaltung:       v.Enthaltung,
+	}, true
+}
+
+// parseAnzahlen read counts like "Ja: 12" or "3 Gegenstimmen" not known yet
+func (a *Abstimmung) parseAnzahlen(text string) {
+
+	for _, m := range regexLabelZahl.FindAllStringSubmatch(text, -1) {
+		n, _ := strconv.Atoi(m[3])
+		switch strings.ToLower(m[1]) {
+		case "ja", "zustimmung", "dafür":
+			setIfUnbekannt(&a.Zustimmung, n)
+		case "nein", "ablehnung", "dagegen":
+			setIfUnbekannt(&a.Ablehnung, n)
+		default:
+			setIfUnbekannt(&a.Enthaltung, n)
+		}
+	}
+	if m := regexJaStimmen.FindStringSubmatch(text); m != nil {
+		n, _ := parseAnzahl(m[1])
+		setIfUnbekannt(&a.Zustimmung, n)
+	}
+	if m := regexGegenstimmen.FindStringSubmatch(text); m != nil {
+		n, _ := parseAnzahl(m[1])
+		setIfUnbekannt(&a.Ablehnung, n)
+	}
+	if m := regexEnthaltungen.FindStringSubmatch(text); m != nil {
+		n, _ := parseAnzahl(m[1])
+		setIfUnbekannt(&a.Enthaltung, n)
+	}
+}
+
+func setIfUnbekannt(field *int, n int) {
+	if *field == AbstimmungUnbekannt && n != AbstimmungUnbekannt {
+		*field = n
+	}
+}
+
+// parseErgebnisText set margin and outcome from the first words stating them in a text of one vote,
+// a negation turns "nicht einstimmig" into mehrheitlich and "nicht angenommen" into abgelehnt
+func (a *Abstimmung) parseErgebnisText(text string) {
+
+	for _, m := range regexErgebnisWort.FindAllStringSubmatch(text, -1) {
+		nicht := m[1] != ""
+		switch wort := strings.ToLower(m[2]); wort {
+		case MehrheitEinstimmig, MehrheitMehrheitlich:
+			if a.Mehrheit != "" || (nicht && wort == MehrheitMehrheitlich) {
+				continue
+			}
+			a.Mehrheit = wort
+			if nicht {
+				a.Mehrheit = MehrheitMehrheitlich
+			}
+		default:
+			if a.Ergebnis != ErgebnisUnbekannt {
+				continue
+			}
+			angenommen := wort != ErgebnisAbgelehnt
+			if nicht {
+				angenommen = !angenommen
+			}
+			a.Ergebnis = ErgebnisAbgelehnt
+			if angenommen {
+				a.Ergebnis = ErgebnisAngenommen
+			}
+		}
+	}
+}
+
+// complete derive counts, margin and result that follow from each other
+func (a *Abstimmung) complete() {
+
+	if len(a.Fraktionen) > 0 {
+		setIfUnbekannt(&a.Zustimmung, sumFraktionen(a.Fraktionen, func(v FraktionsVotum) int { return v.Zustimmung }))
+		setIfUnbekannt(&a.Ablehnung, sumFraktionen(a.Fraktionen, func(v FraktionsVotum) int { return v.Ablehnung }))
+		setIfUnbekannt(&a.Enthaltung, sumFraktionen(a.Fraktionen, func(v FraktionsVotum) int { return v.Enthaltung }))
+	}
+
+	if a.Zustimmung != AbstimmungUnbekannt && a.Ablehnung != AbstimmungUnbekannt && a.Zustimmung+a.Ablehnung > 0 {
+		if a.Ergebnis == ErgebnisUnbekannt {
+			a.Ergebnis = ErgebnisAbgelehnt
+			if a.Zustimmung > a.Ablehnung {
+				a.Ergebnis = ErgebnisAngenommen
+			}
+		}
+		if a.Mehrheit == "" {
+			a.Mehrheit = MehrheitMehrheitlich
+			if a.Zustimmung == 0 || a.Ablehnung == 0 {
+				a.Mehrheit = MehrheitEinstimmig
+			}
+		}
+	}
+
+	//"einstimmig" without outcome is the usual note of an accepted vote
+	if a.Ergebnis == ErgebnisUnbekannt && a.Mehrheit != "" {
+		a.Ergebnis = ErgebnisAngenommen
+	}
+
+	if a.Mehrheit == MehrheitEinstimmig {
+		switch a.Ergebnis {
+		case ErgebnisAngenommen:
+			setIfUnbekannt(&a.Ablehnung, 0)
+		case ErgebnisAbgelehnt:
+			setIfUnbekannt(&a.Zustimmung, 0)
+		}
+	}
+
+	a.Angenommen = a.Ergebnis == ErgebnisAngenommen
+}
+
+// sumFraktionen returns the sum of all Fraktionen or AbstimmungUnbekannt if one is unknown
+func sumFraktionen(fraktionen []FraktionsVotum, value func(FraktionsVotum) int) int {
+	sum := 0
+	for _, v := range fraktionen {
+		n := value(v)
+		if n == AbstimmungUnbekannt {
+			return AbstimmungUnbekannt
+		}
+		sum = sum + n
+	}
+	return sum
+}
+
+// orZero returns 0 for unknown counts (for the old AbstimmungZustimmung, ... fields)
+func orZero(n int) int {
+	if n == AbstimmungUnbekannt {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+	"testing"
+)
+
+func TestParseProtokoll(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		html       string
+		ergebnis   string
+		mehrheit   string
+		zustimmung int
+		ablehnung  int
+		enthaltung int
+		teile      int
+		quelle     string
+	}{
+		{
+			name:       "einstimmig beschlossen",
+			html:       `<p><b>Abstimmungsergebnis:</b></p><p>einstimmig beschlossen</p>`,
+			ergebnis:   ErgebnisAngenommen,
+			mehrheit:   MehrheitEinstimmig,
+			zustimmung: AbstimmungUnbekannt,
+			ablehnung:  0,
+			enthaltung: AbstimmungUnbekannt,
+			quelle:     QuelleProtokoll,
+		},
+		{
+			name:       "label counts",
+			html:       `<p>Abstimmungsergebnis: Ja: 12 Nein: 3 Enthaltung: 1</p>`,
+			ergebnis:   ErgebnisAngenommen,
+			mehrheit:   MehrheitMehrheitlich,
+			zustimmung: 12,
+			ablehnung:  3,
+			enthaltung: 1,
+			quelle:     QuelleProtokoll,
+		},
+		{
+			name:       "nicht einstimmig",
+			html:       `<p>Abstimmungsergebnis: nicht einstimmig angenommen (2 Gegenstimmen)</p>`,
+			ergebnis:   ErgebnisAngenommen,
+			mehrheit:   MehrheitMehrheitlich,
+			zustimmung: AbstimmungUnbekannt,
+			ablehnung:  2,
+			enthaltung: AbstimmungUnbekannt,
+			quelle:     QuelleProtokoll,
+		},
+		{
+			name:       "nicht angenommen",
+			html:       `<p>Abstimmungsergebnis:<br>Der Antrag wird bei 5 Ja-Stimmen und 5 Gegenstimmen nicht angenommen.</p>`,
+			ergebnis:   ErgebnisAbgelehnt,
+			mehrheit:   MehrheitMehrheitlich,
+			zustimmung: 5,
+			ablehnung:  5,
+			enthaltung: AbstimmungUnbekannt,
+			quelle:     QuelleProtokoll,
+		},
+		{
+			name: "aenderungsantrag abgelehnt before the vorlage is accepted",
+			html: `<p>Der Änderungsantrag der CDU-Fraktion wird mit 4 Ja-Stimmen und 7 Gegenstimmen abgelehnt.</p>` +
+				`<p>Die Beschlussvorlage wird in der Fassung der Verwaltung einstimmig angenommen.</p>`,
+			ergebnis:   ErgebnisAngenommen,
+			mehrheit:   MehrheitEinstimmig,
+			zustimmung: AbstimmungUnbekannt,
+			ablehnung:  0,
+			enthaltung: AbstimmungUnbekannt,
+			teile:      2,
+			quelle:     QuelleProtokoll,
+		},
+		{
+			name: "several blocks",
+			html: `<p>Abstimmungsergebnis Änderungsantrag SPD: mehrheitlich abgelehnt</p>` +
+				`<p>Abstimmungsergebnis (geänderte Beschlussvorlage): einstimmig beschlossen</p>`,
+			ergebnis:   ErgebnisAngenommen,
+			mehrheit:   MehrheitEinstimmig,
+			zustimmung: AbstimmungUnbekannt,
+			ablehnung:  0,
+			enthaltung: AbstimmungUnbekannt,
+			teile:      2,
+			quelle:     QuelleProtokoll,
+		},
+		{
+			name: "discussion ignored",
+			html: `<p>Herr Müller erinnert daran, dass der Antrag im Vorjahr abgelehnt wurde.</p>` +
+				`<p>Abstimmungsergebnis:</p><p>mehrheitlich beschlossen</p><p>Ja: 9</p><p>Nein: 2</p>`,
+			ergebnis:   ErgebnisAngenommen,
+			mehrheit:   MehrheitMehrheitlich,
+			zustimmung: 9,
+			ablehnung:  2,
+			enthaltung: AbstimmungUnbekannt,
+			quelle:     QuelleProtokoll,
+		},
+		{
+			name:       "report without vote",
+			html:       `<div>Die Verwaltung berichtet, der Antrag sei im Kreistag abgelehnt worden.</div>`,
+			ergebnis:   ErgebnisUnbekannt,
+			zustimmung: AbstimmungUnbekannt,
+			ablehnung:  AbstimmungUnbekannt,
+			enthaltung: AbstimmungUnbekannt,
+		},
+		{
+			name:       "date not a sentence end",
+			html:       `<p>Abstimmungsergebnis: Vertagt auf den 12.03.2020, 3 Enthaltungen</p>`,
+			ergebnis:   ErgebnisUnbekannt,
+			zustimmung: AbstimmungUnbekannt,
+			ablehnung:  AbstimmungUnbekannt,
+			enthaltung: 3,
+			quelle:     QuelleProtokoll,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAbstimmung()
+			a.ParseProtokoll(tt.html)
+
+			if a.Ergebnis != tt.ergebnis || a.Mehrheit != tt.mehrheit {
+				t.Errorf("Ergebnis/Mehrheit = %s/%s, want %s/%s", a.Ergebnis, a.Mehrheit, tt.ergebnis, tt.mehrheit)
+			}
+			if a.Zustimmung != tt.zustimmung || a.Ablehnung != tt.ablehnung || a.Enthaltung != tt.enthaltung {
+				t.Errorf("Zustimmung/Ablehnung/Enthaltung = %d/%d/%d, want %d/%d/%d",
+					a.Zustimmung, a.Ablehnung, a.Enthaltung, tt.zustimmung, tt.ablehnung, tt.enthaltung)
+			}
+			if len(a.Teilabstimmungen) != tt.teile {
+				t.Errorf("%d Teilabstimmungen, want %d", len(a.Teilabstimmungen), tt.teile)
+			}
+			if a.Quelle != tt.quelle {
+				t.Errorf("Quelle = %q, want %q", a.Quelle, tt.quelle)
+			}
+		})
+	}
+}
+
+func TestParseProtokollAenderungsantrag(t *testing.T) {
+
+	a := NewAbstimmung()
+	a.ParseProtokoll(`<p>Der Änderungsantrag der CDU-Fraktion wird mit 4 Ja-Stimmen und 7 Gegenstimmen abgelehnt.</p>` +
+		`<p>Die Beschlussvorlage wird in der Fassung der Verwaltung einstimmig angenommen.</p>`)
+
+	if len(a.Teilabstimmungen) != 2 {
+		t.Fatalf("%d Teilabstimmungen, want 2", len(a.Teilabstimmungen))
+	}
+	antrag := a.Teilabstimmungen[0]
+	if !antrag.Aenderungsantrag || antrag.Ergebnis != ErgebnisAbgelehnt || antrag.Zustimmung != 4 || antrag.Ablehnung != 7 {
+		t.Errorf("Änderungsantrag = %+v", antrag)
+	}
+	if a.Teilabstimmungen[1].Aenderungsantrag {
+		t.Errorf("Beschlussvorlage marked as Änderungsantrag")
+	}
+}
+
+func TestParseProtokollBefangen(t *testing.T) {
+
+	a := NewAbstimmung()
+	a.ParseProtokoll(`<p>Ratsherr Meier erklärt sich für befangen und nimmt nicht an der Abstimmung teil.</p>` +
+		`<p>Abstimmungsergebnis: einstimmig</p>`)
+
+	if len(a.Befangen) != 1 || !strings.HasPrefix(a.Befangen[0], "Ratsherr Meier") {
+		t.Errorf("Befangen = %v", a.Befangen)
+	}
+	if a.Ergebnis != ErgebnisAngenommen || a.Mehrheit != MehrheitEinstimmig {
+		t.Errorf("Ergebnis/Mehrheit = %s/%s", a.Ergebnis, a.Mehrheit)
+	}
+}
+
+func TestParseErgebnisblock(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		html       string
+		ergebnis   string
+		mehrheit   string
+		zustimmung int
+		ablehnung  int
+		enthaltung int
+		fraktionen int
+	}{
+		{
+			name: "label table",
+			html: `<div id="allrisAE"><table><tr><td>Zustimmung:</td><td>10</td></tr>` +
+				`<tr><td>Ablehnung:</td><td>2</td></tr><tr><td>Enthaltung:</td><td>keine</td></tr></table></div>`,
+			ergebnis:   ErgebnisAngenommen,
+			mehrheit:   MehrheitMehrheitlich,
+			zustimmung: 10,
+			ablehnung:  2,
+			enthaltung: 0,
+		},
+		{
+			name: "fraktionen",
+			html: `<div id="allrisAE"><table><tr><th>Fraktion</th><th>Ja</th><th>Nein</th><th>Enthaltung</th></tr>` +
+				`<tr><td>SPD</td><td>5</td><td>0</td><td>0</td></tr>` +
+				`<tr><td>CDU</td><td>0</td><td>6</td><td>1</td></tr></table></div>`,
+			ergebnis:   ErgebnisAbgelehnt,
+			mehrheit:   MehrheitMehrheitlich,
+			zustimmung: 5,
+			ablehnung:  6,
+			enthaltung: 1,
+			fraktionen: 2,
+		},
+		{
+			name:       "nicht einstimmig",
+			html:       `<div id="allrisAE"><p><span>Ergebnis:</span><span>nicht einstimmig beschlossen</span></p></div>`,
+			ergebnis:   ErgebnisAngenommen,
+			mehrheit:   MehrheitMehrheitlich,
+			zustimmung: AbstimmungUnbekannt,
+			ablehnung:  AbstimmungUnbekannt,
+			enthaltung: AbstimmungUnbekannt,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(tt.html))
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := NewAbstimmung()
+			a.ParseErgebnisblock(doc.Find("#allrisAE"))
+
+			if a.Ergebnis != tt.ergebnis || a.Mehrheit != tt.mehrheit {
+				t.Errorf("Ergebnis/Mehrheit = %s/%s, want %s/%s", a.Ergebnis, a.Mehrheit, tt.ergebnis, tt.mehrheit)
+			}
+			if a.Zustimmung != tt.zustimmung || a.Ablehnung != tt.ablehnung || a.Enthaltung != tt.enthaltung {
+				t.Errorf("Zustimmung/Ablehnung/Enthaltung = %d/%d/%d, want %d/%d/%d",
+					a.Zustimmung, a.Ablehnung, a.Enthaltung, tt.zustimmung, tt.ablehnung, tt.enthaltung)
+			}
+			if len(a.Fraktionen) != tt.fraktionen {
+				t.Errorf("%d Fraktionen, want %d", len(a.Fraktionen), tt.fraktionen)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common/domtools"
 	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/geo"
 	"regexp"
 	"strconv"
 	"time"
@@ -26,8 +27,21 @@ type Anlage struct {
 
 	SavedAt time.Time
 
+	//near-duplicate detection of the ocr text (minhash)
+	Signatur  []int64  `datastore:",noindex" json:"-"`
+	Baender   []string `json:"-"`             //bands of the signature for the lookup of near-duplicates (minhash.Signatur.Baender)
+	Zeichen   int      `datastore:",noindex"` //length of the ocr text
+	Familie   string   //id of the family of near-duplicates, empty if there are none
+	Kanonisch bool     //canonical Anlage of the family
+
+	//geocoded Adressen and Strassen of the ocr text, merged into the AnlagenOrte of the parent Vorlage (see SetAnlageOrte)
+	Orte []geo.Ort `datastore:",noindex"`
+
+	//topics of the ocr text, merged into the Themen of the parent Vorlage or Top (see SetAnlageThemen)
+	Themen []string
+
 	parent TopHolder
-	Config allris_common.Config `datastore:"-"`
+	Config allris_common.Config `datastore:"-" json:"-"`
 
 	Key *datastore.Key `datastore:"-"`
 }
@@ -0,0 +1,406 @@
+package db
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/kennygrant/sanitize"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const AnwesenheitAnwesend = "anwesend"
+const AnwesenheitEntschuldigt = "entschuldigt"
+const AnwesenheitAbwesend = "abwesend"
+
+// Anwesenheit of one Person in a Sitzung (child of the Sitzung)
+type Anwesenheit struct {
+	SILFDNR   int
+	GremiumID int
+	Datum     time.Time
+	KPLFDNR   int
+	Name      string
+	Status    string
+	Rolle     string
+	Fraktion  string
+
+	SavedAt time.Time
+}
+
+// Anwesenheitsliste is the parsed attendance page of a Sitzung
+type Anwesenheitsliste struct {
+	SILFDNR   int
+	Eintraege []*Anwesenheit
+
+	file *files.File
+	app  *application.AppContext
+}
+
+type AnwesenheitStatistik struct {
+	Key          int
+	Name         string
+	Sitzungen    int
+	Anwesend     int
+	Entschuldigt int
+	Abwesend     int
+	Quote        float64
+}
+
+func NewAnwesenheitsliste(app *application.AppContext, file *files.File) (*Anwesenheitsliste, error) {
+
+	silfdnrStr := strings.TrimPrefix(file.GetNameWithoutExtension(), app.Config.GetSitzungType()+"-")
+	silfdnrStr = strings.TrimSuffix(silfdnrStr, "-"+app.Config.GetAnwesenheitType())
+	silfdnr, err := strconv.Atoi(silfdnrStr)
+	if err != nil {
+		return nil, err
+	}
+	return &Anwesenheitsliste{
+		SILFDNR: silfdnr,
+		file:    file,
+		app:     app,
+	}, nil
+}
+
+func (l *Anwesenheitsliste) GetSitzungKey() *datastore.Key {
+	return datastore.NameKey(l.app.Config.GetEntitySitzung(), fmt.Sprintf("%d", l.SILFDNR), nil)
+}
+
+func (l *Anwesenheitsliste) GetQuery() *datastore.Query {
+	return datastore.NewQuery(l.app.Config.GetEntityAnwesenheit()).Ancestor(l.GetSitzungKey())
+}
+
+func (a *Anwesenheit) GetKey(app *application.AppContext, sitzungKey *datastore.Key) *datastore.Key {
+	kn := fmt.Sprintf("%d", a.KPLFDNR)
+	if a.KPLFDNR <= 0 {
+		kn = sanitize.Name(a.Name)
+	}
+	return datastore.NameKey(app.Config.GetEntityAnwesenheit(), kn, sitzungKey)
+}
+
+func (l *Anwesenheitsliste) Parse(doc *goquery.Document) error {
+
+	status := AnwesenheitAnwesend
+	var spalten map[int]string
+	doc.Find("#allriscontainer tr").Each(func(i int, tr *goquery.Selection) {
+
+		if !tr.HasClass("zl11") && !tr.HasClass("zl12") {
+			if kopf := anwesenheitSpalten(tr); kopf != nil {
+				spalten = kopf
+			} else if s := anwesenheitStatus(tr.Text()); s != "" {
+				status = s
+			}
+			return
+		}
+
+		eintrag := &Anwesenheit{
+			SILFDNR: l.SILFDNR,
+			Status:  status,
+		}
+
+		lnk := tr.Find("a").First()
+		if lnk.Nodes != nil {
+			href, _ := lnk.Attr("href")
+			if matches := domtools.RegexPersonLink.FindStringSubmatch(href); len(matches) > 1 {
+				eintrag.KPLFDNR = domtools.StringToIntOrNeg(matches[1])
+				eintrag.Name = domtools.CleanText(lnk.Text())
+			}
+		}
+
+		tr.Find("td").Each(func(j int, td *goquery.Selection) {
+			text := domtools.CleanText(td.Text())
+			if text == "" || text == eintrag.Name {
+				return
+			}
+			if spalten != nil {
+				eintrag.setSpalte(spalten[j], text)
+				return
+			}
+			//without header the columns are guessed in the order Name, Fraktion, Rolle
+			if s := anwesenheitStatus(text); s != "" {
+				eintrag.Status = s
+			} else if eintrag.Name == "" {
+				eintrag.Name = text
+			} else if eintrag.Fraktion == "" {
+				eintrag.Fraktion = text
+			} else if eintrag.Rolle == "" {
+				eintrag.Rolle = text
+			}
+		})
+
+		if eintrag.Name != "" {
+			l.Eintraege = append(l.Eintraege, eintrag)
+		}
+	})
+
+	if len(l.Eintraege) == 0 {
+		return errors.New(fmt.Sprintf("leere Anwesenheitsliste in Sitzung %d", l.SILFDNR))
+	}
+	return nil
+}
+
+// anwesenheitKoepfe map the beginning of the header of a column to the field
+var anwesenheitKoepfe = []struct {
+	kopf string
+	feld string
+}{
+	{"name", "Name"},
+	{"mitglied", "Name"},
+	{"fraktion", "Fraktion"},
+	{"partei", "Fraktion"},
+	{"funktion", "Rolle"},
+	{"rolle", "Rolle"},
+	{"anwesenheit", "Status"},
+	{"status", "Status"},
+	{"teilnahme", "Status"},
+}
+
+// anwesenheitSpalten returns the fields of the columns if the row is the header of the attendance table
+func anwesenheitSpalten(tr *goquery.Selection) map[int]string {
+
+	spalten := make(map[int]string)
+	tr.Find("th, td").Each(func(j int, cell *goquery.Selection) {
+		text := strings.ToLower(domtools.CleanText(cell.Text()))
+		for _, k := range anwesenheitKoepfe {
+			if strings.HasPrefix(text, k.kopf) {
+				spalten[j] = k.feld
+				return
+			}
+		}
+	})
+
+	hasName := false
+	for _, feld := range spalten {
+		hasName = hasName || feld == "Name"
+	}
+	if !hasName || len(spalten) < 2 {
+		return nil
+	}
+	return spalten
+}
+
+func (a *Anwesenheit) setSpalte(feld string, text string) {
+	switch feld {
+	case "Name":
+		if a.Name == "" {
+			a.Name = text
+		}
+	case "Fraktion":
+		a.Fraktion = text
+	case "Rolle":
+		a.Rolle = text
+	case "Status":
+		if s := anwesenheitStatus(text); s != "" {
+			a.Status = s
+		}
+	}
+}
+
+func anwesenheitStatus(text string) string {
+	t := strings.ToLower(domtools.CleanText(text))
+	switch {
+	case t == "":
+		return ""
+	case strings.HasPrefix(t, "entschuldigt"):
+		return AnwesenheitEntschuldigt
+	case strings.HasPrefix(t, "abwesend"), strings.HasPrefix(t, "nicht anwesend"), strings.HasPrefix(t, "fehlend"), strings.HasPrefix(t, "unentschuldigt"):
+		return AnwesenheitAbwesend
+	case strings.HasPrefix(t, "anwesend"):
+		return AnwesenheitAnwesend
+	}
+	return ""
+}
+
+// SaveOrUpdate save the Eintraege with Gremium and Datum of the Sitzung if it is synced already (see updateAnwesenheit)
+func (l *Anwesenheitsliste) SaveOrUpdate() error {
+
+	var sitzung Sitzung
+	err := l.app.Db().Get(l.app.Ctx(), l.GetSitzungKey(), &sitzung)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return errors.Wrap(err, fmt.Sprintf("error getting sitzung %d", l.SILFDNR))
+	}
+
+	now := time.Now()
+	for _, e := range l.Eintraege {
+		e.GremiumID = sitzung.GremiumID
+		e.Datum = sitzung.Datum
+		e.SavedAt = now
+	}
+
+	ks, err := l.app.Db().GetAll(l.app.Ctx(), l.GetQuery().KeysOnly(), nil)
+	if err != nil {
+		return errors.Wrap(err, "error getting anwesenheit from db")
+	}
+
+	newKeys := make(map[string]bool)
+	var keys []*datastore.Key
+	var eintraege []*Anwesenheit
+	for _, e := range l.Eintraege {
+		k := e.GetKey(l.app, l.GetSitzungKey())
+		if !newKeys[k.Encode()] {
+			newKeys[k.Encode()] = true
+			keys = append(keys, k)
+			eintraege = append(eintraege, e)
+		}
+	}
+
+	var toDelete []*datastore.Key
+	for _, k := range ks {
+		if !newKeys[k.Encode()] {
+			toDelete = append(toDelete, k)
+		}
+	}
+
+	_, err = l.app.Db().RunInTransaction(l.app.Ctx(), func(tx *datastore.Transaction) error {
+		errTx := tx.DeleteMulti(toDelete)
+		if errTx != nil {
+			return errTx
+		}
+		_, errTx = tx.PutMulti(keys, eintraege)
+		return errTx
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error saving anwesenheit of sitzung %d", l.SILFDNR))
+	}
+	return nil
+}
+
+// updateAnwesenheit set Gremium and Datum of the attendance of a Sitzung, the attendance may be synced before its Sitzung
+func updateAnwesenheit(app *application.AppContext, s *Sitzung) error {
+
+	var anwesenheiten []*Anwesenheit
+	ks, err := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityAnwesenheit()).Ancestor(s.GetKey()), &anwesenheiten)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error getting anwesenheit of sitzung %d", s.SILFDNR))
+	}
+
+	var keys []*datastore.Key
+	var changed []*Anwesenheit
+	for i, a := range anwesenheiten {
+		if a.GremiumID != s.GremiumID || !a.Datum.Equal(s.Datum) {
+			a.GremiumID = s.GremiumID
+			a.Datum = s.Datum
+			keys = append(keys, ks[i])
+			changed = append(changed, a)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err = app.Db().PutMulti(app.Ctx(), keys, changed)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error updating anwesenheit of sitzung %d", s.SILFDNR))
+	}
+	return nil
+}
+
+func (l *Anwesenheitsliste) Delete() error {
+
+	ks, err := l.app.Db().GetAll(l.app.Ctx(), l.GetQuery().KeysOnly(), nil)
+	if err != nil {
+		return errors.Wrap(err, "error getting anwesenheit from db")
+	}
+	return l.app.Db().DeleteMulti(l.app.Ctx(), ks)
+}
+
+func getAnwesenheiten(app *application.AppContext, gremiumID int, von time.Time, bis time.Time) ([]*Anwesenheit, error) {
+
+	query := datastore.NewQuery(app.Config.GetEntityAnwesenheit())
+	if gremiumID > 0 {
+		query = query.Filter("GremiumID =", gremiumID)
+	}
+	if !von.IsZero() {
+		query = query.Filter("Datum >=", von)
+	}
+	if !bis.IsZero() {
+		query = query.Filter("Datum <", bis)
+	}
+
+	var anwesenheiten []*Anwesenheit
+	_, err := app.Db().GetAll(app.Ctx(), query, &anwesenheiten)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting anwesenheiten from db")
+	}
+	return anwesenheiten, nil
+}
+
+// GetAnwesenheitStatistikProPerson aggregate the attendance per Person, gremiumID 0 means all Gremien
+func GetAnwesenheitStatistikProPerson(app *application.AppContext, gremiumID int, von time.Time, bis time.Time) ([]*AnwesenheitStatistik, error) {
+
+	anwesenheiten, err := getAnwesenheiten(app, gremiumID, von, bis)
+	if err != nil {
+		return nil, err
+	}
+	return aggregateAnwesenheit(anwesenheiten, func(a *Anwesenheit) (int, string) {
+		return a.KPLFDNR, a.Name
+	}), nil
+}
+
+// GetAnwesenheitStatistikProGremium aggregate the attendance per Gremium (Key is the GRA)
+func GetAnwesenheitStatistikProGremium(app *application.AppContext, von time.Time, bis time.Time) ([]*AnwesenheitStatistik, error) {
+
+	anwesenheiten, err := getAnwesenheiten(app, 0, von, bis)
+	if err != nil {
+		return nil, err
+	}
+
+	gremien, err := GetGremien(app, false)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string)
+	for _, g := range gremien {
+		names[g.GRA] = g.Name
+	}
+
+	return aggregateAnwesenheit(anwesenheiten, func(a *Anwesenheit) (int, string) {
+		return a.GremiumID, names[a.GremiumID]
+	}), nil
+}
+
+func aggregateAnwesenheit(anwesenheiten []*Anwesenheit, groupBy func(*Anwesenheit) (int, string)) []*AnwesenheitStatistik {
+
+	stats := make(map[string]*AnwesenheitStatistik)
+	for _, a := range anwesenheiten {
+		key, name := groupBy(a)
+		id := fmt.Sprintf("%d_%s", key, name)
+		if key > 0 {
+			id = fmt.Sprintf("%d", key)
+		}
+		stat, exist := stats[id]
+		if !exist {
+			stat = &AnwesenheitStatistik{Key: key, Name: name}
+			stats[id] = stat
+		}
+		stat.Sitzungen++
+		switch a.Status {
+		case AnwesenheitAnwesend:
+			stat.Anwesend++
+		case AnwesenheitEntschuldigt:
+			stat.Entschuldigt++
+		default:
+			stat.Abwesend++
+		}
+	}
+
+	var result []*AnwesenheitStatistik
+	for _, stat := range stats {
+		if stat.Sitzungen > 0 {
+			stat.Quote = float64(stat.Anwesend) / float64(stat.Sitzungen)
+		}
+		result = append(result, stat)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	slog.Debug("aggregated %d anwesenheiten into %d statistiken", len(anwesenheiten), len(result))
+	return result
+}
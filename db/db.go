@@ -1,10 +1,16 @@
 package db
 
 import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
 	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common/files"
 	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/dpage"
 	"strings"
+	"time"
 )
 
 func DeleteTop(app *application.AppContext, filepath string) {
@@ -92,3 +98,189 @@ func UpdateSitzung(app *application.AppContext, filepath string) {
 	}
 
 }
+
+func UpdatePerson(app *application.AppContext, filepath string) {
+
+	file := files.NewFileFromStore(app, app.Config.GetPersonenFolder(), strings.TrimPrefix(filepath, app.Config.GetPersonenFolder()))
+	person, err := NewPerson(app, file)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+
+	doc, err := readDom(app, file)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+
+	err = person.Parse(doc)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+	person.SavedAt = time.Now()
+	person.Derive(person.SavedAt)
+
+	err = person.SaveOrUpdate()
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+}
+
+func DeletePerson(app *application.AppContext, filepath string) {
+
+	file := files.NewFileFromStore(app, app.Config.GetPersonenFolder(), strings.TrimPrefix(filepath, app.Config.GetPersonenFolder()))
+	person, err := NewPerson(app, file)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+
+	err = person.Delete()
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+}
+
+func UpdateFraktion(app *application.AppContext, filepath string) {
+
+	file := files.NewFileFromStore(app, app.Config.GetFraktionenFolder(), strings.TrimPrefix(filepath, app.Config.GetFraktionenFolder()))
+	fraktion, err := NewFraktion(app, file)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+
+	doc, err := readDom(app, file)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+
+	err = fraktion.Parse(doc)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+	fraktion.SavedAt = time.Now()
+
+	err = fraktion.SaveOrUpdate()
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+}
+
+func DeleteFraktion(app *application.AppContext, filepath string) {
+
+	file := files.NewFileFromStore(app, app.Config.GetFraktionenFolder(), strings.TrimPrefix(filepath, app.Config.GetFraktionenFolder()))
+	fraktion, err := NewFraktion(app, file)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+
+	err = fraktion.Delete()
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+}
+
+func UpdateAnwesenheit(app *application.AppContext, filepath string) {
+
+	file := files.NewFileFromStore(app, app.Config.GetAnwesenheitFolder(), strings.TrimPrefix(filepath, app.Config.GetAnwesenheitFolder()))
+	liste, err := NewAnwesenheitsliste(app, file)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+
+	doc, err := readDom(app, file)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+
+	err = liste.Parse(doc)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+
+	err = liste.SaveOrUpdate()
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+}
+
+func DeleteAnwesenheit(app *application.AppContext, filepath string) {
+
+	file := files.NewFileFromStore(app, app.Config.GetAnwesenheitFolder(), strings.TrimPrefix(filepath, app.Config.GetAnwesenheitFolder()))
+	liste, err := NewAnwesenheitsliste(app, file)
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+
+	err = liste.Delete()
+	if err != nil {
+		slog.Fatal("err: %+v", err)
+	}
+}
+
+// NewTopHolder create the Vorlage, Sitzung or Top for a path in the fetched bucket
+func NewTopHolder(app *application.AppContext, filepath string) (TopHolder, error) {
+
+	switch {
+	case strings.HasPrefix(filepath, app.Config.GetVorlagenFolder()):
+		return NewVorlage(app, files.NewFileFromStore(app, app.Config.GetVorlagenFolder(), strings.TrimPrefix(filepath, app.Config.GetVorlagenFolder())))
+	case strings.HasPrefix(filepath, app.Config.GetSitzungenFolder()):
+		return NewSitzung(app, files.NewFileFromStore(app, app.Config.GetSitzungenFolder(), strings.TrimPrefix(filepath, app.Config.GetSitzungenFolder())))
+	case strings.HasPrefix(filepath, app.Config.GetTopFolder()):
+		return NewTop(app, files.NewFileFromStore(app, app.Config.GetTopFolder(), strings.TrimPrefix(filepath, app.Config.GetTopFolder())))
+	}
+	return nil, errors.New(fmt.Sprintf("no entity for path %s", filepath))
+}
+
+// RegisterPageFingerprint let dpage hash the downloaded pages by the fields parsed into the db instead of their html
+func RegisterPageFingerprint() {
+	dpage.SetPageFingerprint(ParsePage)
+}
+
+// ParsePage parse a downloaded page of a Vorlage, Sitzung, Top, Person, Fraktion or Anwesenheitsliste without
+// saving or deriving anything, nil for other pages
+func ParsePage(app *application.AppContext, file *files.File, doc *goquery.Document) (interface{}, error) {
+
+	type parser interface {
+		Parse(doc *goquery.Document) error
+	}
+
+	var p parser
+	var err error
+	switch file.GetFolder() {
+	case app.Config.GetVorlagenFolder(), app.Config.GetSitzungenFolder(), app.Config.GetTopFolder():
+		p, err = NewTopHolder(app, file.GetPath())
+	case app.Config.GetPersonenFolder():
+		p, err = NewPerson(app, file)
+	case app.Config.GetFraktionenFolder():
+		p, err = NewFraktion(app, file)
+	case app.Config.GetAnwesenheitFolder():
+		p, err = NewAnwesenheitsliste(app, file)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.Parse(doc)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetEntityPath returns the path of the file in the fetched bucket a Vorlage, Sitzung or Top was created from
+func GetEntityPath(app *application.AppContext, key *datastore.Key) string {
+	conf := app.Config
+	switch key.Kind {
+	case conf.GetEntityVorlage():
+		return fmt.Sprintf("%s%s-%s.html", conf.GetVorlagenFolder(), conf.GetVorlageType(), key.Name)
+	case conf.GetEntitySitzung():
+		return fmt.Sprintf("%s%s-%s.html", conf.GetSitzungenFolder(), conf.GetSitzungType(), key.Name)
+	case conf.GetEntityTop():
+		if key.Parent == nil {
+			return ""
+		}
+		return fmt.Sprintf("%s%s-%s-%s-%s.html", conf.GetTopFolder(), conf.GetSitzungType(), key.Parent.Name, conf.GetTopType(), key.Name)
+	}
+	return ""
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/mail"
+	"github.com/rismaster/allris-common/common/slog"
+	"time"
+)
+
+// SendSomethingNewDigest send the daily or weekly digest (mail.DigestDaily, mail.DigestWeekly) of the SomethingNewMessages
+// saved with a Time in the period ending at now to the recipients with app.Mailer; run scheduled once per period,
+// nothing is sent without messages. Returns the number of messages sent
+func SendSomethingNewDigest(app *application.AppContext, period string, to []string, now time.Time) (int, error) {
+
+	_, von, err := mail.DigestZeitraum(period, now)
+	if err != nil {
+		return 0, err
+	}
+
+	var messages []mail.SomethingNewMessage
+	_, err = app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetSomethingNewEntity()).
+		Filter("Time >", von).
+		Filter("Time <=", now), &messages)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("error getting messages since %s from db", von))
+	}
+	if len(messages) == 0 {
+		slog.Info("no messages since %s, %s digest not sent", von, period)
+		return 0, nil
+	}
+
+	msg, err := mail.NewSomethingNewDigest(period, messages, now)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("error rendering %s digest", period))
+	}
+	msg.To = to
+
+	err = app.Mailer().Send(app.Ctx(), msg)
+	if err != nil {
+		return 0, errors.Wrap(err, fmt.Sprintf("error sending %s digest", period))
+	}
+	return len(messages), nil
+}
@@ -0,0 +1,423 @@
+package db
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/minhash"
+	"github.com/rismaster/allris-common/common/slog"
+	"reflect"
+	"sort"
+)
+
+const defaultDuplikatSchwelle = 0.7
+
+// SetAnlageSignatur store the MinHash signature with its bands and the length of the ocr text of an Anlage
+func SetAnlageSignatur(app *application.AppContext, key *datastore.Key, sig minhash.Signatur, zeichen int) error {
+
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+		var anlage Anlage
+		err := tx.Get(key, &anlage)
+		if err != nil {
+			return err
+		}
+		anlage.Signatur = toInt64(sig)
+		anlage.Baender = sig.Baender()
+		anlage.Zeichen = zeichen
+		_, err = tx.Put(key, &anlage)
+		return err
+	})
+	return err
+}
+
+// GetAnlagenDerFamilie list the near-duplicates of a family
+func GetAnlagenDerFamilie(app *application.AppContext, familie string) ([]*Anlage, error) {
+
+	var anlagen []*Anlage
+	keys, err := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityAnlage()).Filter("Familie =", familie), &anlagen)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting anlagen of family from db")
+	}
+	for i, a := range anlagen {
+		a.Config = app.Config
+		a.Key = keys[i]
+	}
+	return anlagen, nil
+}
+
+func duplikatSchwelle(app *application.AppContext) float64 {
+	schwelle := app.Config.GetDuplikatSchwelle()
+	if schwelle <= 0 {
+		schwelle = defaultDuplikatSchwelle
+	}
+	return schwelle
+}
+
+// errFamilieGeaendert is returned when an Anlage changed while its family was updated
+var errFamilieGeaendert = errors.New("family of anlage changed concurrently")
+
+// familieVersuche is the number of attempts to group an Anlage while its near-duplicates change concurrently
+const familieVersuche = 3
+
+// kandidat is the state of a near-duplicate candidate the grouping is based on
+type kandidat struct {
+	familie  string
+	aehnlich bool
+}
+
+// UpdateFamilie group an Anlage with its near-duplicates (similarity of at least Config.GetDuplikatSchwelle) found
+// by the bands of its signature, the families of the near-duplicates are merged, the Anlagen left in its former
+// family stay a family; only Familie and Kanonisch are saved, each Anlage in its own transaction, the grouping is
+// repeated if the Anlage, its candidates or the members changed meanwhile (the signature is saved before the lookup,
+// of two near-duplicates indexed at the same time the later one finds the other); returns the Anlagen with a changed
+// family
+func UpdateFamilie(app *application.AppContext, key *datastore.Key) ([]*Anlage, error) {
+
+	saved := make(map[string]*Anlage)
+	var err error
+	for versuch := 0; versuch < familieVersuche; versuch++ {
+		err = updateFamilie(app, key, saved)
+		if err != errFamilieGeaendert {
+			break
+		}
+		slog.Info("family of %s changed concurrently, grouping again", key.String())
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error grouping anlage %s", key.String()))
+	}
+
+	var changed []*Anlage
+	for _, a := range saved {
+		changed = append(changed, a)
+	}
+	return changed, nil
+}
+
+// updateFamilie is one attempt of UpdateFamilie, the saved Anlagen are added to saved
+func updateFamilie(app *application.AppContext, key *datastore.Key, saved map[string]*Anlage) error {
+
+	var anlage Anlage
+	err := app.Db().Get(app.Ctx(), key, &anlage)
+	if err != nil {
+		return errors.Wrap(err, "error getting anlage from db")
+	}
+	anlage.Config = app.Config
+	anlage.Key = key
+
+	group := map[string]*Anlage{key.Encode(): &anlage}
+	erwartet := make(map[string]string)
+	kandidaten := make(map[string]kandidat)
+	var kandidatenKeys []*datastore.Key
+	familien := make(map[string]bool)
+	sig := toUint64(anlage.Signatur)
+	schwelle := duplikatSchwelle(app)
+	for _, band := range anlage.Baender {
+		var candidates []*Anlage
+		keys, errBand := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityAnlage()).Filter("Baender =", band), &candidates)
+		if errBand != nil {
+			return errors.Wrap(errBand, "error getting near-duplicate candidates from db")
+		}
+		for i, c := range candidates {
+			k := keys[i].Encode()
+			if _, exist := kandidaten[k]; exist || keys[i].Equal(key) {
+				continue
+			}
+			aehnlich := minhash.Aehnlichkeit(sig, toUint64(c.Signatur)) >= schwelle
+			kandidaten[k] = kandidat{familie: c.Familie, aehnlich: aehnlich}
+			kandidatenKeys = append(kandidatenKeys, keys[i])
+			if !aehnlich {
+				continue
+			}
+			c.Config = app.Config
+			c.Key = keys[i]
+			group[k] = c
+			erwartet[k] = c.Familie
+			if c.Familie != "" {
+				familien[c.Familie] = true
+			}
+		}
+	}
+
+	for familie := range familien {
+		members, errFamilie := GetAnlagenDerFamilie(app, familie)
+		if errFamilie != nil {
+			return errFamilie
+		}
+		for _, m := range members {
+			if _, exist := group[m.Key.Encode()]; !exist {
+				group[m.Key.Encode()] = m
+				erwartet[m.Key.Encode()] = m.Familie
+			}
+		}
+	}
+
+	var members []*Anlage
+	for _, m := range group {
+		members = append(members, m)
+	}
+	//the Anlage leaves its former family if none of its near-duplicates is in it, the id stays with the rest
+	former := anlage.Familie
+	formerKanonisch := anlage.Kanonisch
+	verlassen := former != "" && !familien[former]
+	if verlassen {
+		anlage.Familie = ""
+	}
+	changed := setFamilie(members)
+
+	if verlassen {
+		formerMembers, errFamilie := GetAnlagenDerFamilie(app, former)
+		if errFamilie != nil {
+			return errFamilie
+		}
+		var rest []*Anlage
+		for _, m := range formerMembers {
+			if _, exist := group[m.Key.Encode()]; !exist {
+				rest = append(rest, m)
+				erwartet[m.Key.Encode()] = m.Familie
+			}
+		}
+		changed = append(changed, setFamilie(rest)...)
+	}
+
+	//the Anlage first: its signature and candidates are re-read, the others are only saved if they are still valid
+	err = saveAnlageFamilie(app, &anlage, former, formerKanonisch, kandidatenKeys, kandidaten)
+	if err != nil {
+		return err
+	}
+	if anlage.Familie != former || anlage.Kanonisch != formerKanonisch {
+		saved[key.Encode()] = &anlage
+	}
+	for _, m := range changed {
+		if m.Key.Equal(key) {
+			continue
+		}
+		err = setAnlageFamilie(app, m, erwartet[m.Key.Encode()])
+		if err != nil {
+			return err
+		}
+		saved[m.Key.Encode()] = m
+	}
+
+	slog.Info("family of %s with %d anlagen, %d changed", key.String(), len(members), len(changed))
+	return nil
+}
+
+// setFamilie make the Anlagen one family (no family for a single Anlage) and mark the canonical one,
+// returns the Anlagen with a changed family
+func setFamilie(members []*Anlage) []*Anlage {
+
+	id := ""
+	kanonisch := ""
+	if len(members) > 1 {
+		id = familienId(members)
+		kanonisch = canonical(members).Key.Encode()
+	}
+
+	var changed []*Anlage
+	for _, m := range members {
+		k := m.Key.Encode() == kanonisch
+		if m.Familie == id && m.Kanonisch == k {
+			continue
+		}
+		m.Familie = id
+		m.Kanonisch = k
+		changed = append(changed, m)
+	}
+	return changed
+}
+
+// familienId returns the id of the family with the most members, the id stays stable when Anlagen join a family,
+// a new family gets the hash of its smallest key
+func familienId(members []*Anlage) string {
+
+	count := make(map[string]int)
+	for _, m := range members {
+		if m.Familie != "" {
+			count[m.Familie]++
+		}
+	}
+	id := ""
+	for familie, n := range count {
+		if id == "" || n > count[id] || (n == count[id] && familie < id) {
+			id = familie
+		}
+	}
+	if id != "" {
+		return id
+	}
+
+	smallest := ""
+	for _, m := range members {
+		if k := m.Key.Encode(); smallest == "" || k < smallest {
+			smallest = k
+		}
+	}
+	return common.Md5HashStr(smallest)
+}
+
+// saveAnlageFamilie save Familie and Kanonisch of the grouped Anlage if neither it, its signature nor its candidates
+// changed since the grouping
+func saveAnlageFamilie(app *application.AppContext, anlage *Anlage, familie string, kanonisch bool,
+	kandidatenKeys []*datastore.Key, kandidaten map[string]kandidat) error {
+
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+
+		var fresh Anlage
+		err := tx.Get(anlage.Key, &fresh)
+		if err != nil {
+			return err
+		}
+		if fresh.Familie != familie || fresh.Kanonisch != kanonisch || !reflect.DeepEqual(fresh.Baender, anlage.Baender) {
+			return errFamilieGeaendert
+		}
+
+		candidates := make([]Anlage, len(kandidatenKeys))
+		err = tx.GetMulti(kandidatenKeys, candidates)
+		if _, deleted := err.(datastore.MultiError); deleted {
+			return errFamilieGeaendert
+		}
+		if err != nil {
+			return err
+		}
+		sig := toUint64(fresh.Signatur)
+		schwelle := duplikatSchwelle(app)
+		for i, c := range candidates {
+			aehnlich := minhash.Aehnlichkeit(sig, toUint64(c.Signatur)) >= schwelle
+			if kandidaten[kandidatenKeys[i].Encode()] != (kandidat{familie: c.Familie, aehnlich: aehnlich}) {
+				return errFamilieGeaendert
+			}
+		}
+
+		if fresh.Familie == anlage.Familie && fresh.Kanonisch == anlage.Kanonisch {
+			return nil
+		}
+		fresh.Familie = anlage.Familie
+		fresh.Kanonisch = anlage.Kanonisch
+		_, err = tx.Put(anlage.Key, &fresh)
+		return err
+	})
+	return err
+}
+
+// setAnlageFamilie save Familie and Kanonisch of an Anlage if its family is still the one the grouping is based on,
+// the bands of a signature saved without are added
+func setAnlageFamilie(app *application.AppContext, anlage *Anlage, familie string) error {
+
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+
+		var fresh Anlage
+		err := tx.Get(anlage.Key, &fresh)
+		if err != nil {
+			return err
+		}
+		if fresh.Familie != familie {
+			return errFamilieGeaendert
+		}
+		fresh.Familie = anlage.Familie
+		fresh.Kanonisch = anlage.Kanonisch
+		if len(fresh.Baender) == 0 && len(fresh.Signatur) > 0 {
+			fresh.Baender = toUint64(fresh.Signatur).Baender()
+		}
+		_, err = tx.Put(anlage.Key, &fresh)
+		return err
+	})
+	return err
+}
+
+// UpdateFamilien regroup all Anlagen with a signature into families of near-duplicates (similarity of at least
+// Config.GetDuplikatSchwelle) and mark the canonical one, the bands of signatures saved without are added;
+// UpdateFamilie is the incremental grouping of one Anlage, returns the Anlagen with a changed family
+func UpdateFamilien(app *application.AppContext) ([]*Anlage, error) {
+
+	var anlagen []*Anlage
+	keys, err := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityAnlage()), &anlagen)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting anlagen from db")
+	}
+
+	byKey := make(map[string]*Anlage)
+	erwartet := make(map[string]string)
+	signaturen := make(map[string]minhash.Signatur)
+	changedKeys := make(map[string]bool)
+	for i, a := range anlagen {
+		a.Config = app.Config
+		a.Key = keys[i]
+		byKey[a.Key.Encode()] = a
+		erwartet[a.Key.Encode()] = a.Familie
+		if len(a.Signatur) > 0 {
+			signaturen[a.Key.Encode()] = toUint64(a.Signatur)
+			if len(a.Baender) == 0 {
+				changedKeys[a.Key.Encode()] = true
+			}
+		}
+	}
+
+	grouped := make(map[string]bool)
+	familien := minhash.Familien(signaturen, duplikatSchwelle(app))
+	for _, familie := range familien {
+		var members []*Anlage
+		for _, k := range familie {
+			members = append(members, byKey[k])
+			grouped[k] = true
+		}
+		for _, a := range setFamilie(members) {
+			changedKeys[a.Key.Encode()] = true
+		}
+	}
+	for k, a := range byKey {
+		if !grouped[k] && len(setFamilie([]*Anlage{a})) > 0 {
+			changedKeys[k] = true
+		}
+	}
+
+	//an Anlage grouped meanwhile by UpdateFamilie keeps that family
+	var changed []*Anlage
+	for k := range changedKeys {
+		err = setAnlageFamilie(app, byKey[k], erwartet[k])
+		if err == errFamilieGeaendert {
+			slog.Info("family of %s changed concurrently, kept", byKey[k].Key.String())
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error saving family of anlage %s", byKey[k].Key.String()))
+		}
+		changed = append(changed, byKey[k])
+	}
+
+	slog.Info("grouped %d anlagen into %d families, %d changed", len(signaturen), len(familien), len(changed))
+	return changed, nil
+}
+
+// canonical returns the Anlage with the longest ocr text (the most complete or best scanned one),
+// the earliest saved for equal length
+func canonical(members []*Anlage) *Anlage {
+	sort.SliceStable(members, func(i, j int) bool {
+		if members[i].Zeichen != members[j].Zeichen {
+			return members[i].Zeichen > members[j].Zeichen
+		}
+		if !members[i].SavedAt.Equal(members[j].SavedAt) {
+			return members[i].SavedAt.Before(members[j].SavedAt)
+		}
+		return members[i].Key.Encode() < members[j].Key.Encode()
+	})
+	return members[0]
+}
+
+// the datastore has no unsigned integers
+func toInt64(sig minhash.Signatur) []int64 {
+	result := make([]int64, len(sig))
+	for i, v := range sig {
+		result[i] = int64(v)
+	}
+	return result
+}
+
+func toUint64(values []int64) minhash.Signatur {
+	result := make(minhash.Signatur, len(values))
+	for i, v := range values {
+		result[i] = uint64(v)
+	}
+	return result
+}
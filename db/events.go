@@ -0,0 +1,154 @@
+package db
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/slog"
+	"reflect"
+	"time"
+)
+
+const ActionUpdate = "update"
+const ActionDelete = "delete"
+
+// ChangeEvent is fired after a Vorlage, Top, Sitzung or Anlage was written to or deleted from the datastore
+type ChangeEvent struct {
+	Action string
+	Kind   string
+	Name   string
+	Key    *datastore.Key
+	Time   time.Time
+	Entity interface{}
+}
+
+// volatileFields are set at parse time or computed and are no content, changes are no ChangeEvent
+var volatileFields = map[string]bool{
+	"SavedAt":                     true,
+	"Lebenszyklus":                true,
+	"LebenszyklusSeit":            true,
+	"OffenInGremien":              true,
+	"LeterBeratungsStatus":        true,
+	"LeterBeratungsTyp":           true,
+	"LetesBeratungsGremium":       true,
+	"LetzteBeratungsSitzung":      true,
+	"LetzterBeratungsTop":         true,
+	"LetzterBeratungBeschlussart": true,
+	"LetzteBeratungDatum":         true,
+	"BeschlussGremium":            true,
+	"BeschlussDatum":              true,
+	"Zeichen":                     true,
+	"Familie":                     true,
+	"Kanonisch":                   true,
+	"AnlagenOrte":                 true,
+	"AnlagenGebiete":              true,
+}
+
+type ChangeListener func(app *application.AppContext, event ChangeEvent) error
+
+var changeListeners []ChangeListener
+
+// AddChangeListener register a listener called for every ChangeEvent (e.g. webhooks)
+func AddChangeListener(listener ChangeListener) {
+	changeListeners = append(changeListeners, listener)
+}
+
+func fireChange(app *application.AppContext, action string, key *datastore.Key, entity interface{}) {
+
+	if key == nil {
+		return
+	}
+
+	event := ChangeEvent{
+		Action: action,
+		Kind:   key.Kind,
+		Name:   key.Name,
+		Key:    key,
+		Time:   time.Now(),
+		Entity: entity,
+	}
+
+	for _, listener := range changeListeners {
+		err := listener(app, event)
+		if err != nil {
+			slog.Error("error in change listener for %s %s: %v", event.Action, key.String(), err)
+		}
+	}
+}
+
+// entityChanged compare the stored entity with the new one before it is saved, volatile fields are ignored
+func entityChanged(app *application.AppContext, key *datastore.Key, entity interface{}) bool {
+
+	var stored datastore.PropertyList
+	err := app.Db().Get(app.Ctx(), key, &stored)
+	if err != nil {
+		return true
+	}
+	props, err := datastore.SaveStruct(entity)
+	if err != nil {
+		slog.Warn("error comparing %s with stored entity: %v", key.String(), err)
+		return true
+	}
+	return !reflect.DeepEqual(normalizeProperties(stored), normalizeProperties(props))
+}
+
+func normalizeProperties(props []datastore.Property) map[string]interface{} {
+	result := make(map[string]interface{})
+	for _, p := range props {
+		if volatileFields[p.Name] {
+			continue
+		}
+		if v := normalizeValue(p.Value); v != nil {
+			result[p.Name] = v
+		}
+	}
+	return result
+}
+
+// normalizeValue ignores the time zone and the precision of times and empty lists (not stored)
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Truncate(time.Microsecond).UnixNano()
+	case *datastore.Entity:
+		if v == nil {
+			return nil
+		}
+		return normalizeProperties(v.Properties)
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		var result []interface{}
+		for _, e := range v {
+			result = append(result, normalizeValue(e))
+		}
+		return result
+	}
+	return value
+}
+
+// LoadEntity returns the Vorlage, Top, Sitzung or Anlage of the key
+func LoadEntity(app *application.AppContext, key *datastore.Key) (interface{}, error) {
+
+	var entity interface{}
+	switch key.Kind {
+	case app.Config.GetEntityVorlage():
+		entity = &Vorlage{}
+	case app.Config.GetEntityTop():
+		entity = &Top{}
+	case app.Config.GetEntitySitzung():
+		entity = &Sitzung{}
+	case app.Config.GetEntityAnlage():
+		entity = &Anlage{Config: app.Config}
+	default:
+		return nil, errors.New(fmt.Sprintf("unknown kind %s", key.Kind))
+	}
+
+	err := app.Db().Get(app.Ctx(), key, entity)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error loading %s", key.String()))
+	}
+	return entity, nil
+}
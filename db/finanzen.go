@@ -0,0 +1,97 @@
+package db
+
+import (
+	"cloud.google.com/go/datastore"
+	"github.com/kennygrant/sanitize"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/extract"
+	"sort"
+)
+
+// FinanzSumme is the budget approved by a Gremium in a year
+type FinanzSumme struct {
+	Gremium   string
+	Jahr      int
+	Anzahl    int
+	Einmalig  float64
+	Jaehrlich float64
+}
+
+// parseFinanzen extract amounts, Haushaltsjahre, Produkte and Kostenstellen of the FinanzielleAuswirkung
+func (v *Vorlage) parseFinanzen() {
+
+	f := extract.ParseFinanzen(domtools.CleanText(sanitize.HTML(v.FinanzielleAuswirkung)))
+	v.FinanzenKeine = f.Keine
+	v.FinanzenEinmalig = f.Einmalig
+	v.FinanzenJaehrlich = f.Jaehrlich
+	v.Haushaltsjahre = f.Haushaltsjahre
+	v.Produkte = f.Produkte
+	v.Kostenstellen = f.Kostenstellen
+}
+
+// GetFinanzSummen sum the amounts of the beschlossen Vorlagen per deciding Gremium and year of the decision
+func GetFinanzSummen(app *application.AppContext) ([]*FinanzSumme, error) {
+
+	vorlagen, err := getVorlagen(app, datastore.NewQuery(app.Config.GetEntityVorlage()).Filter("Lebenszyklus =", LebenszyklusBeschlossen))
+	if err != nil {
+		return nil, err
+	}
+	return SummiereFinanzen(vorlagen), nil
+}
+
+// GetFinanzSummenImJahr returns the sums of the decisions in the year
+func GetFinanzSummenImJahr(app *application.AppContext, jahr int) ([]*FinanzSumme, error) {
+
+	summen, err := GetFinanzSummen(app)
+	if err != nil {
+		return nil, err
+	}
+	var result []*FinanzSumme
+	for _, s := range summen {
+		if s.Jahr == jahr {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// SummiereFinanzen group the Vorlagen with financial impact by the Gremium and the year of the deciding Beratung
+// (BeschlussGremium, BeschlussDatum; LetesBeratungsGremium and LetzteBeratungDatum of Vorlagen saved before)
+func SummiereFinanzen(vorlagen []*Vorlage) []*FinanzSumme {
+
+	type gruppe struct {
+		gremium string
+		jahr    int
+	}
+	summen := make(map[gruppe]*FinanzSumme)
+	for _, v := range vorlagen {
+		if v.FinanzenEinmalig == 0 && v.FinanzenJaehrlich == 0 {
+			continue
+		}
+		g := gruppe{gremium: v.BeschlussGremium, jahr: v.BeschlussDatum.Year()}
+		if g.gremium == "" {
+			g = gruppe{gremium: v.LetesBeratungsGremium, jahr: v.LetzteBeratungDatum.Year()}
+		}
+		s, exist := summen[g]
+		if !exist {
+			s = &FinanzSumme{Gremium: g.gremium, Jahr: g.jahr}
+			summen[g] = s
+		}
+		s.Anzahl++
+		s.Einmalig += v.FinanzenEinmalig
+		s.Jaehrlich += v.FinanzenJaehrlich
+	}
+
+	var result []*FinanzSumme
+	for _, s := range summen {
+		result = append(result, s)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Jahr != result[j].Jahr {
+			return result[i].Jahr > result[j].Jahr
+		}
+		return result[i].Gremium < result[j].Gremium
+	})
+	return result
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/files"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fraktion is a Fraktion or other Organisation (pa021) Personen are member of
+type Fraktion struct {
+	PALFDNR int
+	Name    string
+	Kuerzel string
+
+	SavedAt time.Time
+	file    *files.File
+	app     *application.AppContext
+}
+
+func NewFraktion(app *application.AppContext, file *files.File) (*Fraktion, error) {
+
+	palfdnrStr := strings.TrimPrefix(strings.TrimSuffix(file.GetName(), ".html"), app.Config.GetFraktionType()+"-")
+	palfdnr, err := strconv.Atoi(palfdnrStr)
+	if err != nil {
+		return nil, err
+	}
+	return &Fraktion{
+		PALFDNR: palfdnr,
+		file:    file,
+		app:     app,
+	}, nil
+}
+
+func (f *Fraktion) GetKey() *datastore.Key {
+	return datastore.NameKey(f.app.Config.GetEntityFraktion(), fmt.Sprintf("%d", f.PALFDNR), nil)
+}
+
+func (f *Fraktion) GetMitgliedschaftenQuery() *datastore.Query {
+	return datastore.NewQuery(f.app.Config.GetEntityMitgliedschaft()).Filter("PALFDNR =", f.PALFDNR)
+}
+
+func (f *Fraktion) Parse(doc *goquery.Document) error {
+
+	name := domtools.CleanText(doc.Find("#allriscontainer h1").First().Text())
+	for _, prefix := range []string{"Fraktion - ", "Organisation - "} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	if name == "" {
+		return errors.New(fmt.Sprintf("leerer Name in Fraktion %d", f.PALFDNR))
+	}
+
+	matches := regexKuerzel.FindStringSubmatch(name)
+	if len(matches) > 1 {
+		f.Kuerzel = domtools.CleanText(matches[1])
+		f.Name = domtools.CleanText(strings.TrimSuffix(name, matches[0]))
+	} else {
+		f.Name = name
+		f.Kuerzel = createKuerzel(name)
+	}
+	return nil
+}
+
+func (f *Fraktion) SaveOrUpdate() error {
+	_, err := f.app.Db().Put(f.app.Ctx(), f.GetKey(), f)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error saving to db fraktion from %s", f.file.GetName()))
+	}
+	return nil
+}
+
+func (f *Fraktion) Delete() error {
+	return f.app.Db().Delete(f.app.Ctx(), f.GetKey())
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"cloud.google.com/go/datastore"
+	"encoding/json"
+	"fmt"
+	"github.com/kennygrant/sanitize"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/geo"
+	"github.com/rismaster/allris-common/common/slog"
+	"reflect"
+	"sort"
+)
+
+// GeoJsonAlle is the name of the export with the Vorlagen of all areas
+const GeoJsonAlle = "alle.geojson"
+
+// GeoJsonGebieteFolder is the folder of the exports of the areas, apart from GeoJsonAlle (an area may be named "alle")
+const GeoJsonGebieteFolder = "gebiete/"
+
+// GetVorlagenImGebiet list the geocoded Vorlagen of an area, also by the locations of their Anlagen
+func GetVorlagenImGebiet(app *application.AppContext, gebiet string) ([]*Vorlage, error) {
+
+	var result []*Vorlage
+	seen := make(map[int]bool)
+	for _, property := range []string{"Gebiete =", "AnlagenGebiete ="} {
+		vorlagen, err := getVorlagen(app, datastore.NewQuery(app.Config.GetEntityVorlage()).Filter(property, gebiet))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range vorlagen {
+			if !seen[v.VOLFDNR] {
+				seen[v.VOLFDNR] = true
+				result = append(result, v)
+			}
+		}
+	}
+	return result, nil
+}
+
+// alleOrte returns the locations of the Vorlage and of its Anlagen
+func (v *Vorlage) alleOrte() []geo.Ort {
+	return append(append([]geo.Ort{}, v.Orte...), v.AnlagenOrte...)
+}
+
+// SetAnlageOrte store the locations of an Anlage and merge the locations of all Anlagen of the parent Vorlage
+// into its AnlagenOrte and AnlagenGebiete, the Anlagen of Sitzungen and Tops are not exported
+func SetAnlageOrte(app *application.AppContext, key *datastore.Key, orte []geo.Ort) error {
+
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+
+		var anlage Anlage
+		err := tx.Get(key, &anlage)
+		if err != nil {
+			return err
+		}
+		if reflect.DeepEqual(anlage.Orte, orte) || (len(anlage.Orte) == 0 && len(orte) == 0) {
+			return nil
+		}
+		anlage.Orte = orte
+		_, err = tx.Put(key, &anlage)
+		if err != nil {
+			return err
+		}
+
+		parentKey := key.Parent
+		if parentKey == nil || parentKey.Kind != app.Config.GetEntityVorlage() {
+			return nil
+		}
+		var vorlage Vorlage
+		err = tx.Get(parentKey, &vorlage)
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var anlagen []*Anlage
+		keys, err := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityAnlage()).Ancestor(parentKey).Transaction(tx), &anlagen)
+		if err != nil {
+			return err
+		}
+		vorlage.AnlagenOrte = orte
+		for i, a := range anlagen {
+			//the query does not see the put of this transaction
+			if !keys[i].Equal(key) {
+				vorlage.AnlagenOrte = append(vorlage.AnlagenOrte, a.Orte...)
+			}
+		}
+		vorlage.AnlagenGebiete = gebieteDerOrte(vorlage.AnlagenOrte)
+		_, err = tx.Put(parentKey, &vorlage)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error saving locations of anlage %s", key.String()))
+	}
+	return nil
+}
+
+// VorlagenGeoJson returns one feature per Vorlage with its locations (of the area, all if gebiet is empty)
+func VorlagenGeoJson(vorlagen []*Vorlage, gebiet string) *geo.FeatureCollection {
+
+	fc := geo.NewFeatureCollection()
+	for _, v := range vorlagen {
+		var orte []geo.Ort
+		for _, o := range v.alleOrte() {
+			if gebiet == "" || o.Gebiet == gebiet {
+				orte = append(orte, o)
+			}
+		}
+		fc.Add(geo.NewFeature(orte, map[string]interface{}{
+			"volfdnr":       v.VOLFDNR,
+			"bsvv":          v.BSVV,
+			"betreff":       v.Betreff,
+			"status":        v.Status,
+			"lebenszyklus":  v.Lebenszyklus,
+			"federfuehrend": v.Federfuehrend,
+			"datum":         v.DatumAngelegt.Format("2006-01-02"),
+			"gebiete":       mergeGebiete(v.Gebiete, v.AnlagenGebiete),
+		}))
+	}
+	return fc
+}
+
+// ExportGeoJson write the geocoded Vorlagen (with the locations of their Anlagen) as one GeoJSON file per area
+// in GeoJsonGebieteFolder and one with all areas (GeoJsonAlle) to Config.GetBucketGeoJson
+func ExportGeoJson(app *application.AppContext) error {
+
+	vorlagen, err := getVorlagen(app, datastore.NewQuery(app.Config.GetEntityVorlage()))
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(vorlagen, func(i, j int) bool {
+		return vorlagen[i].DatumAngelegt.After(vorlagen[j].DatumAngelegt)
+	})
+
+	var geocoded []*Vorlage
+	gebiete := make(map[string][]*Vorlage)
+	for _, v := range vorlagen {
+		if len(v.Orte) == 0 && len(v.AnlagenOrte) == 0 {
+			continue
+		}
+		geocoded = append(geocoded, v)
+		for _, g := range mergeGebiete(v.Gebiete, v.AnlagenGebiete) {
+			gebiete[g] = append(gebiete[g], v)
+		}
+	}
+
+	err = writeGeoJson(app, GeoJsonAlle, VorlagenGeoJson(geocoded, ""))
+	if err != nil {
+		return err
+	}
+	for gebiet, imGebiet := range gebiete {
+		err = writeGeoJson(app, GeoJsonGebieteFolder+sanitize.BaseName(gebiet)+".geojson", VorlagenGeoJson(imGebiet, gebiet))
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("exported %d geocoded vorlagen in %d areas to %s", len(geocoded), len(gebiete), app.Config.GetBucketGeoJson())
+	return nil
+}
+
+// mergeGebiete returns the areas of both lists without duplicates
+func mergeGebiete(a []string, b []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, g := range append(append([]string{}, a...), b...) {
+		if !seen[g] {
+			seen[g] = true
+			result = append(result, g)
+		}
+	}
+	return result
+}
+
+func writeGeoJson(app *application.AppContext, name string, fc *geo.FeatureCollection) error {
+
+	data, err := json.Marshal(fc)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error creating geojson %s", name))
+	}
+
+	wc := app.Store().Bucket(app.Config.GetBucketGeoJson()).Object(name).NewWriter(app.Ctx())
+	wc.ContentType = "application/geo+json"
+	_, err = wc.Write(data)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing %s", name))
+	}
+	err = wc.Close()
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing %s", name))
+	}
+	return nil
+}
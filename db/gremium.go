@@ -0,0 +1,267 @@
+package db
+
+import (
+	"bytes"
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const GremiumTypAusschuss = "Ausschuss"
+const GremiumTypBeirat = "Beirat"
+const GremiumTypRat = "Rat"
+const GremiumTypSonstiges = "Sonstiges"
+
+// Gremium is identified by the GRA option value of the ALLRIS Sitzungsliste
+type Gremium struct {
+	GRA      int
+	Name     string
+	NameNorm string
+	Kuerzel  string
+	Typ      string
+	Aktiv    bool
+
+	SavedAt time.Time
+}
+
+var regexKuerzel = regexp.MustCompile(`\(([^()]+)\)\s*$`)
+
+func GetGremiumKey(app *application.AppContext, gra int) *datastore.Key {
+	return datastore.NameKey(app.Config.GetEntityGremium(), fmt.Sprintf("%d", gra), nil)
+}
+
+func NewGremium(gra int, name string) *Gremium {
+
+	name = domtools.CleanText(name)
+	kuerzel := ""
+	matches := regexKuerzel.FindStringSubmatch(name)
+	if len(matches) > 1 {
+		kuerzel = domtools.CleanText(matches[1])
+		name = domtools.CleanText(strings.TrimSuffix(name, matches[0]))
+	} else {
+		kuerzel = createKuerzel(name)
+	}
+
+	return &Gremium{
+		GRA:      gra,
+		Name:     name,
+		NameNorm: NormalizeGremiumName(name),
+		Kuerzel:  kuerzel,
+		Typ:      gremiumTyp(name),
+		Aktiv:    true,
+		SavedAt:  time.Now(),
+	}
+}
+
+// NormalizeGremiumName is used to link the free text Gremium of Sitzung, Top and Termin to a Gremium
+func NormalizeGremiumName(name string) string {
+	name = regexKuerzel.ReplaceAllString(name, "")
+	return strings.ToLower(domtools.CleanText(name))
+}
+
+func gremiumTyp(name string) string {
+	n := strings.ToLower(name)
+	switch {
+	case strings.Contains(n, "ausschuss"):
+		return GremiumTypAusschuss
+	case strings.Contains(n, "beirat"):
+		return GremiumTypBeirat
+	case strings.HasSuffix(n, "rat"), strings.Contains(n, "vertretung"), strings.Contains(n, "bürgerschaft"), strings.Contains(n, "kreistag"):
+		return GremiumTypRat
+	}
+	return GremiumTypSonstiges
+}
+
+func createKuerzel(name string) string {
+	var kuerzel []rune
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '-' || r == ','
+	}) {
+		first := []rune(word)[0]
+		if unicode.IsUpper(first) {
+			kuerzel = append(kuerzel, first)
+		}
+	}
+	return string(kuerzel)
+}
+
+// UpdateGremien parse the stored Gremien options page and save all Gremien,
+// Gremien no longer offered in ALLRIS are kept but marked inactive
+func UpdateGremien(app *application.AppContext) error {
+
+	f := files.NewFileFromStore(app, "", app.Config.GetGremienOptionsType()+".html")
+	err := f.ReadDocument(app.Config.GetBucketFetched())
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error reading file %s", f.GetName()))
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(f.GetContent()))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error create dom from %s", f.GetName()))
+	}
+
+	gremien := parseGremienOptions(doc)
+	if len(gremien) < 1 {
+		return errors.New("empty gremien")
+	}
+
+	var oldGremien []*Gremium
+	oldKeys, err := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityGremium()), &oldGremien)
+	if err != nil {
+		return errors.Wrap(err, "error getting gremien from db")
+	}
+
+	found := make(map[int]bool)
+	var keys []*datastore.Key
+	for _, g := range gremien {
+		found[g.GRA] = true
+		keys = append(keys, GetGremiumKey(app, g.GRA))
+	}
+
+	for i, old := range oldGremien {
+		if !found[old.GRA] && old.Aktiv {
+			slog.Info("Gremium %d (%s) not in RIS anymore, set inactive", old.GRA, old.Name)
+			old.Aktiv = false
+			old.SavedAt = time.Now()
+			keys = append(keys, oldKeys[i])
+			gremien = append(gremien, old)
+		}
+	}
+
+	_, err = app.Db().PutMulti(app.Ctx(), keys, gremien)
+	if err != nil {
+		return errors.Wrap(err, "error saving gremien to db")
+	}
+	invalidateGremiumResolver()
+	slog.Info("saved %d gremien", len(gremien))
+	return nil
+}
+
+func parseGremienOptions(doc *goquery.Document) (gremien []*Gremium) {
+
+	doc.Find("select[name=\"GRA\"] option").Each(func(i int, s *goquery.Selection) {
+		optStr, ok := s.Attr("value")
+		if !ok {
+			return
+		}
+		opt, err := strconv.Atoi(optStr)
+		if err != nil {
+			slog.Warn("error parsing opt value ignored: %s reason: %v", optStr, err)
+		} else if opt < 1000 {
+			gremien = append(gremien, NewGremium(opt, s.Text()))
+		}
+	})
+	return gremien
+}
+
+func GetGremien(app *application.AppContext, nurAktive bool) ([]*Gremium, error) {
+
+	query := datastore.NewQuery(app.Config.GetEntityGremium())
+	if nurAktive {
+		query = query.Filter("Aktiv =", true)
+	}
+
+	var gremien []*Gremium
+	_, err := app.Db().GetAll(app.Ctx(), query, &gremien)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting gremien from db")
+	}
+	return gremien, nil
+}
+
+// GremiumResolver map Gremium names to the GRA of the Gremium
+type GremiumResolver struct {
+	byName map[string]int
+}
+
+// gremiumResolverTTL is the time a GremiumResolver is reused by GetGremiumResolver
+const gremiumResolverTTL = 10 * time.Minute
+
+var gremiumResolverMutex sync.Mutex
+var gremiumResolverCache *GremiumResolver
+var gremiumResolverTime time.Time
+
+// GetGremiumResolver returns the resolver of all Gremien, loaded at most once per gremiumResolverTTL
+func GetGremiumResolver(app *application.AppContext) (*GremiumResolver, error) {
+
+	gremiumResolverMutex.Lock()
+	defer gremiumResolverMutex.Unlock()
+
+	if gremiumResolverCache != nil && time.Since(gremiumResolverTime) < gremiumResolverTTL {
+		return gremiumResolverCache, nil
+	}
+	resolver, err := NewGremiumResolver(app)
+	if err != nil {
+		return nil, err
+	}
+	gremiumResolverCache, gremiumResolverTime = resolver, time.Now()
+	return resolver, nil
+}
+
+func invalidateGremiumResolver() {
+	gremiumResolverMutex.Lock()
+	defer gremiumResolverMutex.Unlock()
+	gremiumResolverCache = nil
+}
+
+func NewGremiumResolver(app *application.AppContext) (*GremiumResolver, error) {
+
+	gremien, err := GetGremien(app, false)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := &GremiumResolver{byName: make(map[string]int)}
+	for _, g := range gremien {
+		resolver.byName[g.NameNorm] = g.GRA
+		if g.Kuerzel != "" {
+			if _, exist := resolver.byName[strings.ToLower(g.Kuerzel)]; !exist {
+				resolver.byName[strings.ToLower(g.Kuerzel)] = g.GRA
+			}
+		}
+	}
+	return resolver, nil
+}
+
+// Resolve returns the GRA for a Gremium name or 0 if not known
+func (r *GremiumResolver) Resolve(name string) int {
+	if r == nil || name == "" {
+		return 0
+	}
+	return r.byName[NormalizeGremiumName(name)]
+}
+
+func resolveGremien(app *application.AppContext, s TopHolder) {
+
+	resolver, err := GetGremiumResolver(app)
+	if err != nil {
+		slog.Warn("gremien not resolved for %s: %v", s.GetKey().String(), err)
+		return
+	}
+
+	switch holder := s.(type) {
+	case *Sitzung:
+		holder.GremiumID = resolver.Resolve(holder.Gremium)
+	case *Top:
+		holder.GremiumID = resolver.Resolve(holder.Gremium)
+	}
+
+	for _, t := range s.GetTops() {
+		t.GremiumID = resolver.Resolve(t.Gremium)
+	}
+}
+
+func GetSitzungenQueryByGremium(app *application.AppContext, gra int) *datastore.Query {
+	return datastore.NewQuery(app.Config.GetEntitySitzung()).Filter("GremiumID =", gra)
+}
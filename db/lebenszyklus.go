@@ -0,0 +1,256 @@
+package db
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/kennygrant/sanitize"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/slog"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const LebenszyklusEingebracht = "eingebracht"
+const LebenszyklusInBeratung = "in Beratung"
+const LebenszyklusVertagt = "vertagt"
+const LebenszyklusBeschlossen = "beschlossen"
+const LebenszyklusAbgelehnt = "abgelehnt"
+const LebenszyklusZurueckgezogen = "zurückgezogen"
+
+const beschlussVorlageShortLen = 300
+
+// LebenszyklusOffen are the states of Vorlagen still to be decided
+var LebenszyklusOffen = []string{LebenszyklusEingebracht, LebenszyklusInBeratung, LebenszyklusVertagt}
+
+func containsAny(s string, words ...string) bool {
+	for _, w := range words {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// computeLebenszyklus derive the state and the "letzte Beratung" fields from the Beratungsfolge (sorted by Datum)
+func (v *Vorlage) computeLebenszyklus(now time.Time) {
+
+	v.BeschlussVorlageShort = shorten(domtools.CleanText(sanitize.HTML(v.BeschlussVorlage)), beschlussVorlageShortLen)
+
+	var letzte *Top
+	var letzterIndex = -1
+	for i, b := range v.Beratungsfolge {
+		if !b.Datum.After(now) {
+			letzte = b
+			letzterIndex = i
+		}
+	}
+
+	v.OffenInGremien = nil
+	if letzte == nil {
+		v.LeterBeratungsStatus = ""
+		v.LeterBeratungsTyp = ""
+		v.LetesBeratungsGremium = ""
+		v.LetzteBeratungsSitzung = 0
+		v.LetzterBeratungsTop = 0
+		v.LetzterBeratungBeschlussart = ""
+		v.LetzteBeratungDatum = time.Time{}
+		v.Lebenszyklus = LebenszyklusEingebracht
+		v.LebenszyklusSeit = v.DatumAngelegt
+	} else {
+		v.LeterBeratungsStatus = letzte.Beschlussstatus
+		v.LeterBeratungsTyp = letzte.Typ
+		v.LetesBeratungsGremium = letzte.Gremium
+		v.LetzteBeratungsSitzung = letzte.SILFDNR
+		v.LetzterBeratungsTop = letzte.TOLFDNR
+		v.LetzterBeratungBeschlussart = letzte.Beschlussart
+		v.LetzteBeratungDatum = letzte.Datum
+		v.LebenszyklusSeit = letzte.Datum
+
+		istLetzte := letzterIndex == len(v.Beratungsfolge)-1
+		entscheidung := istLetzte || strings.Contains(strings.ToLower(letzte.Typ), "entscheidung")
+		art := strings.ToLower(letzte.Beschlussart + " " + letzte.Beschlussstatus)
+
+		switch {
+		case containsAny(art, "zurückgezogen"):
+			v.Lebenszyklus = LebenszyklusZurueckgezogen
+		case containsAny(art, "vertagt", "zurückgestellt", "abgesetzt", "zurückverwiesen", "verwiesen"):
+			v.Lebenszyklus = LebenszyklusVertagt
+		case entscheidung && containsAny(art, "abgelehnt"):
+			v.Lebenszyklus = LebenszyklusAbgelehnt
+		case entscheidung && containsAny(art, "beschlossen", "zugestimmt", "kenntnis genommen", "kenntnisnahme"):
+			v.Lebenszyklus = LebenszyklusBeschlossen
+		default:
+			v.Lebenszyklus = LebenszyklusInBeratung
+		}
+	}
+
+	if containsAny(strings.ToLower(v.Status), "zurückgezogen") {
+		v.Lebenszyklus = LebenszyklusZurueckgezogen
+	}
+
+	v.BeschlussGremium = ""
+	v.BeschlussDatum = time.Time{}
+	if v.Lebenszyklus == LebenszyklusBeschlossen {
+		beschluss := entscheidendeBeratung(v.Beratungsfolge[:letzterIndex+1])
+		v.BeschlussGremium = beschluss.Gremium
+		v.BeschlussDatum = beschluss.Datum
+	}
+
+	if v.IsOffen() {
+		found := make(map[string]bool)
+		for i, b := range v.Beratungsfolge {
+			if i < letzterIndex || b.Gremium == "" || found[b.Gremium] {
+				continue
+			}
+			if i == letzterIndex && v.Lebenszyklus == LebenszyklusInBeratung && b.Beschlussart != "" {
+				continue
+			}
+			found[b.Gremium] = true
+			v.OffenInGremien = append(v.OffenInGremien, b.Gremium)
+		}
+	}
+}
+
+// entscheidendeBeratung returns the last Beratung of the type "Entscheidung" approving the Vorlage,
+// the last Beratung if there is none (later Beratungen may only take note of the decision)
+func entscheidendeBeratung(beratungen []*Top) *Top {
+	for i := len(beratungen) - 1; i >= 0; i-- {
+		b := beratungen[i]
+		art := strings.ToLower(b.Beschlussart + " " + b.Beschlussstatus)
+		if strings.Contains(strings.ToLower(b.Typ), "entscheidung") && containsAny(art, "beschlossen", "zugestimmt") {
+			return b
+		}
+	}
+	return beratungen[len(beratungen)-1]
+}
+
+func (v *Vorlage) IsOffen() bool {
+	for _, s := range LebenszyklusOffen {
+		if v.Lebenszyklus == s {
+			return true
+		}
+	}
+	return false
+}
+
+func shorten(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+	return strings.TrimSpace(string(r[:max])) + "…"
+}
+
+// GetOffeneVorlagenImGremium list all open Vorlagen with a pending Beratung in the Gremium
+func GetOffeneVorlagenImGremium(app *application.AppContext, gremium string) ([]*Vorlage, error) {
+	return getVorlagen(app, datastore.NewQuery(app.Config.GetEntityVorlage()).Filter("OffenInGremien =", gremium))
+}
+
+// GetVorlagenOffenSeit list all open Vorlagen without change of their state for at least the given duration
+func GetVorlagenOffenSeit(app *application.AppContext, dauer time.Duration) ([]*Vorlage, error) {
+
+	stichtag := time.Now().Add(-dauer)
+	var result []*Vorlage
+	for _, zustand := range LebenszyklusOffen {
+		vorlagen, err := getVorlagen(app, datastore.NewQuery(app.Config.GetEntityVorlage()).
+			Filter("Lebenszyklus =", zustand).
+			Filter("LebenszyklusSeit <", stichtag))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, vorlagen...)
+	}
+	return result, nil
+}
+
+// UpdateLebenszyklen recompute the lifecycle of the Vorlagen with a Beratung in (seit, now], it changes when the date
+// of a Beratung passes without a new fetch of the Vorlage; run scheduled with the time of the last run as seit,
+// returns the number of changed Vorlagen
+func UpdateLebenszyklen(app *application.AppContext, seit time.Time, now time.Time) (int, error) {
+
+	var beratungen []*Top
+	_, err := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityTop()).
+		Filter("Datum >", seit).
+		Filter("Datum <=", now), &beratungen)
+	if err != nil {
+		return 0, errors.Wrap(err, "error getting beratungen from db")
+	}
+
+	vorlagen := make(map[int]bool)
+	for _, b := range beratungen {
+		if b.VOLFDNR > 0 {
+			vorlagen[b.VOLFDNR] = true
+		}
+	}
+
+	changed := 0
+	for volfdnr := range vorlagen {
+		c, errUpdate := updateLebenszyklus(app, volfdnr, now)
+		if errUpdate != nil {
+			return changed, errUpdate
+		}
+		if c {
+			changed++
+		}
+	}
+	slog.Info("lebenszyklus of %d vorlagen with beratungen since %s checked, %d changed", len(vorlagen), seit, changed)
+	return changed, nil
+}
+
+// updateLebenszyklus recompute the lifecycle of a Vorlage from its saved Beratungen at now
+func updateLebenszyklus(app *application.AppContext, volfdnr int, now time.Time) (bool, error) {
+
+	v := &Vorlage{VOLFDNR: volfdnr, app: app}
+	var beratungen []*Top
+	_, err := app.Db().GetAll(app.Ctx(), v.GetTopQuery(), &beratungen)
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("error getting beratungen of vorlage %d from db", volfdnr))
+	}
+	var beratungsfolge []*Top
+	for _, b := range beratungen {
+		if !b.Datum.IsZero() {
+			beratungsfolge = append(beratungsfolge, b)
+		}
+	}
+	sort.SliceStable(beratungsfolge, func(i, j int) bool {
+		return beratungsfolge[i].Datum.Before(beratungsfolge[j].Datum)
+	})
+
+	changed := false
+	_, err = app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+
+		var stored Vorlage
+		errTx := tx.Get(v.GetKey(), &stored)
+		if errTx == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if errTx != nil {
+			return errTx
+		}
+
+		before := stored.lebenszyklusStand()
+		stored.Beratungsfolge = beratungsfolge
+		stored.computeLebenszyklus(now)
+		changed = !reflect.DeepEqual(before, stored.lebenszyklusStand())
+		if !changed {
+			return nil
+		}
+		_, errTx = tx.Put(v.GetKey(), &stored)
+		return errTx
+	})
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("error saving lebenszyklus of vorlage %d", volfdnr))
+	}
+	return changed, nil
+}
+
+// lebenszyklusStand returns the fields set by computeLebenszyklus
+func (v *Vorlage) lebenszyklusStand() []interface{} {
+	return []interface{}{v.Lebenszyklus, v.LebenszyklusSeit, v.OffenInGremien, v.LeterBeratungsStatus, v.LeterBeratungsTyp,
+		v.LetesBeratungsGremium, v.LetzteBeratungsSitzung, v.LetzterBeratungsTop, v.LetzterBeratungBeschlussart,
+		v.LetzteBeratungDatum, v.BeschlussGremium, v.BeschlussDatum, v.BeschlussVorlageShort}
+}
@@ -0,0 +1,282 @@
+package db
+
+import (
+	"bytes"
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/kennygrant/sanitize"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var regexDatum = regexp.MustCompile(`^[0-9]{2}\.[0-9]{2}\.[0-9]{4}$`)
+
+type Person struct {
+	KPLFDNR  int
+	Name     string
+	Fraktion string
+	PALFDNR  int
+
+	Mitgliedschaften []*Mitgliedschaft `datastore:"-"`
+
+	SavedAt time.Time
+	file    *files.File
+	app     *application.AppContext
+}
+
+// Mitgliedschaft of a Person in a Gremium (GRA) or in a Fraktion/Organisation (PALFDNR)
+type Mitgliedschaft struct {
+	KPLFDNR  int
+	GRA      int
+	PALFDNR  int
+	Gremium  string
+	Rolle    string
+	Von      time.Time
+	Bis      time.Time
+	Fraktion bool
+
+	SavedAt time.Time
+}
+
+func NewPerson(app *application.AppContext, file *files.File) (*Person, error) {
+
+	kplfdnrStr := strings.TrimPrefix(strings.TrimSuffix(file.GetName(), ".html"), app.Config.GetPersonType()+"-")
+	kplfdnr, err := strconv.Atoi(kplfdnrStr)
+	if err != nil {
+		return nil, err
+	}
+	return &Person{
+		KPLFDNR: kplfdnr,
+		file:    file,
+		app:     app,
+	}, nil
+}
+
+func (p *Person) GetKey() *datastore.Key {
+	return datastore.NameKey(p.app.Config.GetEntityPerson(), fmt.Sprintf("%d", p.KPLFDNR), nil)
+}
+
+func (p *Person) GetMitgliedschaftenQuery() *datastore.Query {
+	return datastore.NewQuery(p.app.Config.GetEntityMitgliedschaft()).Ancestor(p.GetKey())
+}
+
+func (m *Mitgliedschaft) GetKey(app *application.AppContext, parentKey *datastore.Key) *datastore.Key {
+	kn := fmt.Sprintf("%d_%d_%s_%s_%s", m.GRA, m.PALFDNR, m.Gremium, m.Rolle, m.Von.Format(app.Config.GetDateFormatTech()))
+	return datastore.NameKey(app.Config.GetEntityMitgliedschaft(), sanitize.Name(kn), parentKey)
+}
+
+// IsAktiv returns true if the Mitgliedschaft is valid at the given time
+func (m *Mitgliedschaft) IsAktiv(at time.Time) bool {
+	return !m.Von.After(at) && (m.Bis.IsZero() || !m.Bis.Before(at))
+}
+
+func (p *Person) Parse(doc *goquery.Document) error {
+
+	dom := doc.Find("#allriscontainer").First()
+
+	name := domtools.CleanText(dom.Find("h1").First().Text())
+	for _, prefix := range []string{"Person - ", "Mandatsträger - ", "Mandatsträger/-in - "} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	p.Name = name
+	if p.Name == "" {
+		return errors.New(fmt.Sprintf("leerer Name in Person %d", p.KPLFDNR))
+	}
+
+	dom.Find("tr.zl11, tr.zl12").Each(func(i int, selection *goquery.Selection) {
+		m := p.parseMitgliedschaft(selection)
+		if m != nil {
+			p.Mitgliedschaften = append(p.Mitgliedschaften, m)
+		}
+	})
+
+	return nil
+}
+
+// Derive resolve the Gremien of the Mitgliedschaften and set the Fraktion of the person at now
+func (p *Person) Derive(now time.Time) {
+
+	resolver, err := GetGremiumResolver(p.app)
+	if err != nil {
+		slog.Warn("gremien of person %d not resolved: %v", p.KPLFDNR, err)
+	}
+
+	var aktuell *Mitgliedschaft
+	for _, m := range p.Mitgliedschaften {
+		m.SavedAt = now
+		if m.Fraktion {
+			if m.IsAktiv(now) && (aktuell == nil || m.Von.After(aktuell.Von)) {
+				aktuell = m
+			}
+		} else {
+			m.GRA = resolver.Resolve(m.Gremium)
+		}
+	}
+	if aktuell != nil {
+		p.Fraktion = aktuell.Gremium
+		p.PALFDNR = aktuell.PALFDNR
+	}
+}
+
+func (p *Person) parseMitgliedschaft(selection *goquery.Selection) *Mitgliedschaft {
+
+	m := &Mitgliedschaft{
+		KPLFDNR: p.KPLFDNR,
+	}
+
+	lnk := selection.Find("a").First()
+	if lnk.Nodes != nil {
+		m.Gremium = domtools.CleanText(lnk.Text())
+		href, _ := lnk.Attr("href")
+		if matches := domtools.RegexFraktionLink.FindStringSubmatch(href); len(matches) > 1 {
+			m.PALFDNR = domtools.StringToIntOrNeg(matches[1])
+			m.Fraktion = true
+		}
+	}
+
+	var daten []time.Time
+	selection.Find("td").Each(func(i int, td *goquery.Selection) {
+		text := domtools.CleanText(td.Text())
+		if text == "" || text == m.Gremium {
+			return
+		}
+		if regexDatum.MatchString(text) {
+			d, err := time.Parse(p.app.Config.GetDateFormat(), text)
+			if err == nil {
+				daten = append(daten, d)
+			}
+		} else if m.Gremium == "" {
+			m.Gremium = text
+		} else if m.Rolle == "" {
+			m.Rolle = text
+		}
+	})
+
+	if m.Gremium == "" || len(daten) == 0 {
+		return nil
+	}
+	m.Von = daten[0]
+	if len(daten) > 1 {
+		m.Bis = daten[1]
+	}
+	return m
+}
+
+func (p *Person) SaveOrUpdate() error {
+
+	ks, err := p.app.Db().GetAll(p.app.Ctx(), p.GetMitgliedschaftenQuery().KeysOnly(), nil)
+	if err != nil {
+		return errors.Wrap(err, "error getting mitgliedschaften from db")
+	}
+
+	tx, err := p.app.Db().NewTransaction(p.app.Ctx())
+	if err != nil {
+		return errors.Wrap(err, "client.NewTransaction")
+	}
+
+	newKeys := make(map[string]bool)
+	var keys []*datastore.Key
+	var mitgliedschaften []*Mitgliedschaft
+	for _, m := range p.Mitgliedschaften {
+		k := m.GetKey(p.app, p.GetKey())
+		if !newKeys[k.Encode()] {
+			newKeys[k.Encode()] = true
+			keys = append(keys, k)
+			mitgliedschaften = append(mitgliedschaften, m)
+		}
+	}
+
+	var toDelete []*datastore.Key
+	for _, k := range ks {
+		if !newKeys[k.Encode()] {
+			toDelete = append(toDelete, k)
+		}
+	}
+
+	err = tx.DeleteMulti(toDelete)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("error deleting old mitgliedschaften of person %d", p.KPLFDNR))
+	}
+
+	_, err = tx.PutMulti(keys, mitgliedschaften)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("error saving mitgliedschaften of person %d", p.KPLFDNR))
+	}
+
+	_, err = tx.Put(p.GetKey(), p)
+	if err != nil {
+		_ = tx.Rollback()
+		return errors.Wrap(err, fmt.Sprintf("error saving to db person from %s", p.file.GetName()))
+	}
+
+	_, err = tx.Commit()
+	return err
+}
+
+func (p *Person) Delete() error {
+
+	ks, err := p.app.Db().GetAll(p.app.Ctx(), p.GetMitgliedschaftenQuery().KeysOnly(), nil)
+	if err != nil {
+		return errors.Wrap(err, "error getting mitgliedschaften from db")
+	}
+
+	tx, err := p.app.Db().NewTransaction(p.app.Ctx())
+	if err != nil {
+		return errors.Wrap(err, "client.NewTransaction")
+	}
+
+	err = tx.DeleteMulti(ks)
+	if err != nil {
+		slog.Error("error delete mitgliedschaften of person in db for %s: %v", p.file.GetName(), err)
+	}
+
+	err = tx.Delete(p.GetKey())
+	if err != nil {
+		slog.Error("error delete person in db for %s: %v", p.file.GetName(), err)
+	}
+
+	_, err = tx.Commit()
+	return err
+}
+
+func readDom(app *application.AppContext, file *files.File) (*goquery.Document, error) {
+
+	err := file.ReadDocument(app.Config.GetBucketFetched())
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading file %s", file.GetName()))
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(file.GetContent()))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error create dom from %s", file.GetName()))
+	}
+	return doc, nil
+}
+
+// GetMitgliedschaftenOfGremium list the Mitgliedschaften in a Gremium valid at the given time
+func GetMitgliedschaftenOfGremium(app *application.AppContext, gra int, at time.Time) ([]*Mitgliedschaft, error) {
+
+	var all []*Mitgliedschaft
+	_, err := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityMitgliedschaft()).Filter("GRA =", gra), &all)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting mitgliedschaften of gremium %d", gra))
+	}
+
+	var result []*Mitgliedschaft
+	for _, m := range all {
+		if m.IsAktiv(at) {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
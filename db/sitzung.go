@@ -16,16 +16,19 @@ import (
 )
 
 type Sitzung struct {
-	SILFDNR int
-	Datum   time.Time
-	Gremium string
-	Status  string
+	SILFDNR   int
+	Datum     time.Time
+	Gremium   string
+	GremiumID int
+	Status    string
 
 	Title   string
 	Uhrzeit string
 	Raum    string
 	Ort     string
 
+	HatAnwesenheit bool
+
 	Tops    []*Top    `datastore:"-"`
 	Anlagen []*Anlage `datastore:"-"`
 
@@ -117,6 +120,13 @@ func (s *Sitzung) parseElement(dom *goquery.Selection) error {
 	}
 	s.Datum = datum
 
+	s.HatAnwesenheit = false
+	dom.Find("a").EachWithBreak(func(i int, selection *goquery.Selection) bool {
+		lnk, _ := selection.Attr("href")
+		s.HatAnwesenheit = domtools.RegexAnwesenheitLink.MatchString(lnk)
+		return !s.HatAnwesenheit
+	})
+
 	topRows := dom.Find("table.tl1").Find("tr.zl12, tr.zl11")
 	topRows.Each(func(i int, selection *goquery.Selection) {
 		top := s.parseTop(selection)
@@ -189,6 +199,7 @@ func (s *Sitzung) UpdateTop(oldTop *Top, newTop *Top) *Top {
 
 	oldTop.Datum = newTop.Datum
 	oldTop.Gremium = newTop.Gremium
+	oldTop.GremiumID = newTop.GremiumID
 
 	return oldTop
 }
@@ -217,7 +228,10 @@ func (s *Sitzung) SaveOrUpdate() error {
 		return errors.Wrap(err, fmt.Sprintf("error saving to db sitzung from %s", s.file.GetName()))
 	}
 	_, err = tx.Commit()
-	return err
+	if err != nil {
+		return err
+	}
+	return updateAnwesenheit(s.app, s)
 }
 
 func (s *Sitzung) Delete() error {
@@ -253,6 +267,13 @@ func (s *Sitzung) Delete() error {
 	}
 
 	_, err = tx.Commit()
+	if err != nil {
+		return err
+	}
 
-	return err
+	for _, k := range append(ks, tks...) {
+		fireChange(s.app, ActionDelete, k, nil)
+	}
+	fireChange(s.app, ActionDelete, s.GetKey(), nil)
+	return nil
 }
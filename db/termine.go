@@ -18,11 +18,12 @@ import (
 )
 
 type Termin struct {
-	Gremium string
-	SILFDNR int
-	Start   time.Time
-	End     time.Time
-	file    files.File
+	Gremium   string
+	GremiumID int
+	SILFDNR   int
+	Start     time.Time
+	End       time.Time
+	file      files.File
 
 	SavedAt time.Time
 }
@@ -49,6 +50,14 @@ func UpdateTermine(app *application.AppContext, minDate time.Time) error {
 		return errors.New("empty termine")
 	}
 
+	resolver, err := GetGremiumResolver(app)
+	if err != nil {
+		slog.Warn("gremien of termine not resolved: %v", err)
+	}
+	for i := range termine {
+		termine[i].GremiumID = resolver.Resolve(termine[i].Gremium)
+	}
+
 	var tmap = make(map[string]bool)
 	var terminKeys []*datastore.Key
 	for _, termin := range termine {
@@ -0,0 +1,140 @@
+package db
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/kennygrant/sanitize"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/common/taxonomy"
+	"reflect"
+	"sort"
+)
+
+// classify returns the topics of the texts (html) with the taxonomy Config.GetTaxonomy
+func classify(app *application.AppContext, html ...string) []string {
+
+	t, err := taxonomy.ForFile(app.Config.GetTaxonomy())
+	if err != nil {
+		slog.Warn("no topics classified: %v", err)
+	}
+
+	var texts []string
+	for _, h := range html {
+		texts = append(texts, sanitize.HTML(h))
+	}
+	return t.Classify(texts...)
+}
+
+// mergeThemen returns the sorted topics of both lists without duplicates
+func mergeThemen(a []string, b []string) []string {
+	var result []string
+	seen := make(map[string]bool)
+	for _, t := range append(append([]string{}, a...), b...) {
+		if !seen[t] {
+			seen[t] = true
+			result = append(result, t)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// addAnlagenThemen add the topics of the direct Anlagen (classified when they are indexed) to a Vorlage or Top
+func addAnlagenThemen(app *application.AppContext, s TopHolder) {
+
+	var themen *[]string
+	switch e := s.(type) {
+	case *Vorlage:
+		themen = &e.Themen
+	case *Top:
+		themen = &e.Themen
+	default:
+		return
+	}
+
+	var anlagen []*Anlage
+	_, err := app.Db().GetAll(app.Ctx(), s.GetDirectAnlagenQuery(), &anlagen)
+	if err != nil {
+		slog.Warn("topics of the anlagen of %s not added: %v", s.GetKey().String(), err)
+		return
+	}
+	for _, a := range anlagen {
+		*themen = mergeThemen(*themen, a.Themen)
+	}
+}
+
+// SetAnlageThemen store the topics of an Anlage and add them to the Themen of the parent Vorlage or Top,
+// topics no longer found in the Anlage are removed from the parent by its next Sync
+func SetAnlageThemen(app *application.AppContext, key *datastore.Key, themen []string) error {
+
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+
+		var anlage Anlage
+		err := tx.Get(key, &anlage)
+		if err != nil {
+			return err
+		}
+		themen = mergeThemen(themen, nil)
+		if reflect.DeepEqual(mergeThemen(anlage.Themen, nil), themen) {
+			return nil
+		}
+		anlage.Themen = themen
+		_, err = tx.Put(key, &anlage)
+		if err != nil {
+			return err
+		}
+
+		parentKey := key.Parent
+		if parentKey == nil || len(themen) == 0 {
+			return nil
+		}
+		var parent interface{}
+		var parentThemen *[]string
+		switch parentKey.Kind {
+		case app.Config.GetEntityVorlage():
+			v := &Vorlage{}
+			parent, parentThemen = v, &v.Themen
+		case app.Config.GetEntityTop():
+			t := &Top{}
+			parent, parentThemen = t, &t.Themen
+		default:
+			return nil
+		}
+		err = tx.Get(parentKey, parent)
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		merged := mergeThemen(*parentThemen, themen)
+		if len(merged) == len(*parentThemen) {
+			return nil
+		}
+		*parentThemen = merged
+		_, err = tx.Put(parentKey, parent)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error saving topics of anlage %s", key.String()))
+	}
+	return nil
+}
+
+// GetThemen returns the topics of a Vorlage or Top, nil for other entities
+func GetThemen(entity interface{}) []string {
+	switch e := entity.(type) {
+	case *Vorlage:
+		return e.Themen
+	case *Top:
+		return e.Themen
+	}
+	return nil
+}
+
+// GetVorlagenZumThema list the Vorlagen classified with the topic
+func GetVorlagenZumThema(app *application.AppContext, thema string) ([]*Vorlage, error) {
+	return getVorlagen(app, datastore.NewQuery(app.Config.GetEntityVorlage()).Filter("Themen =", thema))
+}
@@ -29,6 +29,7 @@ type Top struct {
 	Nr            string
 	Beschlussart  string
 	Gremium       string
+	GremiumID     int
 	Federfuehrend string
 	Bearbeiter    string
 	Datum         time.Time
@@ -36,6 +37,7 @@ type Top struct {
 	AbstimmungZustimmung int
 	AbstimmungAblehnung  int
 	AbstimmungEnthaltung int
+	Abstimmung           Abstimmung
 
 	IndexTop        int
 	Typ             string
@@ -44,6 +46,9 @@ type Top struct {
 	BSVV            string
 	Beschlussstatus string
 
+	//topics of Betreff, Beschluss and Protokoll (see themen.go)
+	Themen []string
+
 	file    *files.File
 	app     *application.AppContext
 	Anlagen []*Anlage `datastore:"-"`
@@ -168,26 +173,20 @@ func (t *Top) parseElement(dom *goquery.Selection) error {
 	return nil
 }
 
+// Derive compute the topics of the parsed texts
+func (t *Top) Derive(now time.Time) {
+	t.Themen = classify(t.app, t.Betreff, t.Beschluss, t.Protokoll)
+}
+
 func (t *Top) parseAbstimmungsErgebnis(sel *goquery.Selection) {
-	bez, cont := domtools.ParseTable(sel.Find("table tr td:first-child"))
-
-	if bez == nil && cont == nil {
-		sel.Find("p").Each(func(i int, selection *goquery.Selection) {
-			descr := selection.Find("span").First().Text()
-			if descr == "Zustimmung:" {
-				t.AbstimmungZustimmung = domtools.StringToIntOrNeg(domtools.CleanText(selection.Find("span").Last().Text()))
-			} else if descr == "Ablehnung:" {
-				t.AbstimmungAblehnung = domtools.StringToIntOrNeg(domtools.CleanText(selection.Find("span").Last().Text()))
-			} else if descr == "Enthaltung:" {
-				t.AbstimmungEnthaltung = domtools.StringToIntOrNeg(domtools.CleanText(selection.Find("span").Last().Text()))
-			}
-		})
-	} else {
 
-		t.AbstimmungZustimmung = domtools.StringToIntOrNeg(domtools.FindIndex(bez, cont, "Zustimmung:"))
-		t.AbstimmungAblehnung = domtools.StringToIntOrNeg(domtools.FindIndex(bez, cont, "Ablehnung:"))
-		t.AbstimmungEnthaltung = domtools.StringToIntOrNeg(domtools.FindIndex(bez, cont, "Enthaltung:"))
-	}
+	t.Abstimmung = NewAbstimmung()
+	t.Abstimmung.ParseErgebnisblock(sel)
+	t.Abstimmung.ParseProtokoll(t.Beschluss, t.Protokoll, t.ProtokollRe)
+
+	t.AbstimmungZustimmung = orZero(t.Abstimmung.Zustimmung)
+	t.AbstimmungAblehnung = orZero(t.Abstimmung.Ablehnung)
+	t.AbstimmungEnthaltung = orZero(t.Abstimmung.Enthaltung)
 }
 
 func (t *Top) UpdateAnlage(oldAnlage *Anlage, newAnlage *Anlage) *Anlage {
@@ -242,15 +241,23 @@ func (t *Top) Delete() error {
 
 	err = tx.DeleteMulti(ks)
 	if err != nil {
-		slog.Error("error delete Anlagen of sitzung in db for %t: %v", t.file.GetName(), err)
+		slog.Error("error delete Anlagen of top in db for %s: %v", t.file.GetName(), err)
 	}
 
 	err = tx.Delete(t.GetKey())
 	if err != nil {
-		slog.Error("error delete sitzung in db for %t: %v", t.file.GetName(), err)
+		slog.Error("error delete top in db for %s: %v", t.file.GetName(), err)
 	}
 
 	_, err = tx.Commit()
+	if err != nil {
+		return err
+	}
 
-	return err
+	for _, k := range ks {
+		fireChange(t.app, ActionDelete, k, nil)
+	}
+	//the deleted entity is passed for its topics (webhooks)
+	fireChange(t.app, ActionDelete, t.GetKey(), t)
+	return nil
 }
@@ -24,6 +24,13 @@ type TopHolder interface {
 	GetTopQuery() *datastore.Query
 	GetDirectAnlagenQuery() *datastore.Query
 	SaveOrUpdate() error
+	Delete() error
+}
+
+// Deriver is a TopHolder with fields derived from the parsed ones (e.g. the lifecycle of a Vorlage at now), Parse only
+// reads the page and Sync derives them before saving
+type Deriver interface {
+	Derive(now time.Time)
 }
 
 type HasKey interface {
@@ -49,7 +56,12 @@ func Sync(app *application.AppContext, s TopHolder) error {
 		return errors.Wrap(err, fmt.Sprintf("error parsing sitzung from %s", file.GetName()))
 	}
 
-	s.SetSavedAt(time.Now())
+	now := time.Now()
+	if d, ok := s.(Deriver); ok {
+		d.Derive(now)
+	}
+	s.SetSavedAt(now)
+	resolveGremien(app, s)
 
 	///
 	if s.GetTopQuery() != nil {
@@ -65,7 +77,17 @@ func Sync(app *application.AppContext, s TopHolder) error {
 		return errors.Wrap(err, fmt.Sprintf("error saving Anlagen from %s", file.GetName()))
 	}
 
-	return s.SaveOrUpdate()
+	addAnlagenThemen(app, s)
+	changed := entityChanged(app, s.GetKey(), s)
+	err = s.SaveOrUpdate()
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		fireChange(app, ActionUpdate, s.GetKey(), s)
+	}
+	return nil
 }
 
 func saveTops(app *application.AppContext, s TopHolder, err error) error {
@@ -146,6 +168,8 @@ func saveAnlagen(app *application.AppContext, s TopHolder, err error) error {
 		return errors.Wrap(err, "error getting beratungen from db")
 	}
 
+	var deletedKeys []*datastore.Key
+	var changed []*Anlage
 	for i, oldTop := range oldTops {
 		oldTop.Config = app.Config
 		oldkey := ks[i]
@@ -155,12 +179,19 @@ func saveAnlagen(app *application.AppContext, s TopHolder, err error) error {
 			err = tx.Delete(oldkey)
 			if err != nil {
 				slog.Error("delete old top %s: %v", newTop.GetKey(s.GetKey()).String(), err)
+			} else {
+				deletedKeys = append(deletedKeys, oldkey)
 			}
 		} else {
-			_, err = tx.Put(newTop.GetKey(s.GetKey()), s.UpdateAnlage(oldTop, newTop))
+			titleChanged := oldTop.Title != newTop.Title
+			updated := s.UpdateAnlage(oldTop, newTop)
+			_, err = tx.Put(newTop.GetKey(s.GetKey()), updated)
 			if err != nil {
 				return errors.Wrap(err, fmt.Sprintf("put new top %s", newTop.GetKey(s.GetKey()).String()))
 			}
+			if titleChanged {
+				changed = append(changed, updated)
+			}
 			delete(newTopsMap, kstr)
 		}
 	}
@@ -170,6 +201,7 @@ func saveAnlagen(app *application.AppContext, s TopHolder, err error) error {
 		if err != nil {
 			return errors.Wrap(err, fmt.Sprintf("put new top %s", newTop.GetKey(s.GetKey()).String()))
 		}
+		changed = append(changed, newTop)
 	}
 
 	_, err = tx.Commit()
@@ -177,5 +209,12 @@ func saveAnlagen(app *application.AppContext, s TopHolder, err error) error {
 		return errors.Wrap(err, fmt.Sprintf("error commiting to db sitzung from %s", s.GetKey()))
 	}
 
+	for _, k := range deletedKeys {
+		fireChange(app, ActionDelete, k, nil)
+	}
+	for _, a := range changed {
+		fireChange(app, ActionUpdate, a.GetKey(s.GetKey()), a)
+	}
+
 	return nil
 }
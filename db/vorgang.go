@@ -0,0 +1,184 @@
+package db
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/kennygrant/sanitize"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/slog"
+	"regexp"
+	"sort"
+)
+
+// maxVorgangSize limits the walk through the reference graph of a Vorgang
+const maxVorgangSize = 250
+
+// findErwaehnteBSVV returns the BSVV numbers mentioned in the given html, without the own BSVV
+func (v *Vorlage) findErwaehnteBSVV(html ...string) []string {
+
+	pattern := v.app.Config.GetBsvvPattern()
+	if pattern == "" {
+		return nil
+	}
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		slog.Error("invalid bsvv pattern %s: %v", pattern, err)
+		return nil
+	}
+
+	found := make(map[string]bool)
+	var result []string
+	for _, h := range html {
+		for _, bsvv := range regex.FindAllString(sanitize.HTML(h), -1) {
+			bsvv = domtools.CleanText(bsvv)
+			if bsvv != v.BSVV && !found[bsvv] {
+				found[bsvv] = true
+				result = append(result, bsvv)
+			}
+		}
+	}
+	return result
+}
+
+// GetVorlage load a Vorlage from the datastore
+func GetVorlage(app *application.AppContext, volfdnr int) (*Vorlage, error) {
+
+	v := &Vorlage{VOLFDNR: volfdnr, app: app}
+	err := app.Db().Get(app.Ctx(), v.GetKey(), v)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting vorlage %d from db", volfdnr))
+	}
+	v.app = app
+	return v, nil
+}
+
+// GetVorlageByBSVV load a Vorlage by its BSVV, nil if not found
+func GetVorlageByBSVV(app *application.AppContext, bsvv string) (*Vorlage, error) {
+
+	vorlagen, err := getVorlagen(app, datastore.NewQuery(app.Config.GetEntityVorlage()).Filter("BSVV =", bsvv).Limit(1))
+	if err != nil || len(vorlagen) == 0 {
+		return nil, err
+	}
+	return vorlagen[0], nil
+}
+
+func getVorlagen(app *application.AppContext, query *datastore.Query) ([]*Vorlage, error) {
+
+	var vorlagen []*Vorlage
+	_, err := app.Db().GetAll(app.Ctx(), query, &vorlagen)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting vorlagen from db")
+	}
+	for _, v := range vorlagen {
+		v.app = app
+	}
+	return vorlagen, nil
+}
+
+// LoadReferences fill Bezueglich and ReferenziertVon (by BezueglichVOLFDNR and BSVV mentioned in the Begruendung)
+func (v *Vorlage) LoadReferences() error {
+
+	var err error
+	if v.BezueglichVOLFDNR > 0 {
+		v.Bezueglich, err = GetVorlage(v.app, v.BezueglichVOLFDNR)
+		if err != nil && errors.Cause(err) != datastore.ErrNoSuchEntity {
+			return err
+		}
+	} else if v.BezueglichBSVV != "" {
+		v.Bezueglich, err = GetVorlageByBSVV(v.app, v.BezueglichBSVV)
+		if err != nil {
+			return err
+		}
+	}
+
+	bezueglich, err := getVorlagen(v.app, datastore.NewQuery(v.app.Config.GetEntityVorlage()).Filter("BezueglichVOLFDNR =", v.VOLFDNR))
+	if err != nil {
+		return err
+	}
+
+	var erwaehnt []*Vorlage
+	if v.BSVV != "" {
+		erwaehnt, err = getVorlagen(v.app, datastore.NewQuery(v.app.Config.GetEntityVorlage()).Filter("ErwaehnteBSVV =", v.BSVV))
+		if err != nil {
+			return err
+		}
+	}
+
+	found := make(map[int]bool)
+	v.ReferenziertVon = nil
+	for _, r := range append(bezueglich, erwaehnt...) {
+		if !found[r.VOLFDNR] && r.VOLFDNR != v.VOLFDNR {
+			found[r.VOLFDNR] = true
+			v.ReferenziertVon = append(v.ReferenziertVon, r)
+		}
+	}
+	return nil
+}
+
+// references returns all Vorlagen connected to v in any direction
+func (v *Vorlage) references() ([]*Vorlage, error) {
+
+	err := v.LoadReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	result := append([]*Vorlage{}, v.ReferenziertVon...)
+	if v.Bezueglich != nil {
+		result = append(result, v.Bezueglich)
+	}
+	for _, bsvv := range v.ErwaehnteBSVV {
+		erwaehnt, err := GetVorlageByBSVV(v.app, bsvv)
+		if err != nil {
+			return nil, err
+		}
+		if erwaehnt != nil {
+			result = append(result, erwaehnt)
+		}
+	}
+	return result, nil
+}
+
+// GetVorgang returns all Vorlagen of the Vorgang of a Vorlage (the connected reference graph) ordered by creation
+func GetVorgang(app *application.AppContext, volfdnr int) ([]*Vorlage, error) {
+
+	start, err := GetVorlage(app, volfdnr)
+	if err != nil {
+		return nil, err
+	}
+
+	visited := map[int]*Vorlage{start.VOLFDNR: start}
+	queue := []*Vorlage{start}
+	for len(queue) > 0 && len(visited) < maxVorgangSize {
+		current := queue[0]
+		queue = queue[1:]
+
+		refs, err := current.references()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range refs {
+			if _, exist := visited[r.VOLFDNR]; !exist {
+				visited[r.VOLFDNR] = r
+				queue = append(queue, r)
+			}
+		}
+	}
+	if len(visited) >= maxVorgangSize {
+		slog.Warn("vorgang of vorlage %d truncated at %d vorlagen", volfdnr, maxVorgangSize)
+	}
+
+	var vorgang []*Vorlage
+	for _, v := range visited {
+		vorgang = append(vorgang, v)
+	}
+	sort.SliceStable(vorgang, func(i, j int) bool {
+		if !vorgang[i].DatumAngelegt.Equal(vorgang[j].DatumAngelegt) {
+			return vorgang[i].DatumAngelegt.Before(vorgang[j].DatumAngelegt)
+		}
+		return vorgang[i].VOLFDNR < vorgang[j].VOLFDNR
+	})
+	return vorgang, nil
+}
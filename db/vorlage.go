@@ -4,10 +4,13 @@ import (
 	"cloud.google.com/go/datastore"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/kennygrant/sanitize"
 	"github.com/pkg/errors"
 	"github.com/rismaster/allris-common/application"
 	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/extract"
 	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/geo"
 	"github.com/rismaster/allris-common/common/slog"
 	"net/url"
 	"sort"
@@ -29,6 +32,7 @@ type Vorlage struct {
 	DatumAngelegt         time.Time
 	BezueglichVOLFDNR     int
 	BezueglichBSVV        string
+	ErwaehnteBSVV         []string
 	Bezueglich            *Vorlage `datastore:"-"`
 
 	Beratungsfolge  []*Top     `datastore:"-"`
@@ -40,15 +44,47 @@ type Vorlage struct {
 	file *files.File
 	app  *application.AppContext
 
-	//transient
-	LeterBeratungsStatus        string    `datastore:"-"`
-	LeterBeratungsTyp           string    `datastore:"-"`
-	LetesBeratungsGremium       string    `datastore:"-"`
-	LetzteBeratungsSitzung      int       `datastore:"-"`
-	LetzterBeratungsTop         int       `datastore:"-"`
-	LetzterBeratungBeschlussart string    `datastore:"-"`
-	LetzteBeratungDatum         time.Time `datastore:"-"`
-	BeschlussVorlageShort       string    `datastore:"-"`
+	//computed from Beratungsfolge (see lebenszyklus.go)
+	Lebenszyklus                string
+	LebenszyklusSeit            time.Time
+	OffenInGremien              []string
+	LeterBeratungsStatus        string
+	LeterBeratungsTyp           string
+	LetesBeratungsGremium       string
+	LetzteBeratungsSitzung      int
+	LetzterBeratungsTop         int
+	LetzterBeratungBeschlussart string
+	LetzteBeratungDatum         time.Time
+	BeschlussGremium            string    //Gremium of the deciding Beratung of a beschlossen Vorlage
+	BeschlussDatum              time.Time //Datum of the deciding Beratung
+	BeschlussVorlageShort       string    `datastore:",noindex"`
+
+	//named entities of Betreff, Beschlussvorlage, Begründung and Finanzielle Auswirkung (see extractNamedEntities)
+	Strassen    []string
+	Adressen    []string
+	Gemarkungen []string
+	Flurstuecke []string
+	BPlaene     []string
+	Betraege    []float64 `datastore:",noindex"`
+
+	//budget data of FinanzielleAuswirkung (see finanzen.go)
+	FinanzenKeine     bool
+	FinanzenEinmalig  float64
+	FinanzenJaehrlich float64
+	Haushaltsjahre    []int
+	Produkte          []string
+	Kostenstellen     []string
+
+	//topics of Betreff, Beschlussvorlage and Begründung (see themen.go)
+	Themen []string
+
+	//geocoded Adressen and Strassen (see geocode)
+	Orte    []geo.Ort `datastore:",noindex"`
+	Gebiete []string
+
+	//geocoded Adressen and Strassen of the Anlagen (see SetAnlageOrte)
+	AnlagenOrte    []geo.Ort `datastore:",noindex"`
+	AnlagenGebiete []string
 }
 
 func NewVorlage(app *application.AppContext, file *files.File) (*Vorlage, error) {
@@ -138,6 +174,7 @@ func (v *Vorlage) parseElement(dom *goquery.Selection) error {
 	fahtml, _ := dom.Find("a[name=\"allrisFA\"]").
 		NextFilteredUntil("div", "a").Html()
 	v.FinanzielleAuswirkung = domtools.SanatizeHtml(fahtml, v.app.Config)
+	v.ErwaehnteBSVV = v.findErwaehnteBSVV(v.Begruendung, v.BeschlussVorlage)
 
 	//theTopTable := dom.Find(".me1 > table.tk1").First()
 
@@ -235,6 +272,72 @@ func (v *Vorlage) parseElement(dom *goquery.Selection) error {
 	return nil
 }
 
+// Derive compute the fields derived from the parsed ones: the creation date of the fetched file, the lifecycle at now,
+// named entities with their locations, the budget data and the topics
+func (v *Vorlage) Derive(now time.Time) {
+
+	if v.DatumAngelegt.IsZero() && v.file != nil {
+		errInfo := v.file.ReadDocumentInfo(v.app.Config.GetBucketFetched())
+		if errInfo != nil {
+			slog.Warn("no creation date for vorlage %d: %v", v.VOLFDNR, errInfo)
+		}
+		v.DatumAngelegt = v.file.GetRisTime()
+	}
+
+	v.computeLebenszyklus(now)
+	v.extractNamedEntities()
+	v.parseFinanzen()
+	v.Themen = classify(v.app, v.Betreff, v.BeschlussVorlage, v.Begruendung)
+}
+
+// extractNamedEntities find streets, Flurstücke, B-Pläne and amounts with the gazetteer Config.GetExtractGazetteer
+func (v *Vorlage) extractNamedEntities() {
+
+	extractor, err := extract.ForGazetteer(v.app.Config.GetExtractGazetteer())
+	if err != nil {
+		slog.Warn("streets not checked against gazetteer: %v", err)
+	}
+
+	r := extractor.Extract(v.Betreff)
+	for _, html := range []string{v.BeschlussVorlage, v.Begruendung, v.FinanzielleAuswirkung} {
+		r.Merge(extractor.Extract(sanitize.HTML(html)))
+	}
+
+	v.Strassen = r.Strassen
+	v.Adressen = r.Adressen
+	v.Gemarkungen = r.Gemarkungen
+	v.Flurstuecke = r.Flurstuecke
+	v.BPlaene = r.BPlaene
+	v.Betraege = r.Betraege
+
+	v.geocode(r)
+}
+
+// geocode the named entities with the local address list Config.GetGeoAdressen
+func (v *Vorlage) geocode(r *extract.Result) {
+
+	geocoder, err := geo.ForAdressen(v.app.Config.GetGeoAdressen())
+	if err != nil {
+		slog.Warn("vorlagen not geocoded: %v", err)
+	}
+
+	v.Orte = geocoder.GeocodeEntities(r)
+	v.Gebiete = gebieteDerOrte(v.Orte)
+}
+
+// gebieteDerOrte returns the areas of the locations without duplicates
+func gebieteDerOrte(orte []geo.Ort) []string {
+	var gebiete []string
+	seen := make(map[string]bool)
+	for _, o := range orte {
+		if o.Gebiet != "" && !seen[o.Gebiet] {
+			seen[o.Gebiet] = true
+			gebiete = append(gebiete, o.Gebiet)
+		}
+	}
+	return gebiete
+}
+
 func (v *Vorlage) createBeratung(beratung *Top) *Top {
 	beratungTyp := ""
 	beratungGremium := ""
@@ -265,6 +368,9 @@ func (v *Vorlage) UpdateTop(oldTop *Top, newTop *Top) *Top {
 	if strings.TrimSpace(newTop.Typ) != "" {
 		oldTop.Typ = newTop.Typ
 	}
+	if newTop.GremiumID > 0 {
+		oldTop.GremiumID = newTop.GremiumID
+	}
 	oldTop.Beschlussstatus = newTop.Beschlussstatus
 	oldTop.IndexBeratung = newTop.IndexBeratung
 	oldTop.SavedAt = time.Now()
@@ -290,6 +396,8 @@ func (v *Vorlage) SaveOrUpdate() error {
 	} else if err == nil {
 		//update
 		//v.VOLFDNR = oldVorlage.VOLFDNR
+		v.AnlagenOrte = oldVorlage.AnlagenOrte
+		v.AnlagenGebiete = oldVorlage.AnlagenGebiete
 	}
 
 	_, err = tx.Put(v.GetKey(), v)
@@ -338,6 +446,14 @@ func (v *Vorlage) Delete() error {
 	}
 
 	_, err = tx.Commit()
+	if err != nil {
+		return err
+	}
 
-	return err
+	for _, k := range ks {
+		fireChange(v.app, ActionDelete, k, nil)
+	}
+	//the deleted entity is passed for its topics (webhooks)
+	fireChange(v.app, ActionDelete, v.GetKey(), v)
+	return nil
 }
@@ -40,5 +40,5 @@ func (a *Anlage) Download() error {
 	}
 
 	mewHash := common.Md5HashB(a.file.GetContent())
-	return a.file.WriteIfMoreActualAndDifferent(mewHash)
+	return a.file.WriteContentAddressed(mewHash)
 }
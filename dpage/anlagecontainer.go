@@ -6,7 +6,6 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
 	"github.com/rismaster/allris-common/application"
-	"github.com/rismaster/allris-common/common"
 	"github.com/rismaster/allris-common/common/domtools"
 	"github.com/rismaster/allris-common/common/files"
 	"github.com/rismaster/allris-common/common/slog"
@@ -67,9 +66,9 @@ func (a *AnlageContainer) GetUrl() string {
 
 func (a *AnlageContainer) downloadWithAnlageRefetch(force bool) error {
 
-	dom, fresh, err := a.downloadAndSave(force)
+	dom, fresh, err := a.download(force)
 	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("error downloading: %s %+v", a.GetPath()))
+		return errors.Wrap(err, fmt.Sprintf("error downloading: %s", a.GetPath()))
 	}
 
 	existingAnlagen := make(map[string]bool)
@@ -96,12 +95,24 @@ func (a *AnlageContainer) downloadWithAnlageRefetch(force bool) error {
 
 	var tops []*AnlageContainer
 	existingTops := make(map[string]bool)
+	existingAnwesenheit := make(map[string]bool)
 	if a.GetFolder() == a.app.Config.GetSitzungenFolder() {
 		tops = a.extractTops(dom)
 		for _, top := range tops {
 			existingTops[top.GetPath()] = true
 			risToDownload = append(risToDownload, *top.webRessource)
 		}
+
+		anwesenheit := a.extractAnwesenheit(dom)
+		if anwesenheit != nil {
+			existingAnwesenheit[NewHtmlPage(a.app, anwesenheit).GetPath()] = true
+			risToDownload = append(risToDownload, *anwesenheit)
+		}
+	}
+
+	err = a.save(dom, risToDownload)
+	if err != nil {
+		return err
 	}
 
 	if !force && !fresh {
@@ -116,7 +127,12 @@ func (a *AnlageContainer) downloadWithAnlageRefetch(force bool) error {
 			return err
 		}
 
-		filesInS := append(anlageFilesInS, topFilesInS...)
+		anwesenheitFilesInS, err := files.ListFiles(a.app, a.app.Config.GetAnwesenheitFolder()+a.GetName())
+		if err != nil {
+			return err
+		}
+
+		filesInS := append(append(anlageFilesInS, topFilesInS...), anwesenheitFilesInS...)
 
 		for _, rd := range risToDownload {
 			risFileInWeb := files.NewFile(a.app, &rd)
@@ -147,6 +163,11 @@ func (a *AnlageContainer) downloadWithAnlageRefetch(force bool) error {
 		if err != nil {
 			return errors.Wrap(err, fmt.Sprintf("error deleting %s", a.app.Config.GetTopFolder()+a.GetName()))
 		}
+
+		err = files.DeleteFilesIfNotInAndAfter(a.app, a.app.Config.GetAnwesenheitFolder()+a.GetName()+"-", existingAnwesenheit, []string{}, time.Time{})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error deleting %s", a.app.Config.GetAnwesenheitFolder()+a.GetName()))
+		}
 	}
 
 	return PublishRisDownload(a.app, risToDownload)
@@ -156,7 +177,7 @@ func (a *AnlageContainer) Download() error {
 	return a.downloadWithAnlageRefetch(false)
 }
 
-func (a *AnlageContainer) downloadAndSave(force bool) (*goquery.Document, bool, error) {
+func (a *AnlageContainer) download(force bool) (*goquery.Document, bool, error) {
 
 	fresh, err := a.file.Fetch(files.HttpGet, a.webRessource, "text/html", force)
 	if err != nil {
@@ -168,22 +189,22 @@ func (a *AnlageContainer) downloadAndSave(force bool) (*goquery.Document, bool,
 		return nil, false, errors.Wrap(err, fmt.Sprintf("error create dom from %s, Error: %+v", a.GetUrl(), err))
 	}
 
-	docCopy := doc.Clone()
-
-	aLink := doc.Find("a")
-	aLinkText := aLink.Text()
-
-	docCopy.Find("a").ReplaceWith(aLinkText)
+	return doc, fresh, nil
+}
 
-	docCleaned, _ := docCopy.Html()
-	hash := common.Md5HashStr(docCleaned)
+// save write the page if the parsed fields or the linked Anlagen, Tops or Anwesenheit changed
+func (a *AnlageContainer) save(doc *goquery.Document, ressourcen []downloader.RisRessource) error {
 
-	err = a.file.WriteIfMoreActualAndDifferent(hash)
+	hash, err := pageFingerprint(a.app, a.file, doc, ressourcen)
 	if err != nil {
-		return nil, false, errors.Wrap(err, fmt.Sprintf("error writing to storage: %s, Error: %+v", a.GetUrl(), err))
+		return errors.Wrap(err, fmt.Sprintf("error creating fingerprint of %s", a.GetPath()))
 	}
 
-	return doc, fresh, nil
+	err = writePage(a.app, a.file, doc, hash)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error writing to storage: %s, Error: %+v", a.GetUrl(), err))
+	}
+	return nil
 }
 
 func (a *AnlageContainer) extractTops(dom *goquery.Document) (tops []*AnlageContainer) {
@@ -223,6 +244,29 @@ func (a *AnlageContainer) extractTops(dom *goquery.Document) (tops []*AnlageCont
 	return tops
 }
 
+// extractAnwesenheit find the link to the Anwesenheitsliste (to0045/to0050) of a Sitzung
+func (a *AnlageContainer) extractAnwesenheit(dom *goquery.Document) *downloader.RisRessource {
+
+	var anwesenheit *downloader.RisRessource
+	dom.Find("a").EachWithBreak(func(i int, selection *goquery.Selection) bool {
+		lnk, _ := selection.Attr("href")
+		if !domtools.RegexAnwesenheitLink.MatchString(lnk) {
+			return true
+		}
+
+		uri, err := url.Parse(a.app.Config.GetTargetToParse() + lnk)
+		if err != nil {
+			slog.Warn("anwesenheit link ignored %s: %v", lnk, err)
+			return true
+		}
+
+		name := fmt.Sprintf("%s-%s", a.webRessource.GetName(), a.app.Config.GetAnwesenheitType())
+		anwesenheit = downloader.NewRisRessource(a.app.Config.GetAnwesenheitFolder(), name, ".html", a.webRessource.GetCreated(), uri, &url.Values{}, a.webRessource.RedownloadChildren, a.webRessource.RedownloadChildren)
+		return false
+	})
+	return anwesenheit
+}
+
 func (a *AnlageContainer) extractAnlagen(dom *goquery.Selection) (docs []downloader.RisRessource) {
 
 	theAnlagenTables := dom.Find("table.tk1")
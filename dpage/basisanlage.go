@@ -40,5 +40,5 @@ func (d *AnlageDocument) Download() error {
 	}
 
 	mewHash := common.Md5HashB(d.file.GetContent())
-	return d.file.WriteIfMoreActualAndDifferent(mewHash)
+	return d.file.WriteContentAddressed(mewHash)
 }
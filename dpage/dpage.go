@@ -27,6 +27,8 @@ func Download(ctx context.Context, ris downloader.RisRessource, conf allris_comm
 		}
 	case conf.GetVorlagenFolder():
 		doc = NewVorlage(app, &ris)
+	case conf.GetPersonenFolder(), conf.GetFraktionenFolder(), conf.GetAnwesenheitFolder():
+		doc = NewHtmlPage(app, &ris)
 	}
 
 	if doc != nil {
@@ -0,0 +1,191 @@
+package dpage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/downloader"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fingerprintVersion is part of every fingerprint, change it if the hashed fields change
+const fingerprintVersion = "v3"
+
+// volatileFields are set while parsing (e.g. SavedAt) and do not change the content of a page
+var volatileFields = map[string]bool{
+	"SavedAt": true,
+}
+
+// PageParser parse a downloaded page into the entity saved in the db without saving it, nil for pages without entity
+type PageParser func(app *application.AppContext, file *files.File, doc *goquery.Document) (interface{}, error)
+
+var pageParserMutex sync.Mutex
+var pageParser PageParser
+
+// SetPageFingerprint set the parser of the pages hashed by pageFingerprint (registered by db, dpage must not import db)
+func SetPageFingerprint(parser PageParser) {
+	pageParserMutex.Lock()
+	defer pageParserMutex.Unlock()
+	pageParser = parser
+}
+
+func getPageParser() PageParser {
+	pageParserMutex.Lock()
+	defer pageParserMutex.Unlock()
+	return pageParser
+}
+
+// pageFingerprint returns the sha256 of the fields parsed from the page and the sorted paths of the linked ressources
+// (Anlagen, Tops, Anwesenheit), the html (session ids, layout) does not change it.
+// Without a registered parser the normalised dom is hashed
+func pageFingerprint(app *application.AppContext, file *files.File, doc *goquery.Document, ressourcen []downloader.RisRessource) (string, error) {
+
+	parser := getPageParser()
+	if parser == nil {
+		slog.Debug("no page parser registered, fingerprint of the dom of %s", file.GetPath())
+		return domtools.Fingerprint(doc), nil
+	}
+
+	entity, err := parser(app, file, doc)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error parsing %s for the fingerprint", file.GetPath()))
+	}
+
+	felder, err := canonicalJson(entity)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("error serializing %s for the fingerprint", file.GetPath()))
+	}
+
+	return common.Sha256HashB([]byte(fingerprintVersion + "\n" + felder + "\n" + strings.Join(ressourcePaths(app, ressourcen), "\n"))), nil
+}
+
+// listFingerprint returns the sha256 of the sorted entries of a list page (Vorlagenliste, Sitzungsliste, Personenliste,
+// Gremien), e.g. created by ressourceEintraege
+func listFingerprint(eintraege []string) string {
+
+	sorted := append([]string{}, eintraege...)
+	sort.Strings(sorted)
+	return common.Sha256HashB([]byte(fingerprintVersion + "\n" + strings.Join(sorted, "\n")))
+}
+
+// ressourceEintraege returns the path and the creation time in ris of the listed ressources
+func ressourceEintraege(app *application.AppContext, ressourcen []downloader.RisRessource) []string {
+
+	var eintraege []string
+	for _, r := range ressourcen {
+		eintraege = append(eintraege, files.NewFile(app, &r).GetPath()+"|"+r.GetCreated().UTC().Format(time.RFC3339))
+	}
+	return eintraege
+}
+
+func ressourcePaths(app *application.AppContext, ressourcen []downloader.RisRessource) []string {
+
+	var paths []string
+	for _, r := range ressourcen {
+		paths = append(paths, files.NewFile(app, &r).GetPath())
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// canonicalJson returns the json of the entity without the volatileFields, the keys of objects are sorted by encoding/json
+func canonicalJson(entity interface{}) (string, error) {
+
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return "", err
+	}
+	var v interface{}
+	err = json.Unmarshal(b, &v)
+	if err != nil {
+		return "", err
+	}
+	b, err = json.Marshal(withoutVolatile(v))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func withoutVolatile(v interface{}) interface{} {
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if volatileFields[k] {
+				delete(t, k)
+			} else {
+				t[k] = withoutVolatile(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = withoutVolatile(e)
+		}
+	}
+	return v
+}
+
+// migrateHash replace a stored hash of a former hash function by the fingerprint if the page is unchanged,
+// the change of the hash function must not backup and fire every page
+func migrateHash(app *application.AppContext, file *files.File, fingerprint string, formerHashes ...string) error {
+
+	err := file.ReadDocumentInfo(app.Config.GetBucketFetched())
+	if err != nil {
+		return err
+	}
+	stored := file.GetHash()
+	if stored == "" || stored == fingerprint {
+		return nil
+	}
+	for _, former := range formerHashes {
+		if stored == former {
+			return file.MigrateHash(app.Config.GetBucketFetched(), fingerprint)
+		}
+	}
+	return nil
+}
+
+// formerPageHashes returns the hashes of a page created by the former hash functions: the md5 of the html,
+// the md5 of the html with the links replaced by their texts and the fingerprint of the normalised dom
+func formerPageHashes(content []byte, doc *goquery.Document) []string {
+
+	return []string{
+		common.Md5HashB(content),
+		linkTextHash(doc),
+		domtools.Fingerprint(doc),
+	}
+}
+
+// linkTextHash is the former hash of the html with all links replaced by the link texts
+func linkTextHash(doc *goquery.Document) string {
+
+	docCopy := doc.Clone()
+
+	aLink := doc.Find("a")
+	aLinkText := aLink.Text()
+
+	docCopy.Find("a").ReplaceWith(aLinkText)
+
+	docCleaned, _ := docCopy.Html()
+	return common.Md5HashStr(docCleaned)
+}
+
+// writePage write a fetched page if its fingerprint changed, stored hashes of the former hash functions are migrated first
+func writePage(app *application.AppContext, file *files.File, doc *goquery.Document, fingerprint string) error {
+
+	err := migrateHash(app, file, fingerprint, formerPageHashes(file.GetContent(), doc)...)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error migrating hash of %s", file.GetPath()))
+	}
+	return file.WriteIfMoreActualAndDifferent(fingerprint)
+}
@@ -0,0 +1,54 @@
+package dpage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/downloader"
+)
+
+// HtmlPage is a ris page without Anlagen or Tops (e.g. Person, Fraktion)
+type HtmlPage struct {
+	app          *application.AppContext
+	webRessource *downloader.RisRessource
+	file         *files.File
+}
+
+func NewHtmlPage(app *application.AppContext, ris *downloader.RisRessource) *HtmlPage {
+
+	return &HtmlPage{
+		app:          app,
+		webRessource: ris,
+		file:         files.NewFile(app, ris),
+	}
+}
+
+func (p *HtmlPage) GetPath() string {
+	return p.file.GetPath()
+}
+
+func (p *HtmlPage) GetUrl() string {
+	return p.webRessource.GetUrl()
+}
+
+func (p *HtmlPage) Download() error {
+
+	_, err := p.file.Fetch(files.HttpGet, p.webRessource, "text/html", false)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error downloading page from %s, Error: %+v", p.webRessource.GetUrl(), err))
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(p.file.GetContent()))
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error create dom from %s", p.file.GetName()))
+	}
+
+	hash, err := pageFingerprint(p.app, p.file, doc, nil)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error creating fingerprint of %s", p.GetPath()))
+	}
+	return writePage(p.app, p.file, doc, hash)
+}
@@ -0,0 +1,129 @@
+package dpage
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/downloader"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type Personenliste struct {
+	app *application.AppContext
+}
+
+func NewPersonenliste(app *application.AppContext) Personenliste {
+	return Personenliste{
+		app: app,
+	}
+}
+
+// Synchronize download the Personenliste (kp0040), all linked persons (kp0050) and Fraktionen (pa021)
+// and delete stored persons and Fraktionen not listed anymore
+func (pl *Personenliste) Synchronize(redownload bool) error {
+
+	personen, fraktionen, err := pl.fetch(redownload)
+	if err != nil {
+		return errors.Wrap(err, "error fetching personenliste")
+	}
+
+	allFromRis := make(map[string]bool)
+	for _, ris := range append(personen, fraktionen...) {
+		page := NewHtmlPage(pl.app, &ris)
+		allFromRis[page.GetPath()] = true
+	}
+
+	err = PublishRisDownload(pl.app, append(personen, fraktionen...))
+	if err != nil {
+		return err
+	}
+
+	err = files.DeleteFilesIfNotInAndAfter(pl.app, pl.app.Config.GetPersonenFolder(), allFromRis, []string{}, time.Time{})
+	if err != nil {
+		return errors.Wrap(err, "error deleting personen")
+	}
+	err = files.DeleteFilesIfNotInAndAfter(pl.app, pl.app.Config.GetFraktionenFolder(), allFromRis, []string{}, time.Time{})
+	if err != nil {
+		return errors.Wrap(err, "error deleting fraktionen")
+	}
+	return nil
+}
+
+func (pl *Personenliste) fetch(redownload bool) (personen []downloader.RisRessource, fraktionen []downloader.RisRessource, err error) {
+
+	uri, err := url.Parse(pl.app.Config.GetTargetToParse() + pl.app.Config.GetUrlPersonenliste())
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot parse url")
+	}
+
+	srcWeb := downloader.NewRisRessource("", pl.app.Config.GetPersonenListeType(), ".html", time.Now(), uri, &url.Values{}, true, redownload)
+	targetStore := files.NewFile(pl.app, srcWeb)
+
+	_, err = targetStore.Fetch(files.HttpGet, srcWeb, "text/html", false)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("error downloading Personenliste from %s", pl.app.Config.GetUrlPersonenliste()))
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(targetStore.GetContent()))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("error create dom from %s", targetStore.GetName()))
+	}
+
+	foundPersonen := make(map[int]bool)
+	foundFraktionen := make(map[int]bool)
+	doc.Find("a").Each(func(i int, selection *goquery.Selection) {
+		lnk, _ := selection.Attr("href")
+
+		if matches := domtools.RegexPersonLink.FindStringSubmatch(lnk); len(matches) > 1 {
+			kplfdnr, errA := strconv.Atoi(matches[1])
+			if errA == nil && !foundPersonen[kplfdnr] {
+				foundPersonen[kplfdnr] = true
+				ris, errR := pl.createRessource(pl.app.Config.GetPersonenFolder(), pl.app.Config.GetPersonType(), pl.app.Config.GetUrlPersonTmpl(), kplfdnr, srcWeb)
+				if errR != nil {
+					slog.Warn("person %d ignored: %v", kplfdnr, errR)
+				} else {
+					personen = append(personen, *ris)
+				}
+			}
+		} else if matches := domtools.RegexFraktionLink.FindStringSubmatch(lnk); len(matches) > 1 {
+			palfdnr, errA := strconv.Atoi(matches[1])
+			if errA == nil && !foundFraktionen[palfdnr] {
+				foundFraktionen[palfdnr] = true
+				ris, errR := pl.createRessource(pl.app.Config.GetFraktionenFolder(), pl.app.Config.GetFraktionType(), pl.app.Config.GetUrlFraktionTmpl(), palfdnr, srcWeb)
+				if errR != nil {
+					slog.Warn("fraktion %d ignored: %v", palfdnr, errR)
+				} else {
+					fraktionen = append(fraktionen, *ris)
+				}
+			}
+		}
+	})
+
+	if len(personen) == 0 {
+		return nil, nil, errors.New("keine Personen (personenliste.html)")
+	}
+	slog.Info("loaded %d personen and %d fraktionen", len(personen), len(fraktionen))
+
+	err = writePage(pl.app, targetStore, doc, listFingerprint(ressourcePaths(pl.app, append(personen, fraktionen...))))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, fmt.Sprintf("error writing personenliste %s", srcWeb.GetName()))
+	}
+	return personen, fraktionen, nil
+}
+
+func (pl *Personenliste) createRessource(folder string, typ string, tmpl string, lfdnr int, parent *downloader.RisRessource) (*downloader.RisRessource, error) {
+
+	uri, err := url.Parse(pl.app.Config.GetTargetToParse() + fmt.Sprintf(tmpl, lfdnr))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse url")
+	}
+	name := fmt.Sprintf("%s-%d", typ, lfdnr)
+	return downloader.NewRisRessource(folder, name, ".html", time.Now(), uri, &url.Values{}, parent.RedownloadChildren, parent.RedownloadChildren), nil
+}
@@ -6,7 +6,7 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
 	"github.com/rismaster/allris-common/application"
-	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/domtools"
 	"github.com/rismaster/allris-common/common/files"
 	"github.com/rismaster/allris-common/common/slog"
 	"github.com/rismaster/allris-common/downloader"
@@ -23,6 +23,7 @@ type Sitzungsliste struct {
 
 type Gremium struct {
 	option   int
+	name     string
 	children []downloader.RisRessource
 }
 
@@ -49,7 +50,7 @@ func (sl *Sitzungsliste) SynchronizeSince(minTime time.Time, redownload bool) er
 		return err
 	}
 
-	childFolders := []string{sl.app.Config.GetAnlagenFolder(), sl.app.Config.GetTopFolder()}
+	childFolders := []string{sl.app.Config.GetAnlagenFolder(), sl.app.Config.GetTopFolder(), sl.app.Config.GetAnwesenheitFolder()}
 	err = files.DeleteFilesIfNotInAndAfter(sl.app, sl.app.Config.GetSitzungenFolder(), allSitzungenFromRis, childFolders, minTime)
 	if err != nil {
 		return errors.Wrap(err, "error deleting vorlagen")
@@ -79,7 +80,7 @@ func (sl *Sitzungsliste) downloadMax(countPerGremium int, redownload bool) (sitz
 	}
 
 	for _, gremium := range gremien {
-		slog.Info("Gremium %d", gremium.option)
+		slog.Info("Gremium %d (%s)", gremium.option, gremium.name)
 		errSizungsliste := sl.fetchSitzungsListe(gremium, redownload)
 		if errSizungsliste != nil {
 			slog.Error("error loading sitzungsliste for gremium %d, Reason: %v", gremium.option, errSizungsliste)
@@ -123,6 +124,7 @@ func (sl *Sitzungsliste) fetchLongSitzungsListe(minTime time.Time, redownload bo
 		return nil, errors.Wrap(err, fmt.Sprintf("error create dom from %s", targetStore.GetName()))
 	}
 
+	var alle []downloader.RisRessource
 	selector := "tr.zl11,tr.zl12"
 	doc.Find(selector).Each(func(index int, selection *goquery.Selection) {
 
@@ -132,6 +134,9 @@ func (sl *Sitzungsliste) fetchLongSitzungsListe(minTime time.Time, redownload bo
 			if err != nil {
 				log.Printf("error Parse sitzung element %v", err)
 			}
+			if sitzung != nil {
+				alle = append(alle, *sitzung)
+			}
 			if sitzung.GetUrl() != "" && sitzung.GetCreated().After(minTime) {
 				sitzungen = append(sitzungen, *sitzung)
 			}
@@ -141,8 +146,7 @@ func (sl *Sitzungsliste) fetchLongSitzungsListe(minTime time.Time, redownload bo
 		return nil, errors.New("keine Sitzungen (allesitzungen.html)")
 	}
 
-	newHash := common.Md5HashB(targetStore.GetContent())
-	err = targetStore.WriteIfMoreActualAndDifferent(newHash)
+	err = writePage(sl.app, targetStore, doc, listFingerprint(ressourceEintraege(sl.app, alle)))
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("error writing allesitzungen %s", srcWeb.GetName()))
 	}
@@ -192,8 +196,7 @@ func (sl *Sitzungsliste) fetchSitzungsListe(gremium *Gremium, redownload bool) (
 		return errors.New("falsche Sitzungsliste")
 	}
 
-	newHash := common.Md5HashB(targetStore.GetContent())
-	err = targetStore.WriteIfMoreActualAndDifferent(newHash)
+	err = writePage(sl.app, targetStore, doc, listFingerprint(ressourceEintraege(sl.app, gremium.children)))
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("error writing Gremienliste %s", srcWeb.GetName()))
 	}
@@ -282,14 +285,17 @@ func (sl *Sitzungsliste) fetchGremiumOptions(redownload bool) (gremien []*Gremiu
 			if intErr != nil {
 				slog.Warn("error parsing opt value ignored: %s reason: %v", optStr, intErr)
 			} else if opt < 1000 {
-				gremium := &Gremium{option: opt}
+				gremium := &Gremium{option: opt, name: domtools.CleanText(s.Text())}
 				options = append(options, gremium)
 			}
 		}
 	})
 
-	newHash := common.Md5HashB(targetStore.GetContent())
-	err = targetStore.WriteIfMoreActualAndDifferent(newHash)
+	var eintraege []string
+	for _, gremium := range options {
+		eintraege = append(eintraege, fmt.Sprintf("%d|%s", gremium.option, gremium.name))
+	}
+	err = writePage(sl.app, targetStore, doc, listFingerprint(eintraege))
 	if err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("error writing Gremienliste %s", srcWeb.GetName()))
 	}
@@ -6,7 +6,6 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/pkg/errors"
 	"github.com/rismaster/allris-common/application"
-	"github.com/rismaster/allris-common/common"
 	"github.com/rismaster/allris-common/common/domtools"
 	"github.com/rismaster/allris-common/common/files"
 	"github.com/rismaster/allris-common/common/slog"
@@ -98,13 +97,12 @@ func (vl *Vorlagenliste) fetch(ressourceUrl string, page int, risCreatedSince ti
 		return false, nil, errors.Wrap(err, fmt.Sprintf("error create dom from %s", targetStore.GetName()))
 	}
 
-	limitTimeReached, vorlagen, err = vl.parseChildren(doc, risCreatedSince, srcWeb)
+	limitTimeReached, vorlagen, alle, err := vl.parseChildren(doc, risCreatedSince, srcWeb)
 	if err != nil {
 		return false, nil, errors.Wrap(err, fmt.Sprintf("error parsing dom from %s", targetStore.GetName()))
 	}
 
-	newHash := common.Md5HashB(targetStore.GetContent())
-	err = targetStore.WriteIfMoreActualAndDifferent(newHash)
+	err = writePage(vl.app, targetStore, doc, listFingerprint(ressourceEintraege(vl.app, alle)))
 	if err != nil {
 		return false, nil, errors.Wrap(err, fmt.Sprintf("error writing vorlagenliste %s", srcWeb.GetName()))
 	}
@@ -112,7 +110,8 @@ func (vl *Vorlagenliste) fetch(ressourceUrl string, page int, risCreatedSince ti
 	return limitTimeReached, vorlagen, nil
 }
 
-func (vl *Vorlagenliste) parseChildren(doc *goquery.Document, risCreatedSince time.Time, vlRisResource *downloader.RisRessource) (limitTimeReached bool, vorlagen []downloader.RisRessource, err error) {
+// parseChildren returns the Vorlagen created after risCreatedSince and all Vorlagen of the page
+func (vl *Vorlagenliste) parseChildren(doc *goquery.Document, risCreatedSince time.Time, vlRisResource *downloader.RisRessource) (limitTimeReached bool, vorlagen []downloader.RisRessource, alle []downloader.RisRessource, err error) {
 
 	selector := "tr.zl11,tr.zl12"
 
@@ -120,6 +119,7 @@ func (vl *Vorlagenliste) parseChildren(doc *goquery.Document, risCreatedSince ti
 	doc.Find(selector).Each(func(index int, dom *goquery.Selection) {
 		vorlage, err := vl.parseElement(dom, vlRisResource)
 		if err == nil {
+			alle = append(alle, *vorlage)
 			if risCreatedSince.Before(vorlage.GetCreated()) {
 				vorlagen = append(vorlagen, *vorlage)
 			} else {
@@ -128,7 +128,7 @@ func (vl *Vorlagenliste) parseChildren(doc *goquery.Document, risCreatedSince ti
 		}
 	})
 
-	return limitTimeReached, vorlagen, nil
+	return limitTimeReached, vorlagen, alle, nil
 }
 
 func (vl *Vorlagenliste) parseElement(e *goquery.Selection, vlRisResource *downloader.RisRessource) (vorlage *downloader.RisRessource, err error) {
@@ -11,11 +11,14 @@ require (
 	github.com/algolia/algoliasearch-client-go/v3 v3.14.0
 	github.com/go-errors/errors v1.4.1 // indirect
 	github.com/kennygrant/sanitize v1.2.4
+	github.com/ledongthuc/pdf v0.0.0-20220302134840-0c2507a12d80
 	github.com/mailgun/mailgun-go/v4 v4.6.0
 	github.com/microcosm-cc/bluemonday v1.0.9
 	github.com/pkg/errors v0.9.1
 	golang.org/x/net v0.0.0-20210503060351-7fd8e65b6420
+	golang.org/x/text v0.3.6
 	google.golang.org/api v0.45.0
 	google.golang.org/genproto v0.0.0-20210420162539-3c870d7478d2
+	gopkg.in/yaml.v3 v3.0.1
 	h12.io/socks v1.0.2
 )
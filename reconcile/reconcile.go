@@ -0,0 +1,385 @@
+package reconcile
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/db"
+	"github.com/rismaster/allris-common/search"
+	"sort"
+	"strings"
+	"time"
+)
+
+const FindingEntityOhneDatei = "entity-ohne-datei"
+const FindingDateiOhneEntity = "datei-ohne-entity"
+const FindingAnlagenAnzahl = "anlagen-anzahl"
+const FindingOcrFehlt = "ocr-fehlt"
+const FindingOcrVerwaist = "ocr-verwaist"
+const FindingIndexVeraltet = "index-veraltet"
+const FindingNichtIndexiert = "nicht-indexiert"
+
+type Finding struct {
+	Typ       string
+	Path      string
+	Key       string
+	Detail    string
+	Repariert bool
+	Fehler    string
+}
+
+type Report struct {
+	Start    time.Time
+	Ende     time.Time
+	Findings []*Finding
+}
+
+// Checker compare the fetched bucket, the datastore and the search index, with Repair it
+// re-syncs or deletes entities and updates the search index (missing ocr is only reported)
+type Checker struct {
+	app         *application.AppContext
+	Repair      bool
+	CheckSearch bool
+
+	report *Report
+	files  map[string]*files.File
+}
+
+func NewChecker(app *application.AppContext, repair bool) *Checker {
+	return &Checker{
+		app:         app,
+		Repair:      repair,
+		CheckSearch: true,
+	}
+}
+
+func (r *Report) Count(typ string) int {
+	cnt := 0
+	for _, f := range r.Findings {
+		if f.Typ == typ {
+			cnt++
+		}
+	}
+	return cnt
+}
+
+func (r *Report) Log() {
+	for _, f := range r.Findings {
+		slog.Info("%s: %s %s %s (repariert: %v %s)", f.Typ, f.Path, f.Key, f.Detail, f.Repariert, f.Fehler)
+	}
+	for _, typ := range []string{FindingEntityOhneDatei, FindingDateiOhneEntity, FindingAnlagenAnzahl, FindingOcrFehlt, FindingOcrVerwaist, FindingIndexVeraltet, FindingNichtIndexiert} {
+		slog.Info("%s: %d", typ, r.Count(typ))
+	}
+}
+
+func (c *Checker) add(typ string, path string, key *datastore.Key, detail string) *Finding {
+	f := &Finding{Typ: typ, Path: path, Detail: detail}
+	if key != nil {
+		f.Key = key.String()
+	}
+	c.report.Findings = append(c.report.Findings, f)
+	return f
+}
+
+func (c *Checker) repaired(f *Finding, err error) {
+	if err != nil {
+		f.Fehler = err.Error()
+		slog.Error("error repairing %s %s: %v", f.Typ, f.Path, err)
+		return
+	}
+	f.Repariert = true
+}
+
+func (c *Checker) Run() (*Report, error) {
+
+	c.report = &Report{Start: time.Now()}
+	c.files = make(map[string]*files.File)
+
+	conf := c.app.Config
+	for _, folder := range []string{conf.GetVorlagenFolder(), conf.GetSitzungenFolder(), conf.GetTopFolder(), conf.GetAnlagenFolder()} {
+		fs, err := files.ListFiles(c.app, folder)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("error listing files in %s", folder))
+		}
+		for _, f := range fs {
+			c.files[f.GetPath()] = f
+		}
+	}
+	slog.Info("reconcile: %d files in %s", len(c.files), conf.GetBucketFetched())
+
+	err := c.checkEntities()
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.checkAnlagen()
+	if err != nil {
+		return nil, err
+	}
+
+	ocrDocs, err := c.checkOcr()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.CheckSearch {
+		err = c.checkSearch(ocrDocs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.report.Ende = time.Now()
+	return c.report, nil
+}
+
+func (c *Checker) checkEntities() error {
+
+	conf := c.app.Config
+	withEntity := make(map[string]bool)
+	for _, kind := range []string{conf.GetEntityVorlage(), conf.GetEntitySitzung(), conf.GetEntityTop()} {
+
+		keys, err := c.app.Db().GetAll(c.app.Ctx(), datastore.NewQuery(kind).KeysOnly(), nil)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error getting %s keys", kind))
+		}
+
+		for _, k := range keys {
+			path := db.GetEntityPath(c.app, k)
+			withEntity[path] = true
+			if _, exist := c.files[path]; exist {
+				continue
+			}
+
+			// Tops of a Vorlage-Beratungsfolge are created before their own page is fetched
+			if kind == conf.GetEntityTop() {
+				continue
+			}
+
+			f := c.add(FindingEntityOhneDatei, path, k, "")
+			if c.Repair {
+				c.repaired(f, c.deleteEntity(path))
+			}
+		}
+	}
+
+	for path := range c.files {
+		if strings.HasPrefix(path, conf.GetAnlagenFolder()) || withEntity[path] {
+			continue
+		}
+		f := c.add(FindingDateiOhneEntity, path, nil, "")
+		if c.Repair {
+			c.repaired(f, c.sync(path))
+		}
+	}
+	return nil
+}
+
+func (c *Checker) deleteEntity(path string) error {
+	holder, err := db.NewTopHolder(c.app, path)
+	if err != nil {
+		return err
+	}
+	return holder.Delete()
+}
+
+func (c *Checker) sync(path string) error {
+	holder, err := db.NewTopHolder(c.app, path)
+	if err != nil {
+		return err
+	}
+	return db.Sync(c.app, holder)
+}
+
+// checkAnlagen compare the Anlage entities per parent with the files of the parent in the anlagen folder
+func (c *Checker) checkAnlagen() error {
+
+	conf := c.app.Config
+	var anlagen []*db.Anlage
+	keys, err := c.app.Db().GetAll(c.app.Ctx(), datastore.NewQuery(conf.GetEntityAnlage()), &anlagen)
+	if err != nil {
+		return errors.Wrap(err, "error getting anlagen")
+	}
+
+	entitiesPerParent := make(map[string]int)
+	parentKeys := make(map[string]*datastore.Key)
+	for _, k := range keys {
+		if k.Parent == nil {
+			continue
+		}
+		parentPath := db.GetEntityPath(c.app, k.Parent)
+		entitiesPerParent[parentPath]++
+		parentKeys[parentPath] = k.Parent
+	}
+
+	filesPerParent := make(map[string]int)
+	for path := range c.files {
+		if !strings.HasPrefix(path, conf.GetAnlagenFolder()) {
+			continue
+		}
+		parentPath := c.parentPath(path)
+		if parentPath == "" {
+			continue
+		}
+		filesPerParent[parentPath]++
+		if _, exist := c.files[parentPath]; !exist {
+			c.add(FindingDateiOhneEntity, path, nil, fmt.Sprintf("parent %s fehlt", parentPath))
+		}
+	}
+
+	for parentPath, cnt := range entitiesPerParent {
+		if filesPerParent[parentPath] == cnt {
+			continue
+		}
+		f := c.add(FindingAnlagenAnzahl, parentPath, parentKeys[parentPath], fmt.Sprintf("%d entities, %d dateien", cnt, filesPerParent[parentPath]))
+		if c.Repair {
+			if _, exist := c.files[parentPath]; exist {
+				c.repaired(f, c.sync(parentPath))
+			}
+		}
+	}
+	return nil
+}
+
+// parentPath returns the path of the Vorlage, Sitzung or Top of an Anlage file
+func (c *Checker) parentPath(anlagePath string) string {
+
+	conf := c.app.Config
+	name := strings.TrimPrefix(anlagePath, conf.GetAnlagenFolder())
+	for _, typ := range []string{conf.GetAnlageDocumentType(), conf.GetAnlageType()} {
+		i := strings.Index(name, "-"+typ+"-")
+		if i < 0 {
+			continue
+		}
+		parent := name[:i]
+		switch {
+		case strings.Contains(parent, "-"+conf.GetTopType()+"-"):
+			return conf.GetTopFolder() + parent + ".html"
+		case strings.HasPrefix(parent, conf.GetVorlageType()+"-"):
+			return conf.GetVorlagenFolder() + parent + ".html"
+		case strings.HasPrefix(parent, conf.GetSitzungType()+"-"):
+			return conf.GetSitzungenFolder() + parent + ".html"
+		}
+	}
+	return ""
+}
+
+// checkOcr returns the Anlagen with ocr results (shared by all Anlagen with the same content)
+func (c *Checker) checkOcr() (map[string]bool, error) {
+
+	conf := c.app.Config
+	var ocrNames []string
+	for _, prefix := range []string{conf.GetAnlagenFolder(), files.ContentFolder} {
+		objects, err := files.ListObjectAttrs(c.app, conf.GetBucketOcr(), prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range objects {
+			ocrNames = append(ocrNames, o.Name)
+		}
+	}
+	sort.Strings(ocrNames)
+
+	withOcr := make(map[string]bool)
+	usedPrefixes := make(map[string]bool)
+	for path, f := range c.files {
+		if !strings.HasPrefix(path, conf.GetAnlagenFolder()) || !strings.HasSuffix(strings.ToLower(path), ".pdf") {
+			continue
+		}
+		prefix := f.GetOcrPrefix()
+		usedPrefixes[prefix] = true
+		usedPrefixes[path] = true
+		if hasPrefix(ocrNames, prefix) || hasPrefix(ocrNames, path) {
+			withOcr[path] = true
+		} else {
+			c.add(FindingOcrFehlt, path, nil, prefix)
+		}
+	}
+
+	prefixes := minimalPrefixes(usedPrefixes)
+	for _, name := range ocrNames {
+		if !startsWithOneOf(prefixes, name) {
+			c.add(FindingOcrVerwaist, name, nil, conf.GetBucketOcr())
+		}
+	}
+	return withOcr, nil
+}
+
+// minimalPrefixes returns the sorted prefixes without those starting with another prefix
+func minimalPrefixes(prefixes map[string]bool) []string {
+	var sorted []string
+	for p, used := range prefixes {
+		if used && p != "" {
+			sorted = append(sorted, p)
+		}
+	}
+	sort.Strings(sorted)
+
+	var result []string
+	for _, p := range sorted {
+		if len(result) == 0 || !strings.HasPrefix(p, result[len(result)-1]) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// startsWithOneOf returns true if name starts with one of the minimal prefixes, only the greatest
+// prefix not after name can match
+func startsWithOneOf(minimalPrefixes []string, name string) bool {
+	i := sort.SearchStrings(minimalPrefixes, name)
+	if i < len(minimalPrefixes) && minimalPrefixes[i] == name {
+		return true
+	}
+	return i > 0 && strings.HasPrefix(name, minimalPrefixes[i-1])
+}
+
+// hasPrefix returns true if one of the sorted names starts with prefix
+func hasPrefix(sortedNames []string, prefix string) bool {
+	i := sort.SearchStrings(sortedNames, prefix)
+	return i < len(sortedNames) && strings.HasPrefix(sortedNames[i], prefix)
+}
+
+func (c *Checker) checkSearch(ocrDocs map[string]bool) error {
+
+	sctx := &search.SearchContext{AppContext: c.app}
+	indexed, err := sctx.IndexedDocuments()
+	if err != nil {
+		return errors.Wrap(err, "error browsing search index")
+	}
+
+	for name := range indexed {
+		if _, exist := c.files[name]; exist {
+			continue
+		}
+		f := c.add(FindingIndexVeraltet, name, nil, "")
+		if c.Repair {
+			c.repaired(f, sctx.DeleteSearchForDocument(name))
+		}
+	}
+
+	for name := range ocrDocs {
+		if indexed[name] {
+			continue
+		}
+		f := c.add(FindingNichtIndexiert, name, nil, "")
+		if c.Repair {
+			c.repaired(f, sctx.UpdateSearchForDocument(name))
+		}
+	}
+	return nil
+}
+
+// Reconcile run the checker and log the report
+func Reconcile(app *application.AppContext, repair bool) (*Report, error) {
+
+	report, err := NewChecker(app, repair).Run()
+	if err != nil {
+		return nil, errors.Wrap(err, "error reconciling")
+	}
+	report.Log()
+	return report, nil
+}
@@ -0,0 +1,290 @@
+package search
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/algolia/algoliasearch-client-go/v3/algolia/opt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/slog"
+	"io"
+	"time"
+)
+
+const defaultSwapMinRatio = 0.95
+
+// PreviousIndexSuffix is appended to the live index name for the index replaced by the last swap
+const PreviousIndexSuffix = "_previous"
+
+// rollbackIndexSuffix is appended to the live index name for the copy of the previous index moved over the live index
+const rollbackIndexSuffix = "_rollback"
+
+// IndexAdmin copy and replace whole indices of the search backend
+type IndexAdmin interface {
+	Exists(name string) (bool, error)
+	CopySettings(source string, destination string) error
+	CopyIndex(source string, destination string) error
+	MoveIndex(source string, destination string) error
+	DeleteIndex(name string) error
+}
+
+// Exists returns true if the algolia index exists
+func (idx *ClientImpl) Exists(name string) (bool, error) {
+	return idx.Client.InitIndex(name).Exists()
+}
+
+// CopySettings copy settings, synonyms and rules of source to destination and wait for the task
+func (idx *ClientImpl) CopySettings(source string, destination string) error {
+	res, err := idx.Client.CopySettings(source, destination)
+	if err != nil {
+		return err
+	}
+	return res.Wait()
+}
+
+// CopyIndex copy records and settings of source to destination and wait for the task
+func (idx *ClientImpl) CopyIndex(source string, destination string) error {
+	res, err := idx.Client.CopyIndex(source, destination)
+	if err != nil {
+		return err
+	}
+	return res.Wait()
+}
+
+// MoveIndex replace destination by source atomically and wait for the task, source is removed
+func (idx *ClientImpl) MoveIndex(source string, destination string) error {
+	res, err := idx.Client.MoveIndex(source, destination)
+	if err != nil {
+		return err
+	}
+	return res.Wait()
+}
+
+// DeleteIndex delete the index with its records and settings and wait for the task
+func (idx *ClientImpl) DeleteIndex(name string) error {
+	res, err := idx.Client.InitIndex(name).Delete()
+	if err != nil {
+		return err
+	}
+	return res.Wait()
+}
+
+func (sctx *SearchContext) admin() IndexAdmin {
+	return &ClientImpl{Client: sctx.AppContext.Search()}
+}
+
+// VersionedIndexName returns the name of a new index for a rebuild of the live index
+func VersionedIndexName(live string, t time.Time) string {
+	return fmt.Sprintf("%s_%s", live, t.Format("20060102-150405"))
+}
+
+// NewRebuild create a job building all records into a new versioned index, swapped with the live index when done
+func (sctx *SearchContext) NewRebuild() *SearchIndexJob {
+	job := NewReindexAlle()
+	job.Index = VersionedIndexName(sctx.AppContext.Config.GetSearchIndex(), time.Now())
+	return job
+}
+
+// RebuildIndex enqueue and run a rebuild, the live index is untouched until the new one is validated
+func (sctx *SearchContext) RebuildIndex() (*SearchIndexJob, error) {
+	job := sctx.NewRebuild()
+	return job, sctx.Reindex(job)
+}
+
+// ValidateIndex compare the documents in the index with the Anlagen in the datastore,
+// at least Config.GetSearchSwapMinRatio of them must be indexed
+func (sctx *SearchContext) ValidateIndex() (indexed int, expected int, err error) {
+
+	expected, err = sctx.AppContext.Db().Count(sctx.AppContext.Ctx(), datastore.NewQuery(sctx.AppContext.Config.GetEntityAnlage()).KeysOnly())
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "error counting anlagen")
+	}
+
+	it, err := sctx.index().BrowseObjects(opt.AttributesToRetrieve("Document.Filename", "Record"))
+	if err != nil {
+		return 0, expected, err
+	}
+	documents := make(map[string]bool)
+	for {
+		var elem SearchElem
+		_, err = it.Next(&elem)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, expected, err
+		}
+		if elem.Record == RecordDokument {
+			documents[elem.Document.Filename] = true
+		}
+	}
+	indexed = len(documents)
+
+	minRatio := sctx.AppContext.Config.GetSearchSwapMinRatio()
+	if minRatio <= 0 {
+		minRatio = defaultSwapMinRatio
+	}
+	if float64(indexed) < minRatio*float64(expected) {
+		return indexed, expected, errors.New(fmt.Sprintf("index contains %d of %d documents", indexed, expected))
+	}
+	return indexed, expected, nil
+}
+
+// prepareIndex copy the settings of the live index to the index of the job and configure it
+func (sctx *SearchContext) prepareIndex(job *SearchIndexJob) error {
+
+	live := sctx.AppContext.Config.GetSearchIndex()
+	admin := sctx.admin()
+	exists, err := admin.Exists(live)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error checking index %s", live))
+	}
+	if exists {
+		err = admin.CopySettings(live, job.Index)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error copying settings of %s to %s", live, job.Index))
+		}
+	}
+	return sctx.ConfigureIndex()
+}
+
+// ConfigureIndex set the settings the records depend on (e.g. the facets needed by DeleteBy, one hit per family
+// of near-duplicates), other settings are kept
+func (sctx *SearchContext) ConfigureIndex() error {
+	err := sctx.ConfigureFacets()
+	if err != nil {
+		return err
+	}
+	return sctx.ConfigureDistinct()
+}
+
+// swapIndex validate the index of the job and replace the live index with it, the live index is kept as previous,
+// an invalid index is deleted; the changes written to the live index while the index was built are replayed before
+// and the ones during the replay after the swap
+func (sctx *SearchContext) swapIndex(job *SearchIndexJob) error {
+
+	indexed, expected, err := sctx.ValidateIndex()
+	if err != nil {
+		errDelete := sctx.admin().DeleteIndex(job.Index)
+		if errDelete != nil {
+			slog.Error("error deleting invalid index %s: %v", job.Index, errDelete)
+		}
+		return errors.Wrap(err, fmt.Sprintf("index %s not valid, live index not replaced", job.Index))
+	}
+	slog.Info("index %s valid with %d of %d documents", job.Index, indexed, expected)
+
+	replayed := time.Now()
+	err = sctx.replayChanges(job, job.Time)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error replaying changes since %s into %s", job.Time, job.Index))
+	}
+
+	err = sctx.SwapIndex(job.Index)
+	if err != nil {
+		return err
+	}
+
+	live := &SearchContext{AppContext: sctx.AppContext}
+	err = live.replayChanges(job, replayed)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error replaying changes since %s after the swap", replayed))
+	}
+	return nil
+}
+
+// replayChanges index the documents and entities changed since, they were written to the live index by db.Sync
+// and the ocr while the job was running, and delete the records of documents and entities deleted meanwhile
+func (sctx *SearchContext) replayChanges(job *SearchIndexJob, since time.Time) error {
+
+	replay := NewReindexDatum(since, time.Time{})
+	changed, entities, err := sctx.selectDocuments(replay)
+	if err != nil {
+		return err
+	}
+	sctx.indexBatch(replay, changed, entities, sctx.concurrency())
+	for _, fehler := range replay.Fehler {
+		job.addFehler(fehler)
+	}
+
+	existing, _, err := sctx.selectDocuments(&SearchIndexJob{Auswahl: AuswahlAlle, Index: job.Index})
+	if err != nil {
+		return err
+	}
+	exists := make(map[string]bool)
+	for _, name := range existing {
+		exists[name] = true
+	}
+
+	indexed, err := sctx.IndexedDocuments()
+	if err != nil {
+		return err
+	}
+	deleted := 0
+	for name := range indexed {
+		if exists[name] {
+			continue
+		}
+		err = sctx.DeleteSearchForDocument(name)
+		if err != nil {
+			return err
+		}
+		deleted++
+	}
+
+	slog.Info("replayed %d changes (%d failed) and %d deletions since %s", replay.Done, replay.Failed, deleted, since)
+	return nil
+}
+
+// SwapIndex replace the live index by name, the live index is copied to the previous index for RollbackIndex
+func (sctx *SearchContext) SwapIndex(name string) error {
+
+	live := sctx.AppContext.Config.GetSearchIndex()
+	previous := live + PreviousIndexSuffix
+	admin := sctx.admin()
+
+	exists, err := admin.Exists(live)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error checking index %s", live))
+	}
+	if exists {
+		err = admin.CopyIndex(live, previous)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("error copying index %s to %s", live, previous))
+		}
+	}
+
+	err = admin.MoveIndex(name, live)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error moving index %s to %s", name, live))
+	}
+	slog.Info("index %s is live as %s, previous index kept as %s", name, live, previous)
+	return nil
+}
+
+// RollbackIndex replace the live index by the index replaced by the last swap
+func (sctx *SearchContext) RollbackIndex() error {
+
+	live := sctx.AppContext.Config.GetSearchIndex()
+	previous := live + PreviousIndexSuffix
+	admin := sctx.admin()
+
+	exists, err := admin.Exists(previous)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error checking index %s", previous))
+	}
+	if !exists {
+		return errors.New(fmt.Sprintf("no previous index %s for rollback", previous))
+	}
+
+	//the live index is replaced atomically by a copy, the previous index is kept for another rollback
+	rollback := live + rollbackIndexSuffix
+	err = admin.CopyIndex(previous, rollback)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error copying index %s to %s", previous, rollback))
+	}
+	err = admin.MoveIndex(rollback, live)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error moving index %s to %s", rollback, live))
+	}
+	slog.Info("index %s rolled back to %s", live, previous)
+	return nil
+}
@@ -0,0 +1,228 @@
+package search
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const defaultMaxRecordBytes = 10000
+const defaultChunkOverlap = 200
+
+var regexParagraph = regexp.MustCompile(`\n\s*\n`)
+
+// Chunker split the pages of a document into search records. Pages are not split if they fit into a record,
+// longer pages are split at paragraphs, sentences and words. A record continuing the previous one starts
+// with its last Overlap bytes, so phrases across the border can be found.
+type Chunker struct {
+	MaxBytes int
+	Overlap  int
+	Size     func(page SearchPage) int //size of a page in a record, the length of the text if nil
+}
+
+// NewChunker create a chunker with the record size of the search backend (Config.GetSearchMaxRecordBytes)
+func (sctx *SearchContext) NewChunker() *Chunker {
+	c := &Chunker{
+		MaxBytes: sctx.AppContext.Config.GetSearchMaxRecordBytes(),
+		Overlap:  sctx.AppContext.Config.GetSearchChunkOverlap(),
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = defaultMaxRecordBytes
+	}
+	if c.Overlap < 0 || c.Overlap > c.MaxBytes/4 {
+		c.Overlap = defaultChunkOverlap
+	}
+	return c
+}
+
+// Chunk group the pages into records of at most MaxBytes (Size)
+func (c *Chunker) Chunk(pages []SearchPage) [][]SearchPage {
+
+	limit := c.MaxBytes - c.Overlap
+	var pieces []SearchPage
+	for _, p := range pages {
+		pieces = append(pieces, c.splitPage(p, limit)...)
+	}
+
+	var result [][]SearchPage
+	var current []SearchPage
+	size := 0
+	for _, p := range pieces {
+		if size+c.size(p) > c.MaxBytes && len(current) > 0 {
+			result = append(result, current)
+			last := current[len(current)-1]
+			current = nil
+			size = 0
+			if tail := overlapTail(last.Text, c.Overlap); tail != "" {
+				withTail := p
+				withTail.Text = tail + " " + p.Text
+				if c.size(withTail) <= c.MaxBytes {
+					p = withTail
+				}
+			}
+		}
+		current = append(current, p)
+		size = size + c.size(p)
+	}
+	if len(current) > 0 {
+		result = append(result, current)
+	}
+	return result
+}
+
+func (c *Chunker) size(p SearchPage) int {
+	if c.Size == nil {
+		return len(p.Text)
+	}
+	return c.Size(p)
+}
+
+// splitPage split a page larger than limit into pieces of at most limit, the text is split in proportion to
+// the share of the text in the size of the page
+func (c *Chunker) splitPage(p SearchPage, limit int) []SearchPage {
+
+	size := c.size(p)
+	if size <= limit || len(p.Text) <= utf8.UTFMax {
+		return []SearchPage{p}
+	}
+	max := len(p.Text) * limit / size
+	if max >= len(p.Text) {
+		max = len(p.Text) - 1
+	}
+	if max < utf8.UTFMax {
+		max = utf8.UTFMax
+	}
+
+	var result []SearchPage
+	for _, part := range splitText(p.Text, max) {
+		piece := p
+		piece.Text = part
+		result = append(result, c.splitPage(piece, limit)...)
+	}
+	return result
+}
+
+// overlapTail returns the last n bytes of text starting at a word
+func overlapTail(text string, n int) string {
+	if n <= 0 || text == "" {
+		return ""
+	}
+	if len(text) <= n {
+		return text
+	}
+	tail := text[len(text)-n:]
+	if i := strings.IndexAny(tail, " \n"); i >= 0 {
+		return strings.TrimSpace(tail[i:])
+	}
+	return ""
+}
+
+// splitText split text into parts of at most max bytes at paragraphs, sentences or words
+func splitText(text string, max int) []string {
+	return splitAt(strings.TrimSpace(text), max, []func(string) []string{splitParagraphs, splitSentences, strings.Fields})
+}
+
+func splitAt(text string, max int, splitters []func(string) []string) []string {
+
+	if len(text) <= max {
+		if text == "" {
+			return nil
+		}
+		return []string{text}
+	}
+	if len(splitters) == 0 {
+		return hardSplit(text, max)
+	}
+
+	var parts []string
+	var current strings.Builder
+	for _, unit := range splitters[0](text) {
+		if len(unit) > max {
+			if current.Len() > 0 {
+				parts = append(parts, current.String())
+				current.Reset()
+			}
+			parts = append(parts, splitAt(unit, max, splitters[1:])...)
+			continue
+		}
+		if current.Len() > 0 && current.Len()+1+len(unit) > max {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteString(" ")
+		}
+		current.WriteString(unit)
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+func splitParagraphs(text string) []string {
+	var result []string
+	for _, p := range regexParagraph.Split(text, -1) {
+		if p = strings.TrimSpace(p); p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// splitSentences split after . ! ? followed by an upper case letter, short words like "Nr." or "Abs." do not end a sentence
+func splitSentences(text string) []string {
+
+	var result []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] != '.' && text[i] != '!' && text[i] != '?' {
+			continue
+		}
+		j := i + 1
+		for j < len(text) && (text[j] == ' ' || text[j] == '\n' || text[j] == '\t') {
+			j++
+		}
+		if j == i+1 || j >= len(text) {
+			continue
+		}
+		next, _ := utf8.DecodeRuneInString(text[j:])
+		if !unicode.IsUpper(next) || lastWordLen(text[start:i]) <= 3 {
+			continue
+		}
+		if s := strings.TrimSpace(text[start : i+1]); s != "" {
+			result = append(result, s)
+		}
+		start = j
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" {
+		result = append(result, s)
+	}
+	return result
+}
+
+func lastWordLen(text string) int {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return 0
+	}
+	return utf8.RuneCountInString(fields[len(fields)-1])
+}
+
+// hardSplit cut text into parts of at most max bytes without splitting runes
+func hardSplit(text string, max int) []string {
+	var parts []string
+	for len(text) > max {
+		i := max
+		for i > 0 && !utf8.RuneStart(text[i]) {
+			i--
+		}
+		parts = append(parts, text[:i])
+		text = text[i:]
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts
+}
@@ -0,0 +1,154 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitText(t *testing.T) {
+
+	tests := []struct {
+		name string
+		text string
+		max  int
+		want []string
+	}{
+		{
+			name: "fits",
+			text: "Der Rat beschließt die Vorlage.",
+			max:  100,
+			want: []string{"Der Rat beschließt die Vorlage."},
+		},
+		{
+			name: "paragraphs",
+			text: "Sachverhalt der Vorlage.\n\nBegründung der Verwaltung.\n\nFinanzielle Auswirkungen keine.",
+			max:  31,
+			want: []string{"Sachverhalt der Vorlage.", "Begründung der Verwaltung.", "Finanzielle Auswirkungen keine."},
+		},
+		{
+			name: "sentences, Nr. does not end a sentence",
+			text: "Der Bebauungsplan Nr. 12 wird geändert. Die Verwaltung wird beauftragt.",
+			max:  45,
+			want: []string{"Der Bebauungsplan Nr. 12 wird geändert.", "Die Verwaltung wird beauftragt."},
+		},
+		{
+			name: "words",
+			text: "Haushaltssatzung Stellenplan Wirtschaftsplan",
+			max:  20,
+			want: []string{"Haushaltssatzung", "Stellenplan", "Wirtschaftsplan"},
+		},
+		{
+			name: "hard split keeps runes",
+			text: "Grünflächenüberplanung",
+			max:  10,
+			want: []string{"Grünfläc", "henüberpl", "anung"},
+		},
+		{
+			name: "empty",
+			text: "  ",
+			max:  10,
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitText(tt.text, tt.max)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitText = %q, want %q", got, tt.want)
+			}
+			for _, part := range got {
+				if len(part) > tt.max || !utf8.ValidString(part) {
+					t.Errorf("part %q longer than %d or invalid utf8", part, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestOverlapTail(t *testing.T) {
+
+	tests := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"Der Rat beschließt", 0, ""},
+		{"kurz", 10, "kurz"},
+		{"Der Rat beschließt die Vorlage", 10, "Vorlage"},
+		{"Haushaltssatzung", 5, ""},
+	}
+
+	for _, tt := range tests {
+		if got := overlapTail(tt.text, tt.n); got != tt.want {
+			t.Errorf("overlapTail(%q, %d) = %q, want %q", tt.text, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestChunk(t *testing.T) {
+
+	long := strings.Repeat("Die Verwaltung wird beauftragt, den Plan zu ändern. ", 10)
+
+	tests := []struct {
+		name    string
+		chunker Chunker
+		pages   []SearchPage
+		records int
+	}{
+		{
+			name:    "small pages in one record",
+			chunker: Chunker{MaxBytes: 100, Overlap: 10},
+			pages:   []SearchPage{{Seite: 1, Text: "Beschluss"}, {Seite: 2, Text: "Protokoll"}},
+			records: 1,
+		},
+		{
+			name:    "pages in several records",
+			chunker: Chunker{MaxBytes: 20, Overlap: 0},
+			pages:   []SearchPage{{Seite: 1, Text: "Beschlussvorlage"}, {Seite: 2, Text: "Begründung"}},
+			records: 2,
+		},
+		{
+			name:    "long page split",
+			chunker: Chunker{MaxBytes: 120, Overlap: 20},
+			pages:   []SearchPage{{Seite: 1, Abschnitt: "Begründung", Text: long}},
+			records: 9,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := tt.chunker.Chunk(tt.pages)
+			if len(records) != tt.records {
+				t.Errorf("%d records, want %d", len(records), tt.records)
+			}
+			for _, r := range records {
+				size := 0
+				for _, p := range r {
+					size = size + len(p.Text)
+					if p.Abschnitt != tt.pages[0].Abschnitt && len(tt.pages) == 1 {
+						t.Errorf("Abschnitt %q of a piece, want %q", p.Abschnitt, tt.pages[0].Abschnitt)
+					}
+				}
+				if size > tt.chunker.MaxBytes {
+					t.Errorf("record of %d bytes, max %d", size, tt.chunker.MaxBytes)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkOverlap(t *testing.T) {
+
+	c := Chunker{MaxBytes: 60, Overlap: 15}
+	records := c.Chunk([]SearchPage{{Seite: 1, Text: "Der Antrag der Fraktion wird abgelehnt. Die Vorlage wird einstimmig beschlossen."}})
+
+	if len(records) != 2 {
+		t.Fatalf("%d records, want 2", len(records))
+	}
+	if !strings.HasPrefix(records[1][0].Text, "abgelehnt.") {
+		t.Errorf("second record %q does not start with the tail of the first", records[1][0].Text)
+	}
+}
@@ -0,0 +1,185 @@
+package search
+
+import (
+	"fmt"
+	"github.com/algolia/algoliasearch-client-go/v3/algolia/opt"
+	"github.com/algolia/algoliasearch-client-go/v3/algolia/search"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/minhash"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/db"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// AttributeFamilie collapse the records of near-duplicate Anlagen into one hit (distinct)
+const AttributeFamilie = "Familie"
+
+// saveSignatur store the MinHash signature of the ocr text of the document for the detection of near-duplicates
+func (sctx *SearchContext) saveSignatur(documentName string, elems []*SearchParent) {
+
+	var texts []string
+	for _, elem := range elems {
+		for _, page := range elem.Pages {
+			texts = append(texts, page.Text)
+		}
+	}
+	text := strings.Join(texts, "\n")
+
+	key := sctx.createDocumentKey(filepath.Base(documentName), nil)
+	err := db.SetAnlageSignatur(sctx.AppContext, key, minhash.ForText(text), len(text))
+	if err != nil {
+		slog.Warn("error saving signature of %s: %v", documentName, err)
+	}
+}
+
+// updateFamilie group the document with its near-duplicates after saveSignatur, the records of the document get
+// the family by prepareSearchElem, the records of the other Anlagen with a changed family are updated
+func (sctx *SearchContext) updateFamilie(documentName string) {
+
+	key := sctx.createDocumentKey(filepath.Base(documentName), nil)
+	changed, err := db.UpdateFamilie(sctx.AppContext, key)
+	if err != nil {
+		slog.Warn("error grouping %s with its near-duplicates: %v", documentName, err)
+		return
+	}
+
+	var updates []map[string]interface{}
+	for _, anlage := range changed {
+		if anlage.Filename == documentName || anlage.Filename == "" {
+			continue
+		}
+		it, errBrowse := sctx.index().BrowseObjects(
+			opt.Filters(fmt.Sprintf("Document.Filename:\"%s\"", anlage.Filename)),
+			opt.AttributesToRetrieve("objectID"))
+		if errBrowse != nil {
+			slog.Warn("error updating family of %s: %v", anlage.Filename, errBrowse)
+			continue
+		}
+		var elem SearchElem
+		elem.setFamilie(anlage)
+		for {
+			var record struct {
+				ObjectID string `json:"objectID"`
+			}
+			_, errBrowse = it.Next(&record)
+			if errBrowse != nil {
+				break
+			}
+			updates = append(updates, familienUpdate(record.ObjectID, elem))
+		}
+		if errBrowse != io.EOF {
+			slog.Warn("error updating family of %s: %v", anlage.Filename, errBrowse)
+		}
+	}
+
+	if len(updates) > 0 {
+		_, err = sctx.index().PartialUpdateObjects(updates)
+		if err != nil {
+			slog.Warn("error updating families of the near-duplicates of %s: %v", documentName, err)
+		}
+	}
+}
+
+func familienUpdate(objectID string, elem SearchElem) map[string]interface{} {
+	return map[string]interface{}{
+		"objectID":  objectID,
+		"Familie":   elem.Familie,
+		"Kanonisch": elem.Kanonisch,
+	}
+}
+
+// setFamilie set the family of the Anlage, an Anlage without near-duplicates is canonical
+func (e *SearchElem) setFamilie(anlage *db.Anlage) {
+	e.Familie = anlage.Familie
+	e.Kanonisch = anlage.Familie == "" || anlage.Kanonisch
+}
+
+// UpdateDuplikate regroup all Anlagen into families of near-duplicates and update the records of the changed ones,
+// the documents are grouped when they are indexed (updateFamilie), this is for the migration and changed thresholds
+func (sctx *SearchContext) UpdateDuplikate() error {
+
+	changed, err := db.UpdateFamilien(sctx.AppContext)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	anlagen := make(map[string]*db.Anlage)
+	for _, a := range changed {
+		anlagen[a.Key.Encode()] = a
+	}
+
+	it, err := sctx.index().BrowseObjects(opt.AttributesToRetrieve("objectID", "Document.KeyEnc"))
+	if err != nil {
+		return err
+	}
+
+	var updates []map[string]interface{}
+	for {
+		var record struct {
+			ObjectID string         `json:"objectID"`
+			Document SearchDocument `json:"Document"`
+		}
+		_, err = it.Next(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		anlage, exist := anlagen[record.Document.KeyEnc]
+		if !exist {
+			continue
+		}
+		var elem SearchElem
+		elem.setFamilie(anlage)
+		updates = append(updates, familienUpdate(record.ObjectID, elem))
+	}
+
+	if len(updates) > 0 {
+		_, err = sctx.index().PartialUpdateObjects(updates)
+		if err != nil {
+			return errors.Wrap(err, "error updating families in search")
+		}
+	}
+	slog.Info("updated family of %d search records of %d anlagen", len(updates), len(changed))
+	return nil
+}
+
+// ConfigureDistinct set the index to return one hit per family of near-duplicates, the canonical Anlage first
+func (sctx *SearchContext) ConfigureDistinct() error {
+
+	settings, err := sctx.index().GetSettings()
+	if err != nil {
+		return errors.Wrap(err, "error getting search settings")
+	}
+
+	ranking := []string{"desc(Kanonisch)"}
+	var current []string
+	if settings.CustomRanking != nil {
+		current = settings.CustomRanking.Get()
+		for _, r := range current {
+			if r != ranking[0] {
+				ranking = append(ranking, r)
+			}
+		}
+	}
+	distinct, _ := settings.Distinct.Get()
+	if distinct && settings.AttributeForDistinct.Get() == AttributeFamilie && len(current) > 0 && current[0] == ranking[0] {
+		return nil
+	}
+
+	res, err := sctx.index().SetSettings(search.Settings{
+		AttributeForDistinct: opt.AttributeForDistinct(AttributeFamilie),
+		Distinct:             opt.Distinct(true),
+		CustomRanking:        opt.CustomRanking(ranking...),
+	})
+	if err != nil {
+		return errors.Wrap(err, "error setting distinct of search")
+	}
+	return res.Wait()
+}
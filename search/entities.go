@@ -0,0 +1,183 @@
+package search
+
+import (
+	"cloud.google.com/go/datastore"
+	"github.com/kennygrant/sanitize"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/domtools"
+	"github.com/rismaster/allris-common/common/extract"
+	"github.com/rismaster/allris-common/common/geo"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/db"
+	"regexp"
+	"strings"
+)
+
+const RecordDokument = "dokument"
+const RecordEntity = "entity"
+
+// RegisterEntityIndexing index Vorlagen and Tops with their html content after every db.Sync
+func RegisterEntityIndexing() {
+	db.AddChangeListener(func(app *application.AppContext, event db.ChangeEvent) error {
+		if event.Kind != app.Config.GetEntityVorlage() && event.Kind != app.Config.GetEntityTop() {
+			return nil
+		}
+		sctx := &SearchContext{AppContext: app}
+		if event.Action == db.ActionDelete {
+			return sctx.DeleteSearchForDocument(db.GetEntityPath(app, event.Key))
+		}
+		return sctx.UpdateSearchForEntity(event.Key, event.Entity)
+	})
+}
+
+// UpdateSearchForEntity replace the search records of a Vorlage or Top, entity is loaded if nil
+func (sctx *SearchContext) UpdateSearchForEntity(key *datastore.Key, entity interface{}) error {
+
+	app := sctx.AppContext
+	var sections []SearchPage
+	var bsvv, gremium string
+	var entities *extract.Result
+	var orte []geo.Ort
+	switch e := entity.(type) {
+	case *db.Vorlage:
+		sections = htmlSections(map[string]string{
+			"Beschlussvorlage":       e.BeschlussVorlage,
+			"Begründung":             e.Begruendung,
+			"Finanzielle Auswirkung": e.FinanzielleAuswirkung,
+		}, "Beschlussvorlage", "Begründung", "Finanzielle Auswirkung")
+		bsvv, gremium = e.BSVV, e.LetesBeratungsGremium
+		entities = vorlageNamedEntities(e)
+		orte = e.Orte
+	case *db.Top:
+		sections = htmlSections(map[string]string{
+			"Beschluss":   e.Beschluss,
+			"Protokoll":   e.Protokoll,
+			"ProtokollRe": e.ProtokollRe,
+		}, "Beschluss", "Protokoll", "ProtokollRe")
+		bsvv, gremium = e.BSVV, e.Gremium
+	case nil:
+		return sctx.loadAndUpdateSearchForEntity(key)
+	default:
+		return nil
+	}
+
+	path := db.GetEntityPath(app, key)
+	err := sctx.DeleteSearchForDocument(path)
+	if err != nil {
+		return err
+	}
+	if len(sections) == 0 {
+		return nil
+	}
+
+	parent, beratungen, err := sctx.getEntityBeratungen(key)
+	if err != nil {
+		return err
+	}
+
+	sctx.normalizePages(sections)
+	if entities == nil {
+		entities = &extract.Result{}
+		extractor := sctx.extractor()
+		for _, s := range sections {
+			entities.Merge(extractor.Extract(s.Text))
+		}
+		orte = sctx.geocode(entities)
+	}
+
+	template := SearchElem{
+		Record:     RecordEntity,
+		TotalPages: len(sections),
+		Parent:     parent,
+		Beratungen: beratungen,
+		BSVV:       bsvv,
+		Gremium:    gremium,
+		Kanonisch:  true,
+		Document: SearchDocument{
+			Title:    parent.Title,
+			Datum:    parent.Datum,
+			KeyEnc:   key.Encode(),
+			Kind:     key.Kind,
+			Name:     key.Name,
+			Filename: path,
+		},
+	}
+	template.setNamedEntities(entities)
+	template.setOrte(orte)
+	template.setThemen(nil)
+
+	var elems []SearchElem
+	for _, pages := range sctx.chunkPages(sections, recordSize(template)) {
+		elem := template
+		elem.Pages = pages
+		elems = append(elems, elem)
+	}
+
+	_, err = sctx.index().SaveObjects(elems, true)
+	if err != nil {
+		slog.Error("error indexing %s - %v", path, err)
+		return err
+	}
+	slog.Info("Saved Search for %s with %d records", path, len(elems))
+	return nil
+}
+
+func (sctx *SearchContext) loadAndUpdateSearchForEntity(key *datastore.Key) error {
+
+	var entity interface{}
+	if key.Kind == sctx.AppContext.Config.GetEntityVorlage() {
+		entity = &db.Vorlage{}
+	} else {
+		entity = &db.Top{}
+	}
+	err := sctx.AppContext.Db().Get(sctx.AppContext.Ctx(), key, entity)
+	if err == datastore.ErrNoSuchEntity {
+		return sctx.DeleteSearchForDocument(db.GetEntityPath(sctx.AppContext, key))
+	}
+	if err != nil {
+		return err
+	}
+	return sctx.UpdateSearchForEntity(key, entity)
+}
+
+// htmlSections returns the stripped text of the non empty html fields in the given order, long sections are split by the Chunker
+func htmlSections(html map[string]string, order ...string) []SearchPage {
+
+	var sections []SearchPage
+	for _, name := range order {
+		text := htmlText(html[name])
+		if text == "" {
+			continue
+		}
+		sections = append(sections, SearchPage{
+			Seite:     len(sections) + 1,
+			Abschnitt: name,
+			Text:      text,
+		})
+	}
+	return sections
+}
+
+// regexHtmlAbsatz, regexHtmlZeile and regexHtmlZelle mark the ends of paragraphs, lines and table cells in the html
+var regexHtmlAbsatz = regexp.MustCompile(`(?i)</(p|div|li|tr|table|ul|ol|h[1-6])>`)
+var regexHtmlZeile = regexp.MustCompile(`(?i)<br\s*/?>`)
+var regexHtmlZelle = regexp.MustCompile(`(?i)</(td|th)>`)
+
+// htmlText returns the text of the html with the whitespace within the lines collapsed, the paragraphs are
+// separated by an empty line for the Chunker (splitParagraphs)
+func htmlText(html string) string {
+
+	var absaetze []string
+	for _, absatz := range regexHtmlAbsatz.Split(regexHtmlZelle.ReplaceAllString(html, " "), -1) {
+		var zeilen []string
+		for _, zeile := range regexHtmlZeile.Split(absatz, -1) {
+			if zeile = domtools.CleanText(sanitize.HTML(zeile)); zeile != "" {
+				zeilen = append(zeilen, zeile)
+			}
+		}
+		if len(zeilen) > 0 {
+			absaetze = append(absaetze, strings.Join(zeilen, "\n"))
+		}
+	}
+	return strings.Join(absaetze, "\n\n")
+}
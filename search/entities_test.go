@@ -0,0 +1,43 @@
+package search
+
+import (
+	"testing"
+)
+
+func TestHtmlText(t *testing.T) {
+
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "paragraphs",
+			html: "<p>Der Rat   beschließt\n die Vorlage.</p><p>Begründung:</p>",
+			want: "Der Rat beschließt die Vorlage.\n\nBegründung:",
+		},
+		{
+			name: "lines",
+			html: "<div>Zeile 1<br>Zeile\t2</div>Text",
+			want: "Zeile 1\nZeile 2\n\nText",
+		},
+		{
+			name: "list and table",
+			html: "<ul><li>eins</li><li>zwei</li></ul><table><tr><td>a</td><td>b</td></tr></table>",
+			want: "eins\n\nzwei\n\na b",
+		},
+		{
+			name: "empty",
+			html: "<p> </p><p>&nbsp;</p>",
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := htmlText(tt.html); got != tt.want {
+				t.Errorf("htmlText = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package search
+
+import (
+	"github.com/algolia/algoliasearch-client-go/v3/algolia/opt"
+	"github.com/algolia/algoliasearch-client-go/v3/algolia/search"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/extract"
+	"github.com/rismaster/allris-common/common/geo"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/db"
+	"path/filepath"
+	"strings"
+)
+
+// facets are the attributes of the named entities, topics and areas filtered and counted by the search,
+// Document.Filename is needed by DeleteSearchForDocument
+var facets = []string{"Strassen", "Adressen", "Gemarkungen", "Flurstuecke", "BPlaene", "Betraege", "Themen", "Gebiete", "filterOnly(Document.Filename)"}
+
+// ConfigureFacets add the facets to the attributesForFaceting of the index, other facets are kept
+func (sctx *SearchContext) ConfigureFacets() error {
+
+	settings, err := sctx.index().GetSettings()
+	if err != nil {
+		return errors.Wrap(err, "error getting search settings")
+	}
+
+	var attributes []string
+	exist := make(map[string]bool)
+	if settings.AttributesForFaceting != nil {
+		for _, a := range settings.AttributesForFaceting.Get() {
+			attributes = append(attributes, a)
+			exist[facetAttribute(a)] = true
+		}
+	}
+	added := 0
+	for _, a := range facets {
+		if !exist[facetAttribute(a)] {
+			attributes = append(attributes, a)
+			added++
+		}
+	}
+	if added == 0 {
+		return nil
+	}
+
+	res, err := sctx.index().SetSettings(search.Settings{
+		AttributesForFaceting: opt.AttributesForFaceting(attributes...),
+	})
+	if err != nil {
+		return errors.Wrap(err, "error setting facets of search")
+	}
+	return res.Wait()
+}
+
+// facetAttribute returns the attribute of a facet without the modifier, e.g. Strassen for searchable(Strassen)
+func facetAttribute(facet string) string {
+	if i := strings.Index(facet, "("); i >= 0 && strings.HasSuffix(facet, ")") {
+		return facet[i+1 : len(facet)-1]
+	}
+	return facet
+}
+
+func (sctx *SearchContext) extractor() *extract.Extractor {
+	extractor, err := extract.ForGazetteer(sctx.AppContext.Config.GetExtractGazetteer())
+	if err != nil {
+		slog.Warn("streets not checked against gazetteer: %v", err)
+	}
+	return extractor
+}
+
+// extractNamedEntities returns the named entities of all pages of a document
+func (sctx *SearchContext) extractNamedEntities(elems []*SearchParent) *extract.Result {
+
+	extractor := sctx.extractor()
+	result := &extract.Result{}
+	for _, elem := range elems {
+		for _, page := range elem.Pages {
+			result.Merge(extractor.Extract(page.Text))
+		}
+	}
+	return result
+}
+
+// vorlageNamedEntities returns the named entities extracted when the Vorlage was parsed
+func vorlageNamedEntities(v *db.Vorlage) *extract.Result {
+	return &extract.Result{
+		Strassen:    v.Strassen,
+		Adressen:    v.Adressen,
+		Gemarkungen: v.Gemarkungen,
+		Flurstuecke: v.Flurstuecke,
+		BPlaene:     v.BPlaene,
+		Betraege:    v.Betraege,
+	}
+}
+
+// geocode the named entities with the local address list Config.GetGeoAdressen
+func (sctx *SearchContext) geocode(r *extract.Result) []geo.Ort {
+	geocoder, err := geo.ForAdressen(sctx.AppContext.Config.GetGeoAdressen())
+	if err != nil {
+		slog.Warn("documents not geocoded: %v", err)
+	}
+	return geocoder.GeocodeEntities(r)
+}
+
+// saveOrte store the locations of the document for the GeoJSON export of its Vorlage
+func (sctx *SearchContext) saveOrte(documentName string, orte []geo.Ort) {
+	key := sctx.createDocumentKey(filepath.Base(documentName), nil)
+	err := db.SetAnlageOrte(sctx.AppContext, key, orte)
+	if err != nil {
+		slog.Warn("error saving locations of %s: %v", documentName, err)
+	}
+}
+
+func (e *SearchElem) setOrte(orte []geo.Ort) {
+	e.Gebiete = nil
+	e.Geoloc = nil
+	seen := make(map[string]bool)
+	for _, o := range orte {
+		e.Geoloc = append(e.Geoloc, GeoLoc{Lat: o.Lat, Lng: o.Lon})
+		if o.Gebiet != "" && !seen[o.Gebiet] {
+			seen[o.Gebiet] = true
+			e.Gebiete = append(e.Gebiete, o.Gebiet)
+		}
+	}
+}
+
+func (e *SearchElem) setNamedEntities(r *extract.Result) {
+	e.Strassen = r.Strassen
+	e.Adressen = r.Adressen
+	e.Gemarkungen = r.Gemarkungen
+	e.Flurstuecke = r.Flurstuecke
+	e.BPlaene = r.BPlaene
+	e.Betraege = r.Betraege
+}
@@ -0,0 +1,84 @@
+package search
+
+import (
+	"encoding/json"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/common/textnorm"
+	"sync"
+)
+
+var dictionaryMutex sync.Mutex
+var dictionaries = make(map[string]*textnorm.Dictionary)
+
+// normalizer returns the text pipeline with the compound dictionary Config.GetSearchCompoundDictionary (optional)
+func (sctx *SearchContext) normalizer() *textnorm.Normalizer {
+
+	path := sctx.AppContext.Config.GetSearchCompoundDictionary()
+	if path == "" {
+		return textnorm.NewNormalizer(nil)
+	}
+
+	dictionaryMutex.Lock()
+	defer dictionaryMutex.Unlock()
+	dict, loaded := dictionaries[path]
+	if !loaded {
+		var err error
+		dict, err = textnorm.LoadDictionary(path)
+		if err != nil {
+			slog.Error("compounds not split: %v", err)
+		} else {
+			slog.Info("compound dictionary %s loaded with %d words", path, dict.Len())
+		}
+		dictionaries[path] = dict
+	}
+	return textnorm.NewNormalizer(dict)
+}
+
+// normalizePages normalise the text of the pages
+func (sctx *SearchContext) normalizePages(pages []SearchPage) {
+	n := sctx.normalizer()
+	for i := range pages {
+		pages[i].Text = n.Normalize(pages[i].Text)
+	}
+}
+
+// chunkPages group the normalised pages into records and add the search terms of every page, the pages of a record
+// with their terms fit into Config.GetSearchMaxRecordBytes without the reserve (the other fields of the record)
+func (sctx *SearchContext) chunkPages(pages []SearchPage, reserve int) [][]SearchPage {
+
+	n := sctx.normalizer()
+	c := sctx.NewChunker()
+	budget := c.MaxBytes - reserve
+	if budget < c.MaxBytes/minTextShare {
+		slog.Warn("the fields of the record need %d of %d bytes, records will be too large", reserve, c.MaxBytes)
+		budget = c.MaxBytes / minTextShare
+	}
+	c.MaxBytes = budget
+	if c.Overlap > c.MaxBytes/4 {
+		c.Overlap = c.MaxBytes / 4
+	}
+	c.Size = func(p SearchPage) int {
+		p.Terms = n.Terms(p.Text)
+		return recordSize(p) + 1
+	}
+
+	chunks := c.Chunk(pages)
+	for _, chunk := range chunks {
+		for i := range chunk {
+			chunk[i].Terms = n.Terms(chunk[i].Text)
+		}
+	}
+	return chunks
+}
+
+// minTextShare is the minimal share of the text of a record (1/minTextShare of the record size)
+const minTextShare = 4
+
+// recordSize returns the size of the json of a record or a part of it
+func recordSize(v interface{}) int {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
@@ -0,0 +1,441 @@
+package search
+
+import (
+	"cloud.google.com/go/datastore"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/common/files"
+	"github.com/rismaster/allris-common/common/publisher"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/db"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const JobQueued = "queued"
+const JobRunning = "running"
+const JobDone = "done"
+const JobFailed = "failed"
+
+const AuswahlAlle = "alle"
+const AuswahlPrefix = "prefix"
+const AuswahlDatum = "datum"
+const AuswahlKind = "kind"
+
+const defaultReindexBatchSize = 50
+const defaultReindexConcurrency = 4
+
+// maxJobFehler is the maximal number of errors kept in a job
+const maxJobFehler = 100
+
+// jobLease is the time after which a running job without progress is resumed by another worker
+const jobLease = 30 * time.Minute
+
+// errJobUebernommen is returned when the job was claimed by another worker
+var errJobUebernommen = errors.New("search index job claimed by another worker")
+
+// SearchIndexJob is a reindex of documents of the fetched bucket, the progress is saved after every batch
+type SearchIndexJob struct {
+	Document string    //prefix of the documents (AuswahlPrefix)
+	Time     time.Time //time the job was queued
+	Auswahl  string
+	Kind     string    //type of the parent, e.g. Config.GetVorlageType() (AuswahlKind)
+	Von      time.Time //documents saved in [Von, Bis) (AuswahlDatum)
+	Bis      time.Time
+	Index    string //build into this index and swap it with the live index when done (RebuildIndex)
+
+	Status       string
+	Total        int
+	Done         int
+	Failed       int
+	LastDocument string    //documents are processed ordered by name, a resumed job continues after this document
+	Fehler       []string  `datastore:",noindex"`
+	Owner        string    //claim of the worker running the job (claimJob)
+	Updated      time.Time //a running job not updated for jobLease is taken over
+	Finished     time.Time
+
+	Key *datastore.Key `datastore:"-"`
+}
+
+func NewReindexAlle() *SearchIndexJob {
+	return &SearchIndexJob{Auswahl: AuswahlAlle}
+}
+
+func NewReindexPrefix(prefix string) *SearchIndexJob {
+	return &SearchIndexJob{Auswahl: AuswahlPrefix, Document: prefix}
+}
+
+// NewReindexDatum reindex the documents saved in [von, bis), bis zero means until now
+func NewReindexDatum(von time.Time, bis time.Time) *SearchIndexJob {
+	return &SearchIndexJob{Auswahl: AuswahlDatum, Von: von, Bis: bis}
+}
+
+// NewReindexKind reindex the documents of one parent type, e.g. Config.GetSitzungType()
+func NewReindexKind(kind string) *SearchIndexJob {
+	return &SearchIndexJob{Auswahl: AuswahlKind, Kind: kind}
+}
+
+// NewReindexIncremental reindex the documents updated since the last finished job
+func (sctx *SearchContext) NewReindexIncremental() (*SearchIndexJob, error) {
+
+	query := datastore.NewQuery(sctx.AppContext.Config.GetSearchIndexJobEntity()).
+		Filter("Status =", JobDone).
+		Order("-Finished").
+		Limit(1)
+
+	var jobs []*SearchIndexJob
+	_, err := sctx.AppContext.Db().GetAll(sctx.AppContext.Ctx(), query, &jobs)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting last search index job")
+	}
+	if len(jobs) == 0 {
+		return NewReindexAlle(), nil
+	}
+	return NewReindexDatum(jobs[0].Time, time.Time{}), nil
+}
+
+// EnqueueReindex save the job to be processed by ProcessReindexJobs
+func (sctx *SearchContext) EnqueueReindex(job *SearchIndexJob) error {
+
+	job.Status = JobQueued
+	job.Time = time.Now()
+	job.Updated = job.Time
+
+	key, err := sctx.AppContext.Db().Put(sctx.AppContext.Ctx(), datastore.IncompleteKey(sctx.AppContext.Config.GetSearchIndexJobEntity(), nil), job)
+	if err != nil {
+		return errors.Wrap(err, "error saving search index job")
+	}
+	job.Key = key
+	slog.Info("search index job %s (%s) queued", key.String(), job.Auswahl)
+	return nil
+}
+
+// Reindex enqueue and run the job
+func (sctx *SearchContext) Reindex(job *SearchIndexJob) error {
+
+	err := sctx.EnqueueReindex(job)
+	if err != nil {
+		return err
+	}
+	return sctx.RunReindexJob(job)
+}
+
+// ProcessReindexJobs run all queued jobs and resume interrupted ones (not updated for jobLease), oldest first,
+// jobs run by another worker are skipped
+func (sctx *SearchContext) ProcessReindexJobs() error {
+
+	var jobs []*SearchIndexJob
+	for _, status := range []string{JobRunning, JobQueued} {
+		query := datastore.NewQuery(sctx.AppContext.Config.GetSearchIndexJobEntity()).Filter("Status =", status)
+		var found []*SearchIndexJob
+		keys, err := sctx.AppContext.Db().GetAll(sctx.AppContext.Ctx(), query, &found)
+		if err != nil {
+			return errors.Wrap(err, "error getting search index jobs")
+		}
+		for i, job := range found {
+			job.Key = keys[i]
+		}
+		jobs = append(jobs, found...)
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].Time.Before(jobs[j].Time)
+	})
+
+	for _, job := range jobs {
+		err := sctx.RunReindexJob(job)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunReindexJob index the selected documents in batches of Config.GetSearchReindexBatchSize with
+// Config.GetSearchReindexConcurrency parallel documents, the done event is sent when the job is finished
+func (sctx *SearchContext) RunReindexJob(job *SearchIndexJob) error {
+
+	app := sctx.AppContext
+
+	resumed, err := sctx.claimJob(job)
+	if err == errJobUebernommen {
+		slog.Info("search index job %s is not queued or run by another worker", job.Key.String())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if job.Index != "" {
+		sctx = &SearchContext{AppContext: app, Index: app.Search().InitIndex(job.Index)}
+	}
+
+	documents, entities, err := sctx.selectDocuments(job)
+	if err != nil {
+		job.Status = JobFailed
+		job.addFehler(err.Error())
+		sctx.saveJob(job)
+		return err
+	}
+
+	if !resumed {
+		job.Total = len(documents)
+		if job.Index != "" {
+			err = sctx.prepareIndex(job)
+		} else {
+			err = sctx.ConfigureIndex()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	err = sctx.saveJob(job)
+	if err != nil {
+		return err
+	}
+
+	var todo []string
+	for _, document := range documents {
+		if document > job.LastDocument {
+			todo = append(todo, document)
+		}
+	}
+	slog.Info("search index job %s: %d of %d documents to index", job.Key.String(), len(todo), job.Total)
+
+	batchSize := app.Config.GetSearchReindexBatchSize()
+	if batchSize <= 0 {
+		batchSize = defaultReindexBatchSize
+	}
+	concurrency := sctx.concurrency()
+
+	for start := 0; start < len(todo); start += batchSize {
+
+		select {
+		case <-app.Ctx().Done():
+			return app.Ctx().Err()
+		default:
+		}
+
+		end := start + batchSize
+		if end > len(todo) {
+			end = len(todo)
+		}
+		sctx.indexBatch(job, todo[start:end], entities, concurrency)
+
+		job.LastDocument = todo[end-1]
+		err = sctx.saveJob(job)
+		if err != nil {
+			return err
+		}
+	}
+
+	if job.Index != "" {
+		err = sctx.swapIndex(job)
+		if err != nil {
+			job.Status = JobFailed
+			job.addFehler(err.Error())
+			sctx.saveJob(job)
+			return err
+		}
+	}
+
+	job.Status = JobDone
+	job.Finished = time.Now()
+	err = sctx.saveJob(job)
+	if err != nil {
+		return err
+	}
+	slog.Info("search index job %s done: %d documents, %d failed", job.Key.String(), job.Done, job.Failed)
+
+	done := &publisher.PublishDoneRequest{
+		Topic:   app.Config.GetPublicSearchIndexDoneTopic(),
+		Message: fmt.Sprintf("reindex %s: %d documents, %d failed", job.Auswahl, job.Done, job.Failed),
+	}
+	done.SendDoneEvent(app)
+	return nil
+}
+
+func (sctx *SearchContext) concurrency() int {
+	concurrency := sctx.AppContext.Config.GetSearchReindexConcurrency()
+	if concurrency <= 0 {
+		concurrency = defaultReindexConcurrency
+	}
+	return concurrency
+}
+
+func (sctx *SearchContext) indexBatch(job *SearchIndexJob, documents []string, entities map[string]*datastore.Key, concurrency int) {
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+
+	for _, document := range documents {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(document string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			var err error
+			if key, isEntity := entities[document]; isEntity {
+				err = sctx.UpdateSearchForEntity(key, nil)
+			} else {
+				err = sctx.UpdateSearchForDocument(document)
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				job.Failed++
+				job.addFehler(fmt.Sprintf("%s: %v", document, err))
+			} else {
+				job.Done++
+			}
+		}(document)
+	}
+	wg.Wait()
+}
+
+// selectDocuments returns the names of the documents and entities of the job ordered by name,
+// entities (Vorlagen and Tops with own records) are returned with their key
+func (sctx *SearchContext) selectDocuments(job *SearchIndexJob) ([]string, map[string]*datastore.Key, error) {
+
+	conf := sctx.AppContext.Config
+	prefix := conf.GetAnlagenFolder()
+	if job.Auswahl == AuswahlPrefix {
+		prefix = job.Document
+	}
+
+	objects, err := files.ListObjectAttrs(sctx.AppContext, conf.GetBucketFetched(), prefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result []string
+	for _, o := range objects {
+		switch job.Auswahl {
+		case AuswahlDatum:
+			//Updated is changed by every fetch of unchanged content (touch)
+			savedAt := files.SavedAt(o)
+			if savedAt.Before(job.Von) || (!job.Bis.IsZero() && !savedAt.Before(job.Bis)) {
+				continue
+			}
+		case AuswahlKind:
+			if !strings.HasPrefix(filepath.Base(o.Name), job.Kind+"-") {
+				continue
+			}
+		}
+		result = append(result, o.Name)
+	}
+
+	//the entity records are kept up to date by db.Sync, they are part of full rebuilds and of the selections by date and kind
+	entities := make(map[string]*datastore.Key)
+	if (job.Auswahl == AuswahlAlle && job.Index != "") || job.Auswahl == AuswahlDatum || job.Auswahl == AuswahlKind {
+		for _, kind := range []string{conf.GetEntityVorlage(), conf.GetEntityTop()} {
+			query := datastore.NewQuery(kind).KeysOnly()
+			if job.Auswahl == AuswahlDatum {
+				query = query.Filter("SavedAt >=", job.Von)
+				if !job.Bis.IsZero() {
+					query = query.Filter("SavedAt <", job.Bis)
+				}
+			}
+			keys, errKeys := sctx.AppContext.Db().GetAll(sctx.AppContext.Ctx(), query, nil)
+			if errKeys != nil {
+				return nil, nil, errors.Wrap(errKeys, fmt.Sprintf("error getting %s keys", kind))
+			}
+			for _, k := range keys {
+				path := db.GetEntityPath(sctx.AppContext, k)
+				if path == "" || (job.Auswahl == AuswahlKind && !strings.HasPrefix(filepath.Base(path), job.Kind+"-")) {
+					continue
+				}
+				entities[path] = k
+				result = append(result, path)
+			}
+		}
+	}
+
+	sort.Strings(result)
+	return result, entities, nil
+}
+
+// claimJob mark a queued job or a running job without progress for jobLease as run by this worker, the job is
+// reloaded; returns true if a running job is resumed, errJobUebernommen if another worker runs it
+func (sctx *SearchContext) claimJob(job *SearchIndexJob) (bool, error) {
+
+	owner := fmt.Sprintf("%s-%d-%d", hostname(), os.Getpid(), time.Now().UnixNano())
+	resumed := false
+	_, err := sctx.AppContext.Db().RunInTransaction(sctx.AppContext.Ctx(), func(tx *datastore.Transaction) error {
+
+		var stored SearchIndexJob
+		err := tx.Get(job.Key, &stored)
+		if err != nil {
+			return err
+		}
+		switch {
+		case stored.Status == JobQueued:
+		case stored.Status == JobRunning && time.Since(stored.Updated) > jobLease:
+			resumed = true
+		default:
+			return errJobUebernommen
+		}
+
+		stored.Status = JobRunning
+		stored.Owner = owner
+		stored.Updated = time.Now()
+		_, err = tx.Put(job.Key, &stored)
+		if err != nil {
+			return err
+		}
+		stored.Key = job.Key
+		*job = stored
+		return nil
+	})
+	if err == errJobUebernommen {
+		return false, err
+	}
+	if err != nil {
+		return false, errors.Wrap(err, fmt.Sprintf("error claiming search index job %s", job.Key.String()))
+	}
+	return resumed, nil
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "worker"
+	}
+	return name
+}
+
+// saveJob save the progress of the job if it is still claimed by this worker, errJobUebernommen otherwise
+func (sctx *SearchContext) saveJob(job *SearchIndexJob) error {
+
+	job.Updated = time.Now()
+	_, err := sctx.AppContext.Db().RunInTransaction(sctx.AppContext.Ctx(), func(tx *datastore.Transaction) error {
+
+		var stored SearchIndexJob
+		err := tx.Get(job.Key, &stored)
+		if err != nil {
+			return err
+		}
+		if stored.Owner != job.Owner {
+			return errJobUebernommen
+		}
+		_, err = tx.Put(job.Key, job)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error saving search index job %s", job.Key.String()))
+	}
+	return nil
+}
+
+func (job *SearchIndexJob) addFehler(fehler string) {
+	if len(job.Fehler) < maxJobFehler {
+		job.Fehler = append(job.Fehler, fehler)
+	}
+}
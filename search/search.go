@@ -2,18 +2,19 @@ package search
 
 import (
 	"cloud.google.com/go/datastore"
-	"cloud.google.com/go/storage"
 	"fmt"
+	"github.com/algolia/algoliasearch-client-go/v3/algolia/opt"
 	"github.com/algolia/algoliasearch-client-go/v3/algolia/search"
 	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/files"
 	"github.com/rismaster/allris-common/common/ocr"
 	"github.com/rismaster/allris-common/common/slog"
 	"github.com/rismaster/allris-common/db"
-	"google.golang.org/api/iterator"
+	"io"
 	"log"
 	"path/filepath"
+	"sort"
 	"strings"
-	"time"
 )
 
 type SearchParent struct {
@@ -21,8 +22,11 @@ type SearchParent struct {
 }
 
 type SearchPage struct {
-	Seite int
-	Text  string
+	Seite     int
+	Abschnitt string
+	Text      string
+	Terms     []string //umlaut variants and compound parts of the text (textnorm)
+	Link      string
 }
 
 type SearchEntity struct {
@@ -32,6 +36,7 @@ type SearchEntity struct {
 	KeyEnc   string
 	Kind     string
 	Name     string
+	Themen   []string
 }
 
 type SearchDocument struct {
@@ -60,15 +65,50 @@ type SearchBeratung struct {
 }
 
 type SearchElem struct {
+	Record     string
+	BSVV       string
+	Gremium    string
 	Pages      []SearchPage
 	Document   SearchDocument
 	Parent     SearchEntity
 	Beratungen []SearchBeratung
 	TotalPages int
+
+	//named entities of the whole document or entity as facets (extract)
+	Strassen    []string
+	Adressen    []string
+	Gemarkungen []string
+	Flurstuecke []string
+	BPlaene     []string
+	Betraege    []float64
+
+	//topics of the document and its parent (taxonomy)
+	Themen []string
+
+	//family of near-duplicate Anlagen (minhash), canonical if the Anlage has no near-duplicates
+	Familie   string `json:",omitempty"`
+	Kanonisch bool
+
+	//geocoded Adressen and Strassen (geo)
+	Gebiete []string
+	Geoloc  []GeoLoc `json:"_geoloc,omitempty"`
+}
+
+type GeoLoc struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
 }
 
 type SearchContext struct {
 	AppContext *application.AppContext
+	Index      *search.Index //index to write to, the live index Config.GetSearchIndex if nil
+}
+
+func (sctx *SearchContext) index() *search.Index {
+	if sctx.Index != nil {
+		return sctx.Index
+	}
+	return sctx.AppContext.SearchIndex()
 }
 
 type Index interface {
@@ -82,7 +122,7 @@ type Client interface {
 
 func (sctx *SearchContext) UpdateSearchForDocument(documentName string) error {
 
-	err := sctx.deleteOldEntitiesInSearch(documentName)
+	err := sctx.DeleteSearchForDocument(documentName)
 	if err != nil {
 		slog.Error("error deleting search index for %s:  %v", documentName, err)
 		return err
@@ -97,30 +137,95 @@ func (sctx *SearchContext) UpdateSearchForDocument(documentName string) error {
 	return nil
 }
 
-func (sctx *SearchContext) deleteOldEntitiesInSearch(documentName string) error {
-	slog.Info("Delete old Document %s", documentName)
-	_, err := sctx.AppContext.SearchIndex().DeleteBy(fmt.Sprintf("Document.Name:\"%s\"", documentName))
+// UpdateSearchForOcrResult update the search of all documents with the content of an ocr result
+func (sctx *SearchContext) UpdateSearchForOcrResult(ocrName string) error {
+
+	if !strings.HasPrefix(ocrName, files.ContentFolder) {
+		return sctx.UpdateSearchForDocument(ocrName)
+	}
+
+	ref, err := files.GetContentRef(sctx.AppContext, ocrName)
+	if err != nil {
+		return err
+	}
+	for _, path := range ref.Paths {
+		err = sctx.UpdateSearchForDocument(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSearchForDocument remove all search records of a document (Document.Filename must be a facet)
+func (sctx *SearchContext) DeleteSearchForDocument(documentName string) error {
+	slog.Info("Delete Document %s from search", documentName)
+	_, err := sctx.index().DeleteBy(opt.Filters(fmt.Sprintf("Document.Filename:\"%s\"", documentName)))
 	return err
 }
 
+// IndexedDocuments returns the filenames of all documents in the search index
+func (sctx *SearchContext) IndexedDocuments() (map[string]bool, error) {
+
+	it, err := sctx.index().BrowseObjects(opt.AttributesToRetrieve("Document.Filename"))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool)
+	for {
+		var elem SearchElem
+		_, err = it.Next(&elem)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if elem.Document.Filename != "" {
+			result[elem.Document.Filename] = true
+		}
+	}
+	return result, nil
+}
+
 func (sctx *SearchContext) createEntitiesInSearch(documentName string) error {
 
-	totalPages, pageelems, err := sctx.processPage(documentName)
+	totalPages, pages, err := sctx.processPage(documentName)
 	if err != nil {
 		slog.Error("error processing document %s: %v", documentName, err)
 		return err
 	}
+	document := []*SearchParent{{Pages: pages}}
+	entities := sctx.extractNamedEntities(document)
+	orte := sctx.geocode(entities)
+	sctx.saveOrte(documentName, orte)
+	themen := sctx.classify(document)
+	sctx.saveThemen(documentName, themen)
+	sctx.saveSignatur(documentName, document)
+	sctx.updateFamilie(documentName)
+
+	template, err := sctx.prepareSearchElem(&SearchParent{}, documentName, totalPages)
+	if err != nil {
+		slog.Error("error preparing search element for document %s, %v", documentName, err)
+		return err
+	}
+	template.setNamedEntities(entities)
+	template.setOrte(orte)
+	template.setThemen(themen)
+
+	chunks := sctx.chunkPages(pages, recordSize(template))
+	if len(chunks) == 0 {
+		chunks = [][]SearchPage{{}}
+	}
 	var searchElems []SearchElem
-	for _, pe := range pageelems {
-		result, err := sctx.prepareSearchElem(pe, documentName, totalPages)
-		if err != nil {
-			slog.Error("error preparing search element for document %s, %v", documentName, err)
-			return err
-		}
+	for _, chunk := range chunks {
+		result := template
+		result.Pages = chunk
 		searchElems = append(searchElems, result)
 		log.Printf("%s (%s) => %s", result.Parent.Kind, result.Parent.Name, result.Document.Name)
 	}
-	_, err = sctx.AppContext.SearchIndex().SaveObjects(searchElems, true)
+	_, err = sctx.index().SaveObjects(searchElems, true)
 	if err != nil {
 		slog.Error("error inexing document %s - %v", documentName, err)
 		return err
@@ -146,6 +251,7 @@ func (sctx *SearchContext) prepareSearchElem(elem *SearchParent, documentName st
 
 	result := SearchElem{
 
+		Record:     RecordDokument,
 		Pages:      elem.Pages,
 		TotalPages: totalPages,
 		Parent:     entity,
@@ -159,64 +265,58 @@ func (sctx *SearchContext) prepareSearchElem(elem *SearchParent, documentName st
 		},
 		Beratungen: beratungen,
 	}
+	result.setFamilie(&anlage)
 	return result, nil
 }
 
-func (sctx *SearchContext) countBytesSearchParent(p *SearchParent) int {
-	if p == nil {
-		return 0
+// processPage returns the normalised ocr pages of a document ordered by page
+func (sctx *SearchContext) processPage(documentName string) (totalPages int, pages []SearchPage, err error) {
+
+	ocrPrefix, err := files.GetOcrPrefix(sctx.AppContext, documentName)
+	if err != nil {
+		slog.Warn("no content info for document %s, use ocr of path - %v", documentName, err)
+		ocrPrefix = documentName
 	}
-	count := 0
-	for _, p := range p.Pages {
-		count = count + len(p.Text)
+
+	totalPages, pages, err = sctx.processOcr(ocrPrefix)
+	if err == nil && totalPages == 0 && ocrPrefix != documentName {
+		// ocr results created before the content was stored content addressed
+		return sctx.processOcr(documentName)
 	}
-	return count
+	return totalPages, pages, err
 }
 
-func (sctx *SearchContext) processPage(documentName string) (totalPages int, elems []*SearchParent, err error) {
+func (sctx *SearchContext) processOcr(ocrPrefix string) (totalPages int, pages []SearchPage, err error) {
 
-	var lastElem = &SearchParent{
-		Pages: []SearchPage{},
+	objects, err := files.ListObjectAttrs(sctx.AppContext, sctx.AppContext.Config.GetBucketOcr(), ocrPrefix)
+	if err != nil {
+		slog.Error("Error on iterating files for document %s - %v", ocrPrefix, err)
+		return 0, nil, err
 	}
-	elems = append(elems, lastElem)
-	query := &storage.Query{Prefix: documentName}
-	it := sctx.AppContext.Store().Bucket(sctx.AppContext.Config.GetBucketOcr()).Objects(sctx.AppContext.Ctx(), query)
-	var lastPCount = sctx.countBytesSearchParent(lastElem)
-	for {
 
-		attrs, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			slog.Error("Error on iterating files for document %s - %v", documentName, err)
-			return 0, nil, err
-		}
+	for _, attrs := range ocr.SelectOcrResults(objects, ocrPrefix) {
 
 		jsonOcr, err := ocr.ReadOcrFromFile(sctx.AppContext, attrs.Name, sctx.AppContext.Config.GetBucketOcr())
 		if err != nil {
 			slog.Error("Error on Reading Ocr-File %s - %v", attrs.Name, err)
+			continue
 		}
 
 		for _, resp := range jsonOcr.Responses {
-
-			countBytes := len([]byte(resp.FullTextAnnotation.Text))
-			lastPCount = lastPCount + countBytes
-			if lastPCount > 10500 {
-				lastElem = &SearchParent{
-					Pages: []SearchPage{},
-				}
-				lastPCount = 0
-				elems = append(elems, lastElem)
-			}
-			lastElem.Pages = append(lastElem.Pages, SearchPage{
+			pages = append(pages, SearchPage{
 				Text:  resp.FullTextAnnotation.Text,
 				Seite: resp.Context.PageNumber,
+				Link:  ocr.PageLink(ocrPrefix, resp.Context.PageNumber),
 			})
 		}
 		totalPages = totalPages + len(jsonOcr.Responses)
 	}
-	return totalPages, elems, nil
+
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].Seite < pages[j].Seite
+	})
+	sctx.normalizePages(pages)
+	return totalPages, pages, nil
 }
 
 func (sctx *SearchContext) getEntityBeratungen(parentKey *datastore.Key) (entity SearchEntity, results []SearchBeratung, err error) {
@@ -255,6 +355,7 @@ func (sctx *SearchContext) getEntityBeratungen(parentKey *datastore.Key) (entity
 			Kind:     sctx.AppContext.Config.GetEntityVorlage(),
 			KeyEnc:   parentKey.Encode(),
 			SubTitle: vorlage.Federfuehrend,
+			Themen:   vorlage.Themen,
 		}
 	} else if parentKey.Kind == sctx.AppContext.Config.GetEntityTop() {
 
@@ -280,6 +381,7 @@ func (sctx *SearchContext) getEntityBeratungen(parentKey *datastore.Key) (entity
 			Kind:     sctx.AppContext.Config.GetEntityTop(),
 			KeyEnc:   parentKey.Encode(),
 			SubTitle: fmt.Sprintf("%s | %s", top.Federfuehrend, sitzung.Gremium),
+			Themen:   top.Themen,
 		}
 	}
 
@@ -343,11 +445,6 @@ type ClientImpl struct {
 	Client *search.Client
 }
 
-type SearchIndexJob struct {
-	Document string
-	Time     time.Time
-}
-
 func (idx *IndexImpl) DeleteBy(option string) (interface{}, error) {
 	return idx.Index.DeleteBy(option)
 }
@@ -0,0 +1,54 @@
+package search
+
+import (
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/common/taxonomy"
+	"github.com/rismaster/allris-common/db"
+	"path/filepath"
+	"sort"
+)
+
+func (sctx *SearchContext) taxonomy() *taxonomy.Taxonomy {
+	t, err := taxonomy.ForFile(sctx.AppContext.Config.GetTaxonomy())
+	if err != nil {
+		slog.Warn("no topics classified: %v", err)
+	}
+	return t
+}
+
+// classify returns the topics of all pages of a document
+func (sctx *SearchContext) classify(elems []*SearchParent) []string {
+
+	var texts []string
+	for _, elem := range elems {
+		for _, page := range elem.Pages {
+			texts = append(texts, page.Text)
+		}
+	}
+	return sctx.taxonomy().Classify(texts...)
+}
+
+// saveThemen store the topics of the document and add them to its Vorlage or Top
+func (sctx *SearchContext) saveThemen(documentName string, themen []string) {
+	key := sctx.createDocumentKey(filepath.Base(documentName), nil)
+	err := db.SetAnlageThemen(sctx.AppContext, key, themen)
+	if err != nil {
+		slog.Warn("error saving topics of %s: %v", documentName, err)
+	}
+}
+
+// setThemen set the topics of the record and of its parent entity
+func (e *SearchElem) setThemen(themen []string) {
+
+	seen := make(map[string]bool)
+	e.Themen = nil
+	for _, list := range [][]string{themen, e.Parent.Themen} {
+		for _, t := range list {
+			if !seen[t] {
+				seen[t] = true
+				e.Themen = append(e.Themen, t)
+			}
+		}
+	}
+	sort.Strings(e.Themen)
+}
@@ -0,0 +1,240 @@
+package webhook
+
+import (
+	"bytes"
+	"cloud.google.com/go/datastore"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/db"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+const maxBackoff = 24 * time.Hour
+
+// ProcessQueue deliver up to limit pending deliveries that are due, failures are retried
+// with exponential backoff until GetWebhookMaxAttempts is reached and then dead-lettered
+func ProcessQueue(app *application.AppContext, limit int) (delivered int, failed int, err error) {
+
+	query := datastore.NewQuery(app.Config.GetEntityWebhookDelivery()).
+		Filter("Status =", StatusPending).
+		Filter("NextAttempt <=", time.Now()).
+		Order("NextAttempt").
+		Limit(limit)
+
+	var deliveries []*Delivery
+	keys, err := app.Db().GetAll(app.Ctx(), query, &deliveries)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "error getting webhook deliveries from db")
+	}
+
+	endpoints := make(map[string]*Endpoint)
+	client := &http.Client{Timeout: app.Config.GetWebhookTimeout()}
+
+	for i, d := range deliveries {
+		d.Key = keys[i]
+
+		endpointKey := d.Key.Parent
+		endpoint, exist := endpoints[endpointKey.Encode()]
+		if !exist {
+			endpoint = new(Endpoint)
+			errGet := app.Db().Get(app.Ctx(), endpointKey, endpoint)
+			if errGet != nil && errGet != datastore.ErrNoSuchEntity {
+				return delivered, failed, errors.Wrap(errGet, fmt.Sprintf("error getting webhook endpoint %s", endpointKey.String()))
+			}
+			if errGet == datastore.ErrNoSuchEntity {
+				endpoint = nil
+			}
+			endpoints[endpointKey.Encode()] = endpoint
+		}
+
+		if endpoint == nil || !endpoint.Aktiv {
+			d.Status = StatusDead
+			d.LastError = "endpoint removed or inactive"
+			d.Finished = time.Now()
+			_, errPut := app.Db().Put(app.Ctx(), d.Key, d)
+			if errPut != nil {
+				slog.Error("error dead-lettering webhook delivery %s: %v", d.Key.String(), errPut)
+			}
+			failed++
+			continue
+		}
+
+		if deliver(app, client, endpoint, d) {
+			delivered++
+		} else {
+			failed++
+		}
+	}
+
+	slog.Info("webhooks delivered: %d, failed: %d", delivered, failed)
+	return delivered, failed, nil
+}
+
+func deliver(app *application.AppContext, client *http.Client, endpoint *Endpoint, d *Delivery) bool {
+
+	start := time.Now()
+	statusCode := 0
+	body, sendErr := payloadBody(app, d)
+	if sendErr == nil {
+		statusCode, sendErr = send(client, endpoint, d.EventId, body)
+	}
+
+	d.Attempts++
+	d.LastStatusCode = statusCode
+	attempt := &DeliveryAttempt{
+		Nr:         d.Attempts,
+		Time:       start,
+		StatusCode: statusCode,
+		DauerMs:    time.Since(start).Milliseconds(),
+	}
+
+	if sendErr == nil {
+		d.Status = StatusDelivered
+		d.LastError = ""
+		d.Finished = time.Now()
+	} else {
+		attempt.Error = sendErr.Error()
+		d.LastError = sendErr.Error()
+		if d.Attempts >= app.Config.GetWebhookMaxAttempts() {
+			slog.Warn("webhook delivery %s to %s dead after %d attempts: %v", d.EventId, endpoint.Url, d.Attempts, sendErr)
+			d.Status = StatusDead
+			d.Finished = time.Now()
+		} else {
+			d.NextAttempt = time.Now().Add(backoff(app.Config.GetWebhookBackoff(), d.Attempts))
+		}
+	}
+
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+		_, err := tx.Put(d.Key, d)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Put(datastore.IncompleteKey(app.Config.GetEntityWebhookAttempt(), d.Key), attempt)
+		return err
+	})
+	if err != nil {
+		slog.Error("error saving webhook delivery %s: %v", d.Key.String(), err)
+	}
+
+	return sendErr == nil
+}
+
+// payloadBody add the current data of the entity to the payload of an update, deleted entities are sent without data
+func payloadBody(app *application.AppContext, d *Delivery) ([]byte, error) {
+
+	var payload Payload
+	err := json.Unmarshal(d.Payload, &payload)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading webhook payload %s", d.EventId))
+	}
+	if payload.Action != db.ActionUpdate {
+		return d.Payload, nil
+	}
+
+	key, err := datastore.DecodeKey(payload.Key)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error reading key of webhook payload %s", d.EventId))
+	}
+	payload.Data, err = db.LoadEntity(app, key)
+	if err != nil && errors.Cause(err) != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	return json.Marshal(payload)
+}
+
+func send(client *http.Client, endpoint *Endpoint, eventId string, body []byte) (int, error) {
+
+	req, err := http.NewRequest("POST", endpoint.Url, bytes.NewBuffer(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventIdHeader, eventId)
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.New(fmt.Sprintf("unexpected status %s from %s", resp.Status, endpoint.Url))
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff doubles the wait time per attempt and adds some jitter like the RetryClient
+func backoff(base time.Duration, attempts int) time.Duration {
+
+	wait := base
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait = wait * 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	if wait > 0 {
+		wait = wait + time.Duration(rand.Int63n(int64(wait)))/6
+	}
+	return wait
+}
+
+// Redeliver put a dead-lettered delivery back into the queue
+func Redeliver(app *application.AppContext, key *datastore.Key) error {
+
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+		var d Delivery
+		err := tx.Get(key, &d)
+		if err != nil {
+			return err
+		}
+		d.Status = StatusPending
+		d.Attempts = 0
+		d.NextAttempt = time.Now()
+		d.Finished = time.Time{}
+		_, err = tx.Put(key, &d)
+		return err
+	})
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error requeue webhook delivery %s", key.String()))
+	}
+	return nil
+}
+
+// GetDeadLetters list dead deliveries for inspection or Redeliver
+func GetDeadLetters(app *application.AppContext, limit int) ([]*Delivery, error) {
+
+	var deliveries []*Delivery
+	keys, err := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityWebhookDelivery()).
+		Filter("Status =", StatusDead).
+		Order("-Finished").
+		Limit(limit), &deliveries)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting dead webhook deliveries from db")
+	}
+	for i, d := range deliveries {
+		d.Key = keys[i]
+	}
+	return deliveries, nil
+}
+
+// GetDeliveryLog list all attempts of a delivery
+func GetDeliveryLog(app *application.AppContext, key *datastore.Key) ([]*DeliveryAttempt, error) {
+
+	var attempts []*DeliveryAttempt
+	_, err := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityWebhookAttempt()).Ancestor(key).Order("Nr"), &attempts)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting delivery log of %s", key.String()))
+	}
+	return attempts, nil
+}
@@ -0,0 +1,278 @@
+package webhook
+
+import (
+	"cloud.google.com/go/datastore"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/rismaster/allris-common/application"
+	"github.com/rismaster/allris-common/common"
+	"github.com/rismaster/allris-common/common/slog"
+	"github.com/rismaster/allris-common/db"
+	"sync"
+	"time"
+)
+
+const SignatureHeader = "X-Signature"
+const EventIdHeader = "X-Event-Id"
+
+const StatusPending = "pending"
+const StatusDelivered = "delivered"
+const StatusDead = "dead"
+
+// endpointCacheTTL is the time the active endpoints are cached for the events of a Sync run
+const endpointCacheTTL = time.Minute
+
+// Endpoint is a registered receiver of change events, Kinds or Themen empty means all kinds or topics
+type Endpoint struct {
+	Url     string
+	Secret  string `datastore:",noindex"`
+	Kinds   []string
+	Themen  []string
+	Aktiv   bool
+	Created time.Time
+
+	Key *datastore.Key `datastore:"-"`
+}
+
+// Delivery is one event queued for one endpoint (child of the endpoint),
+// the Payload refers to the entity by key, its data is loaded when sent
+type Delivery struct {
+	EventId        string
+	Kind           string
+	Action         string
+	Payload        []byte `datastore:",noindex"`
+	Status         string
+	Attempts       int
+	NextAttempt    time.Time
+	LastStatusCode int
+	LastError      string `datastore:",noindex"`
+	Created        time.Time
+	Finished       time.Time
+
+	Key *datastore.Key `datastore:"-"`
+}
+
+// DeliveryAttempt is the delivery log entry for every try (child of the delivery)
+type DeliveryAttempt struct {
+	Nr         int
+	Time       time.Time
+	StatusCode int
+	Error      string `datastore:",noindex"`
+	DauerMs    int64
+}
+
+type Payload struct {
+	Id     string      `json:"id"`
+	Action string      `json:"action"`
+	Kind   string      `json:"kind"`
+	Name   string      `json:"name"`
+	Key    string      `json:"key"`
+	Time   time.Time   `json:"time"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// Register enqueue webhook deliveries for every change written by db.Sync and the Delete functions
+func Register() {
+	db.AddChangeListener(Enqueue)
+}
+
+// Sign create the hex encoded HMAC-SHA256 of body, sent in SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify check a signature created by Sign, for use on the receiving side
+func Verify(secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+func AddEndpoint(app *application.AppContext, url string, secret string, kinds []string) (*datastore.Key, error) {
+
+	endpoint := &Endpoint{
+		Url:     url,
+		Secret:  secret,
+		Kinds:   kinds,
+		Aktiv:   true,
+		Created: time.Now(),
+	}
+
+	key, err := app.Db().Put(app.Ctx(), datastore.IncompleteKey(app.Config.GetEntityWebhookEndpoint(), nil), endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error saving webhook endpoint %s", url))
+	}
+	invalidateEndpoints()
+	return key, nil
+}
+
+func SetEndpointAktiv(app *application.AppContext, key *datastore.Key, aktiv bool) error {
+
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+		var endpoint Endpoint
+		err := tx.Get(key, &endpoint)
+		if err != nil {
+			return err
+		}
+		endpoint.Aktiv = aktiv
+		_, err = tx.Put(key, &endpoint)
+		return err
+	})
+	invalidateEndpoints()
+	return err
+}
+
+// SetEndpointThemen restrict the endpoint to events of Vorlagen and Tops with one of the topics, nil for all
+func SetEndpointThemen(app *application.AppContext, key *datastore.Key, themen []string) error {
+
+	_, err := app.Db().RunInTransaction(app.Ctx(), func(tx *datastore.Transaction) error {
+		var endpoint Endpoint
+		err := tx.Get(key, &endpoint)
+		if err != nil {
+			return err
+		}
+		endpoint.Themen = themen
+		_, err = tx.Put(key, &endpoint)
+		return err
+	})
+	invalidateEndpoints()
+	return err
+}
+
+func GetEndpoints(app *application.AppContext) ([]*Endpoint, error) {
+
+	var endpoints []*Endpoint
+	keys, err := app.Db().GetAll(app.Ctx(), datastore.NewQuery(app.Config.GetEntityWebhookEndpoint()).Filter("Aktiv =", true), &endpoints)
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting webhook endpoints from db")
+	}
+	for i, e := range endpoints {
+		e.Key = keys[i]
+	}
+	return endpoints, nil
+}
+
+var endpointMutex sync.Mutex
+var endpointCache []*Endpoint
+var endpointCacheTime time.Time
+
+// cachedEndpoints returns the active endpoints, loaded at most once per endpointCacheTTL
+func cachedEndpoints(app *application.AppContext) ([]*Endpoint, error) {
+
+	endpointMutex.Lock()
+	defer endpointMutex.Unlock()
+
+	if endpointCache != nil && time.Since(endpointCacheTime) < endpointCacheTTL {
+		return endpointCache, nil
+	}
+	endpoints, err := GetEndpoints(app)
+	if err != nil {
+		return nil, err
+	}
+	if endpoints == nil {
+		endpoints = []*Endpoint{}
+	}
+	endpointCache, endpointCacheTime = endpoints, time.Now()
+	return endpoints, nil
+}
+
+func invalidateEndpoints() {
+	endpointMutex.Lock()
+	defer endpointMutex.Unlock()
+	endpointCache = nil
+}
+
+func (e *Endpoint) accepts(kind string) bool {
+	if len(e.Kinds) == 0 {
+		return true
+	}
+	for _, k := range e.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsThemen returns true if the endpoint is interested in one of the topics, events without topics
+// (deleted entities not loaded, kinds without topics, unclassified entities) are always accepted
+func (e *Endpoint) acceptsThemen(themen []string) bool {
+	if len(e.Themen) == 0 || len(themen) == 0 {
+		return true
+	}
+	for _, t := range e.Themen {
+		for _, thema := range themen {
+			if t == thema {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Enqueue store a pending Delivery for every active endpoint interested in the kind and topics of the event
+func Enqueue(app *application.AppContext, event db.ChangeEvent) error {
+
+	endpoints, err := cachedEndpoints(app)
+	if err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	payload := Payload{
+		Id:     common.Md5HashStr(fmt.Sprintf("%s|%s|%d", event.Action, event.Key.String(), event.Time.UnixNano())),
+		Action: event.Action,
+		Kind:   event.Kind,
+		Name:   event.Name,
+		Key:    event.Key.Encode(),
+		Time:   event.Time,
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error creating webhook payload for %s", event.Key.String()))
+	}
+
+	themen := db.GetThemen(event.Entity)
+	var keys []*datastore.Key
+	var deliveries []*Delivery
+	for _, endpoint := range endpoints {
+		if !endpoint.accepts(event.Kind) || !endpoint.acceptsThemen(themen) {
+			continue
+		}
+		keys = append(keys, datastore.IncompleteKey(app.Config.GetEntityWebhookDelivery(), endpoint.Key))
+		deliveries = append(deliveries, &Delivery{
+			EventId:     payload.Id,
+			Kind:        event.Kind,
+			Action:      event.Action,
+			Payload:     body,
+			Status:      StatusPending,
+			NextAttempt: event.Time,
+			Created:     time.Now(),
+		})
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err = app.Db().PutMulti(app.Ctx(), keys, deliveries)
+	if err != nil {
+		return errors.Wrap(err, fmt.Sprintf("error enqueue webhook deliveries for %s", event.Key.String()))
+	}
+
+	slog.Info("enqueued %d webhook deliveries for %s %s", len(keys), event.Action, event.Key.String())
+	return nil
+}